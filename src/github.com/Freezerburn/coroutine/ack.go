@@ -0,0 +1,104 @@
+package coroutine
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Identifies a message sent with SendAcked, for the coroutine to pass to Ack once it has dealt with it.
+type AckToken uint64
+
+// The token most recently given out by SendAcked, accessed atomically.
+var lastAckToken uint64
+
+// What a coroutine receives for a message sent with SendAcked.
+type Delivery struct {
+	Token AckToken
+	Value interface{}
+	// Set if the message is being delivered again by Restart, because it wasn't acknowledged before the coroutine
+	// stopped. Some or all of the work it asked for may already have been done.
+	Redelivered bool
+}
+
+// Sends v to the coroutine r references with at-least-once delivery, for coroutines doing work that can't safely be
+// lost, such as calls to an external system that isn't idempotent. The coroutine receives it as a Delivery, and calls
+// Ack with its token once it has finished with it. Until then the coroutine keeps hold of v, even after it stops, and
+// Restart delivers it again, marked as Redelivered. A message sent to a coroutine that has already stopped is
+// dead-lettered, the same as with Send.
+//
+// Anything that isn't a coroutine started by this package is sent the Delivery with its own Send, and nothing is kept.
+func SendAcked(r Ref, v interface{}) AckToken {
+	token := AckToken(atomic.AddUint64(&lastAckToken, 1))
+	d := Delivery{Token: token, Value: v}
+	er, ok := localRef(r)
+	if !ok {
+		r.Send(d)
+		return token
+	}
+
+	e := er.e
+	if e.isRunning() {
+		e.ackLock.Lock()
+		if e.unacked == nil {
+			e.unacked = make(map[AckToken]interface{})
+		}
+		e.unacked[token] = v
+		e.ackLock.Unlock()
+	}
+	e.deliver(mail{v: d})
+	return token
+}
+
+// Calls f with the token of every message sent with SendAcked once the coroutine acknowledges it, from the
+// coroutine's own goroutine, so whatever sent the message can tell when it's done with, such as to commit an offset.
+// f isn't called for a token that had already been acknowledged.
+func WithAckHandler(f func(token AckToken)) Option {
+	return func(e *Embeddable) {
+		e.onAck = f
+	}
+}
+
+// Implemented by Embeddable for coroutines that acknowledge what they were sent with SendAcked.
+type Acker interface {
+	Ack(token AckToken)
+}
+
+var _ Acker = (*Embeddable)(nil)
+
+// Acknowledges that the message sent with SendAcked that had the given token has been dealt with, so it won't be
+// delivered again. Does nothing for a token that has already been acknowledged.
+func (e *Embeddable) Ack(token AckToken) {
+	e.checkpoint()
+	e.ackLock.Lock()
+	_, pending := e.unacked[token]
+	delete(e.unacked, token)
+	e.ackLock.Unlock()
+	if pending && e.onAck != nil {
+		e.onAck(token)
+	}
+}
+
+// Whether the message sent with the given token is still waiting to be acknowledged.
+func (e *Embeddable) isUnacked(token AckToken) bool {
+	e.ackLock.Lock()
+	defer e.ackLock.Unlock()
+	_, ok := e.unacked[token]
+	return ok
+}
+
+// Delivers every message that still hasn't been acknowledged again, in the order they were first sent.
+func (e *Embeddable) redeliver() {
+	e.ackLock.Lock()
+	pending := make([]Delivery, 0, len(e.unacked))
+	for token, v := range e.unacked {
+		pending = append(pending, Delivery{Token: token, Value: v, Redelivered: true})
+	}
+	e.ackLock.Unlock()
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Token < pending[j].Token
+	})
+	for _, d := range pending {
+		e.deliver(mail{v: d})
+	}
+}
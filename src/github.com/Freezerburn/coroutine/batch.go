@@ -0,0 +1,75 @@
+package coroutine
+
+import (
+	"sync/atomic"
+)
+
+// Sends every one of vs to r, in order, as if by calling Send for each. For a coroutine started by this package they
+// all go into its mailbox at once, with a single atomic swap, and it's woken once, which is far cheaper than sending
+// them one at a time when a producer has a burst of thousands to hand over. Nothing sent from anywhere else can end up
+// in between them, unless the coroutine was given its own Mailbox with WithMailbox, which is handed them one by one.
+//
+// Anything that isn't a coroutine started by this package is sent each of vs with its own Send.
+func SendBatch(r Ref, vs ...interface{}) {
+	ref, ok := localRef(r)
+	if !ok {
+		for _, v := range vs {
+			r.Send(v)
+		}
+		return
+	}
+	ref.e.deliverBatch(vs)
+}
+
+// The same as deliver, for every one of vs at once.
+func (e *Embeddable) deliverBatch(vs []interface{}) {
+	if len(vs) == 0 {
+		return
+	}
+	if !e.isRunning() {
+		for _, v := range vs {
+			deadLetter(DeadLetter{Reason: DeadLetterStopped, Value: v, To: e.ref()})
+		}
+		return
+	}
+
+	for _, v := range vs {
+		e.trace(TraceSend, v, 0)
+	}
+	now := e.clock.Now()
+	var sender Ref
+	if e.recordSenders {
+		if from := callingCoroutine(); from != nil {
+			sender = from.ref()
+		}
+	}
+	var duplicates []mail
+	ms := make([]mail, 0, len(vs))
+	for _, v := range vs {
+		m := mail{v: v, at: now, from: sender}
+		if env, ok := v.(Envelope); ok && env.From != nil {
+			m.from = env.From
+		}
+		if e.duplicate(m) {
+			duplicates = append(duplicates, m)
+		} else {
+			ms = append(ms, m)
+		}
+	}
+	for _, m := range duplicates {
+		deadLetter(DeadLetter{Reason: DeadLetterDuplicate, Value: m.v, To: e.ref(), From: m.from})
+	}
+
+	atomic.AddUint64(&e.enqueued, uint64(len(ms)))
+	if q, ok := e.mailbox.(batchQueue); ok {
+		q.putAll(ms)
+	} else {
+		// A Mailbox from WithMailbox takes them one at a time, so it may let others in between.
+		for _, m := range ms {
+			if err := e.mailbox.put(m); err != nil {
+				e.rejected(m, err)
+			}
+		}
+	}
+	e.notify()
+}
@@ -0,0 +1,70 @@
+package coroutine
+
+// Handles a single message for a coroutine started with StartBehavior. A coroutine that works through a protocol can
+// call Become with a different Behavior for each state it can be in, instead of switching on its state in one big
+// handler.
+type Behavior func(c Coroutine, v interface{})
+
+func StartBehavior(b Behavior, opts ...Option) Ref {
+	return StartBehaviorName(defaultName, b, opts...)
+}
+
+// Starts a coroutine with the given name that hands every message it receives to its current Behavior, starting with
+// b. The coroutine returns once Unbecome has removed every Behavior, including b.
+func StartBehaviorName(name string, b Behavior, opts ...Option) Ref {
+	return StartFuncName(name, func(c Coroutine) {
+		e := c.(*Embeddable)
+		x := e.extra()
+		// Set up directly rather than with Become, so that nothing runs before b is in place.
+		x.behaviors = []Behavior{b}
+		x.replacer = func(handler interface{}) error {
+			next, ok := handler.(Behavior)
+			if !ok {
+				f, isFunc := handler.(func(c Coroutine, v interface{}))
+				if !isFunc {
+					return ErrNotReplaceable
+				}
+				next = f
+			}
+			if len(x.behaviors) == 0 {
+				return ErrNotRunning
+			}
+			x.behaviors[len(x.behaviors)-1] = next
+			return nil
+		}
+		for len(x.behaviors) > 0 {
+			v := e.recvNext()
+			x.behaviors[len(x.behaviors)-1](e, v)
+		}
+	}, opts...)
+}
+
+// Implemented by Embeddable for coroutines started with StartBehavior, so that a Behavior can swap itself for another.
+type Becomer interface {
+	Become(b Behavior)
+	Unbecome()
+}
+
+var _ Becomer = (*Embeddable)(nil)
+
+// Makes b handle every message from the next one onwards, for a coroutine started with StartBehavior. The Behavior
+// being replaced is remembered, so Unbecome can go back to it.
+func (e *Embeddable) Become(b Behavior) {
+	e.checkpoint()
+	x := e.extra()
+	x.behaviors = append(x.behaviors, b)
+}
+
+// Goes back to the Behavior that was handling messages before the most recent Become. Once there's nothing to go
+// back to, a coroutine started with StartBehavior returns. Does nothing if there's no Behavior to remove.
+func (e *Embeddable) Unbecome() {
+	e.checkpoint()
+	x := e.ext.Load()
+	if x == nil {
+		return
+	}
+	if n := len(x.behaviors); n > 0 {
+		x.behaviors[n-1] = nil
+		x.behaviors = x.behaviors[:n-1]
+	}
+}
@@ -0,0 +1,214 @@
+package coroutine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Returned by a CircuitBreaker's Call and Ask while it's open, without anything being sent.
+var ErrCircuitOpen = errors.New("coroutine: circuit breaker is open")
+
+// Whether a CircuitBreaker is letting calls through.
+type BreakerState int
+
+const (
+	// Every call goes through. This is how a breaker starts.
+	BreakerClosed BreakerState = iota
+	// Calls fail straight away with ErrCircuitOpen until the cooldown has passed.
+	BreakerOpen
+	// The cooldown has passed, and a single trial call is let through to see whether things have recovered. Any
+	// other call made while it's in flight fails with ErrCircuitOpen.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// Wraps a Ref so that Call and Ask stop being sent to a coroutine that keeps failing, giving it, and whatever it
+// talks to, a chance to recover. A call fails if it returns an error, such as ErrCallTimeout, or if the reply is
+// itself an error. Once enough calls in a row have failed, the breaker opens and fails every call straight away with
+// ErrCircuitOpen. After the cooldown it half-opens and lets a single trial call through: if that succeeds the breaker
+// closes again, and if it fails the breaker opens for another cooldown.
+//
+// Everything else, including Send and the other functions taking a Ref, reaches the wrapped Ref straight away. Safe
+// for concurrent use.
+type CircuitBreaker struct {
+	Ref
+	failures int
+	cooldown time.Duration
+	clock    Clock
+
+	lock     sync.Mutex
+	state    BreakerState
+	failed   int
+	openedAt time.Time
+	trial    bool
+	onChange func(from, to BreakerState)
+	// Incremented by every change of state. Each call is admitted under the generation at the time, and its result
+	// only counts if the breaker is still in that generation once it arrives.
+	generation uint64
+}
+
+// Wraps r in a closed CircuitBreaker that opens after the given number of failed calls in a row, and stays open for
+// cooldown, measured on the Clock of the coroutine behind r, before trying again.
+func NewCircuitBreaker(r Ref, failures int, cooldown time.Duration) *CircuitBreaker {
+	if failures < 1 {
+		failures = 1
+	}
+	return &CircuitBreaker{Ref: r, failures: failures, cooldown: cooldown, clock: clockOf(r)}
+}
+
+func (b *CircuitBreaker) wrapped() Ref {
+	return b.Ref
+}
+
+// Sets a function to be called every time the breaker changes state. It's called from whichever goroutine caused the
+// change, after the change has been made, so it shouldn't block for long.
+func (b *CircuitBreaker) OnStateChange(f func(from, to BreakerState)) {
+	b.lock.Lock()
+	b.onChange = f
+	b.lock.Unlock()
+}
+
+// The breaker's state right now. An open breaker whose cooldown has passed reports itself as half-open.
+func (b *CircuitBreaker) State() BreakerState {
+	b.lock.Lock()
+	notify := b.cooledDown(b.clock.Now())
+	state := b.state
+	b.lock.Unlock()
+	notify()
+	return state
+}
+
+// Calls the wrapped Ref's Call, unless the breaker is open.
+func (b *CircuitBreaker) Call(v interface{}, timeout time.Duration) (interface{}, error) {
+	gen, ok := b.allow()
+	if !ok {
+		return nil, ErrCircuitOpen
+	}
+	reply, err := Call(b.Ref, v, timeout)
+	b.record(gen, reply, err)
+	return reply, err
+}
+
+// Calls the wrapped Ref's Ask, unless the breaker is open, in which case the Future is already resolved with
+// ErrCircuitOpen. The call counts as succeeding or failing once its Future resolves, unless it's cancelled.
+func (b *CircuitBreaker) Ask(v interface{}) *Future {
+	gen, ok := b.allow()
+	if !ok {
+		return Resolved(nil, ErrCircuitOpen)
+	}
+	f := Ask(b.Ref, v)
+	next := newFuture()
+	next.cancel = f.Cancel
+	next.clock = f.clock
+	go func() {
+		<-f.done
+		reply, err := f.Await(0)
+		b.record(gen, reply, err)
+		next.resolve(reply, err)
+	}()
+	return next
+}
+
+// Whether a call can go through right now, and the generation it goes through under. Claims the trial call if the
+// breaker is half-open.
+func (b *CircuitBreaker) allow() (uint64, bool) {
+	b.lock.Lock()
+	notify := b.cooledDown(b.clock.Now())
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		allowed = false
+	case BreakerHalfOpen:
+		if b.trial {
+			allowed = false
+		} else {
+			b.trial = true
+		}
+	}
+	gen := b.generation
+	b.lock.Unlock()
+	notify()
+	return gen, allowed
+}
+
+// Counts the result of a call that went through under the given generation. Results of calls admitted before the
+// breaker last changed state are ignored, so a slow call made while it was closed can't be taken for the trial call.
+func (b *CircuitBreaker) record(gen uint64, reply interface{}, err error) {
+	b.lock.Lock()
+	if gen != b.generation {
+		b.lock.Unlock()
+		return
+	}
+	if err == ErrCancelled {
+		b.trial = false
+		b.lock.Unlock()
+		return
+	}
+	_, replyErr := reply.(error)
+	failed := err != nil || replyErr
+
+	var notify func()
+	switch {
+	case b.state == BreakerHalfOpen && b.trial:
+		b.trial = false
+		if failed {
+			notify = b.change(BreakerOpen)
+		} else {
+			b.failed = 0
+			notify = b.change(BreakerClosed)
+		}
+	case b.state == BreakerClosed && failed:
+		b.failed++
+		if b.failed >= b.failures {
+			notify = b.change(BreakerOpen)
+		}
+	case b.state == BreakerClosed:
+		b.failed = 0
+	}
+	b.lock.Unlock()
+	if notify != nil {
+		notify()
+	}
+}
+
+// Half-opens the breaker if it's open and the cooldown has passed. Must be called with the lock held, and the
+// returned function called once it's released.
+func (b *CircuitBreaker) cooledDown(now time.Time) func() {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.cooldown {
+		return b.change(BreakerHalfOpen)
+	}
+	return func() {}
+}
+
+// Moves the breaker to a new state. Must be called with the lock held, and the returned function called once it's
+// released to let OnStateChange know.
+func (b *CircuitBreaker) change(to BreakerState) func() {
+	from := b.state
+	b.state = to
+	b.generation++
+	switch to {
+	case BreakerOpen:
+		b.openedAt = b.clock.Now()
+	case BreakerClosed:
+		b.failed = 0
+	}
+	onChange := b.onChange
+	return func() {
+		if onChange != nil && from != to {
+			onChange(from, to)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package coroutine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// A Ref whose Call is a function, for driving a CircuitBreaker directly.
+type callRef struct {
+	Ref
+	call func(v interface{}) (interface{}, error)
+}
+
+func (r callRef) Call(v interface{}, timeout time.Duration) (interface{}, error) {
+	return r.call(v)
+}
+
+func (r callRef) Ask(v interface{}) *Future {
+	return Resolved(r.call(v))
+}
+
+func TestCircuitBreakerIgnoresResultsFromEarlierStates(t *testing.T) {
+	started := make(chan string)
+	release := map[string]chan struct{}{
+		"slow":  make(chan struct{}),
+		"trial": make(chan struct{}),
+	}
+	b := NewCircuitBreaker(callRef{call: func(v interface{}) (interface{}, error) {
+		name := v.(string)
+		if name == "fail" {
+			return nil, errors.New("failed")
+		}
+		started <- name
+		<-release[name]
+		return "ok", nil
+	}}, 1, 10*time.Millisecond)
+
+	results := make(chan error, 2)
+	go func() {
+		_, err := b.Call("slow", 0)
+		results <- err
+	}()
+	<-started
+	if _, err := b.Call("fail", 0); err == nil {
+		t.Fatal("expected the failing call to fail")
+	}
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("expected the breaker to open, got %v", s)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		_, err := b.Call("trial", 0)
+		results <- err
+	}()
+	<-started
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("expected the breaker to half-open for the trial, got %v", s)
+	}
+
+	// Admitted while the breaker was closed, so it mustn't count as the trial succeeding.
+	close(release["slow"])
+	<-results
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("expected the slow call's result to be ignored, but the breaker is %v", s)
+	}
+
+	close(release["trial"])
+	<-results
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("expected the trial to close the breaker, got %v", s)
+	}
+}
+
+func TestCircuitBreakerCooldownFollowsClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	r := clockedCallRef{callRef{call: func(v interface{}) (interface{}, error) {
+		return nil, errors.New("failed")
+	}}, clock}
+	b := NewCircuitBreaker(r, 1, time.Minute)
+
+	b.Call("fail", 0)
+	clock.Advance(time.Minute - time.Second)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("expected the breaker to stay open until the clock passed the cooldown, got %v", s)
+	}
+	clock.Advance(time.Second)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("expected the breaker to half-open once the clock passed the cooldown, got %v", s)
+	}
+}
+
+// A callRef that waits on the given Clock.
+type clockedCallRef struct {
+	callRef
+	clock Clock
+}
+
+func (r clockedCallRef) refClock() Clock {
+	return r.clock
+}
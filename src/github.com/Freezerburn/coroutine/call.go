@@ -0,0 +1,182 @@
+package coroutine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Returned by Call when no reply arrived within the timeout, and by Future.Await when the Future wasn't resolved
+	// within the timeout.
+	ErrCallTimeout = errors.New("coroutine: call timed out")
+	// Returned by Call and Future when the coroutine stopped before replying, or had already stopped.
+	ErrNotRunning = errors.New("coroutine: not running")
+	// Returned by a Future that was cancelled before it was resolved.
+	ErrCancelled = errors.New("coroutine: cancelled")
+)
+
+// Implemented by Refs that can be asked for a reply, used by Call and Ask.
+type Caller interface {
+	Call(v interface{}, timeout time.Duration) (interface{}, error)
+	Ask(v interface{}) *Future
+}
+
+// Sends v to r and waits up to timeout for the reply, as the Call method on the Ref of a coroutine started by this
+// package does. Returns ErrUnsupported if r isn't a Caller.
+func Call(r Ref, v interface{}, timeout time.Duration) (interface{}, error) {
+	if c, ok := refAs[Caller](r); ok {
+		return c.Call(v, timeout)
+	}
+	return nil, ErrUnsupported
+}
+
+// Sends v to r and returns a Future for the reply, as the Ask method on the Ref of a coroutine started by this
+// package does. If r isn't a Caller, the Future is already resolved with ErrUnsupported.
+func Ask(r Ref, v interface{}) *Future {
+	if c, ok := refAs[Caller](r); ok {
+		return c.Ask(v)
+	}
+	return Resolved(nil, ErrUnsupported)
+}
+
+// A Future waiting for a reply, along with the coroutine that is expected to send it.
+type pendingCall struct {
+	f  *Future
+	to *Embeddable
+}
+
+// Every Future waiting for a reply, by correlation id.
+var (
+	calls     = make(map[uint64]pendingCall)
+	callsLock sync.Mutex
+	nextCall  uint64
+)
+
+// Sends v to the coroutine this references and returns straight away with a Future for the reply, which the
+// coroutine sends with Reply, or with ReplyTo on an Envelope. Each Ask gets its own correlation id, so the reply
+// can't be confused with any other message: if the caller is itself a coroutine, its mailbox is left alone. The
+// Future is resolved with ErrNotRunning if the coroutine stops without replying. A reply that arrives after the
+// Future was cancelled goes to dead letters.
+//
+// Useful for sending several requests at once and then waiting for them all with All.
+func (r *embeddableRef) Ask(v interface{}) *Future {
+	return r.ask(v, nil)
+}
+
+// Ask, with the message carrying ctx for Context to return.
+func (r *embeddableRef) ask(v interface{}, ctx context.Context) *Future {
+	e := r.e
+	f := newFuture()
+	f.clock = e.clock
+	id := atomic.AddUint64(&nextCall, 1)
+
+	if r.stale() {
+		deadLetter(DeadLetter{Reason: DeadLetterStopped, Value: v, To: r})
+		f.resolve(nil, ErrNotRunning)
+		return f
+	}
+	e.callLock.Lock()
+	if e.callsClosed {
+		e.callLock.Unlock()
+		deadLetter(DeadLetter{Reason: DeadLetterStopped, Value: v, To: r})
+		f.resolve(nil, ErrNotRunning)
+		return f
+	}
+	if e.calls == nil {
+		e.calls = make(map[uint64]struct{})
+	}
+	e.calls[id] = struct{}{}
+	e.callLock.Unlock()
+
+	callsLock.Lock()
+	calls[id] = pendingCall{f: f, to: e}
+	callsLock.Unlock()
+	f.cancel = func() {
+		takeCall(id)
+	}
+
+	e.deliver(mail{v: v, call: id, ctx: ctx})
+	return f
+}
+
+// Sends v to the coroutine this references and waits up to timeout for it to reply, in the same way as Ask. A
+// timeout <= 0 waits for as long as it takes. If the timeout passes, the call is cancelled and ErrCallTimeout is
+// returned.
+//
+// Call blocks the calling goroutine outright rather than waiting the way Recv does. A coroutine run by a Scheduler
+// must not Call another coroutine under the same scheduler, since the callee can't run until the caller gives up.
+func (r *embeddableRef) Call(v interface{}, timeout time.Duration) (interface{}, error) {
+	f := r.Ask(v)
+	reply, err := f.Await(timeout)
+	if err == ErrCallTimeout {
+		f.Cancel()
+	}
+	return reply, err
+}
+
+// Removes the call with the given correlation id from everywhere it's tracked, returning its Future if it was still
+// waiting.
+func takeCall(id uint64) *Future {
+	callsLock.Lock()
+	call, ok := calls[id]
+	delete(calls, id)
+	callsLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	call.to.callLock.Lock()
+	delete(call.to.calls, id)
+	call.to.callLock.Unlock()
+	return call.f
+}
+
+// Resolves every call still waiting on this coroutine with ErrNotRunning, and makes sure no more can start. Called
+// once the coroutine has stopped running.
+func (e *Embeddable) failCalls() {
+	e.callLock.Lock()
+	e.callsClosed = true
+	ids := e.calls
+	e.calls = nil
+	e.callLock.Unlock()
+
+	for id := range ids {
+		if f := takeCall(id); f != nil {
+			f.resolve(nil, ErrNotRunning)
+		}
+	}
+}
+
+// Implemented by Embeddable for coroutines that answer the messages they receive, whether sent with Call or Ask or
+// carrying an Envelope.
+type Replier interface {
+	Sender() Ref
+	Reply(v interface{}) bool
+	ReplyTo(env Envelope, v interface{}) bool
+}
+
+var _ Replier = (*Embeddable)(nil)
+
+// Replies to the message this coroutine most recently received. If it came from Call or Ask, the reply goes straight
+// back to the waiting caller; otherwise it's sent to the message's Sender, if that's known. Returns false if there
+// was nobody to reply to, or the caller had already given up waiting, in which case the reply goes to dead letters.
+func (e *Embeddable) Reply(v interface{}) bool {
+	e.checkpoint()
+	if e.call != 0 {
+		return e.replyToCall(e.call, v)
+	}
+	return e.ReplyTo(Envelope{From: e.sender}, v)
+}
+
+// Resolves the Future waiting on the given correlation id, if it's still waiting.
+func (e *Embeddable) replyToCall(id uint64, v interface{}) bool {
+	f := takeCall(id)
+	if f == nil || !f.resolve(v, nil) {
+		deadLetter(DeadLetter{Reason: DeadLetterStaleReply, Value: v, From: e.ref()})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,362 @@
+package coroutine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// The source of time for everything a coroutine waits on: Pause, RecvFor and WaitFor, along with the timers behind
+// After, Tick, SendAfter, SendEvery, Ping and the timeouts of Call and Ask. Replacing it lets tests control time
+// instead of sleeping for real.
+type Clock interface {
+	Now() time.Time
+	// Creates a timer that fires once d has passed.
+	NewTimer(d time.Duration) Timer
+}
+
+// A single timer created by a Clock. Only one goroutine will wait on a Timer at any given time.
+type Timer interface {
+	// Receives the time once the timer fires.
+	Chan() <-chan time.Time
+	// Sets the timer to fire once d has passed, replacing anything it was previously set to. A fire from a previous
+	// use that was never received must be discarded.
+	Reset(d time.Duration)
+	// Prevents the timer from firing. Returns false if it already fired or wasn't set.
+	Stop() bool
+}
+
+// The Clock used by default, backed by real time and the timer goroutine shared between every coroutine.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	t := newSharedTimer()
+	t.Reset(d)
+	return t
+}
+
+var (
+	clock     Clock = realClock{}
+	clockLock sync.Mutex
+)
+
+// Sets the Clock used by every coroutine started after this call, unless it is given its own with WithClock.
+// Passing nil goes back to using real time.
+func SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	clockLock.Lock()
+	clock = c
+	clockLock.Unlock()
+}
+
+func defaultClock() Clock {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	return clock
+}
+
+// Implemented by Refs that know which Clock the coroutines behind them wait on.
+type clockedRef interface {
+	refClock() Clock
+}
+
+// The Clock that the coroutines behind r wait on, or the default Clock if r doesn't know.
+func clockOf(r Ref) Clock {
+	if c, ok := refAs[clockedRef](r); ok {
+		return c.refClock()
+	}
+	return defaultClock()
+}
+
+// Implemented by Clocks that can call a function themselves when a timer fires, such as FakeClock, which calls it
+// from Advance or Set so that whatever it does has been done by the time they return.
+type callbackClock interface {
+	Clock
+	// Creates a timer that calls f when it fires, rather than sending on its channel. It isn't set until Reset.
+	newCallbackTimer(f func()) Timer
+}
+
+// Calls f once d has passed on c, unless the returned CancelFunc is called or done is closed first. Used in place of
+// time.AfterFunc so that delayed sends follow the same Clock as everything else.
+func afterFunc(c Clock, d time.Duration, done <-chan struct{}, f func()) CancelFunc {
+	if cc, ok := c.(callbackClock); ok {
+		finished := make(chan struct{})
+		finish := closeOnce(finished)
+		t := cc.newCallbackTimer(func() {
+			finish()
+			if !isClosed(done) {
+				f()
+			}
+		})
+		stopTimerWhenDone(t, done, finished)
+		t.Reset(d)
+		return func() {
+			t.Stop()
+			finish()
+		}
+	}
+
+	t := c.NewTimer(d)
+	cancel := make(chan struct{})
+	go func() {
+		defer t.Stop()
+		select {
+		case <-t.Chan():
+			f()
+		case <-cancel:
+		case <-done:
+		}
+	}()
+	return closeOnce(cancel)
+}
+
+// Calls f every time interval passes on c, until the returned CancelFunc is called, done is closed or f returns
+// false. Used in place of time.Ticker, and like it, skips ticks rather than letting them pile up if f is slow.
+func everyFunc(c Clock, interval time.Duration, done <-chan struct{}, f func() bool) CancelFunc {
+	next := c.Now().Add(interval)
+	if cc, ok := c.(callbackClock); ok {
+		// Every tick is delivered, since the callback runs each time the clock passes one.
+		finished := make(chan struct{})
+		finish := closeOnce(finished)
+		var t Timer
+		t = cc.newCallbackTimer(func() {
+			if isClosed(done) || isClosed(finished) || !f() {
+				finish()
+				return
+			}
+			now := c.Now()
+			for !next.After(now) {
+				next = next.Add(interval)
+			}
+			t.Reset(next.Sub(now))
+		})
+		stopTimerWhenDone(t, done, finished)
+		t.Reset(interval)
+		return func() {
+			finish()
+			t.Stop()
+		}
+	}
+
+	t := c.NewTimer(interval)
+	cancel := make(chan struct{})
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-t.Chan():
+			case <-cancel:
+				return
+			case <-done:
+				return
+			}
+			if !f() {
+				return
+			}
+			now := c.Now()
+			for !next.After(now) {
+				next = next.Add(interval)
+			}
+			t.Reset(next.Sub(now))
+		}
+	}()
+	return closeOnce(cancel)
+}
+
+// Stops t once done is closed, unless finished is closed first, so a timer for a coroutine that has stopped isn't left
+// waiting to fire.
+func stopTimerWhenDone(t Timer, done <-chan struct{}, finished chan struct{}) {
+	if done == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-done:
+			t.Stop()
+		case <-finished:
+		}
+	}()
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// A CancelFunc that closes c the first time it's called.
+func closeOnce(c chan struct{}) CancelFunc {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(c)
+		})
+	}
+}
+
+// A Clock that only moves when told to, for tests. Timers fire during calls to Advance or Set, in the order they
+// were due, from the goroutine making the call. Messages from After, Tick, SendAfter and SendEvery are sent by that
+// goroutine too, so they're in the mailbox by the time Advance or Set returns.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	pending []*fakeTimer
+	// Signalled whenever a timer is set, so BlockUntil can wait for coroutines to start waiting.
+	changed *sync.Cond
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	c     chan time.Time
+	when  time.Time
+	set   bool
+	// Called instead of sending on c, for timers from newCallbackTimer.
+	fn func()
+}
+
+// Creates a FakeClock starting at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.changed = sync.NewCond(&c.lock)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{
+		clock: c,
+		c:     make(chan time.Time, 1),
+	}
+	t.Reset(d)
+	return t
+}
+
+func (c *FakeClock) newCallbackTimer(f func()) Timer {
+	return &fakeTimer{clock: c, c: make(chan time.Time, 1), fn: f}
+}
+
+// Moves the clock forward by d, firing every timer that becomes due along the way.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	now := c.now.Add(d)
+	c.lock.Unlock()
+	c.Set(now)
+}
+
+// Moves the clock to the given time, firing every timer that becomes due along the way. Moving backwards doesn't
+// fire anything.
+func (c *FakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for {
+		// Sorted every time around, since a callback can set timers of its own.
+		sort.SliceStable(c.pending, func(i, j int) bool {
+			return c.pending[i].when.Before(c.pending[j].when)
+		})
+		if len(c.pending) == 0 || c.pending[0].when.After(now) {
+			break
+		}
+		t := c.pending[0]
+		c.pending = c.pending[1:]
+		t.set = false
+		if t.fn != nil {
+			// Called without the lock, at the time it was due, so that it can use the clock itself.
+			if t.when.After(c.now) {
+				c.now = t.when
+			}
+			c.lock.Unlock()
+			t.fn()
+			c.lock.Lock()
+			continue
+		}
+		// Time is reported as when the timer was due, so that code measuring elapsed time sees exact durations.
+		select {
+		case t.c <- t.when:
+		default:
+		}
+	}
+	c.now = now
+}
+
+// The number of timers that are currently set and waiting to fire.
+func (c *FakeClock) Waiters() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.pending)
+}
+
+// Blocks until at least n timers are set and waiting to fire. Useful for waiting until coroutines have reached
+// a Pause or RecvFor before calling Advance.
+func (c *FakeClock) BlockUntil(n int) {
+	c.lock.Lock()
+	for len(c.pending) < n {
+		c.changed.Wait()
+	}
+	c.lock.Unlock()
+}
+
+func (t *fakeTimer) Chan() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Reset(d time.Duration) {
+	c := t.clock
+	c.lock.Lock()
+	c.remove(t)
+	select {
+	case <-t.c:
+	default:
+	}
+
+	t.when = c.now.Add(d)
+	if d <= 0 {
+		if t.fn == nil {
+			t.c <- t.when
+			c.lock.Unlock()
+			return
+		}
+		c.lock.Unlock()
+		t.fn()
+		return
+	}
+	t.set = true
+	c.pending = append(c.pending, t)
+	c.changed.Broadcast()
+	c.lock.Unlock()
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.remove(t)
+}
+
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	if !t.set {
+		return false
+	}
+	t.set = false
+	for i, p := range c.pending {
+		if p == t {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+	return true
+}
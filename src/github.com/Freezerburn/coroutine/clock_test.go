@@ -0,0 +1,83 @@
+package coroutine
+
+import (
+	"testing"
+	"time"
+)
+
+// Starts a coroutine on clock that forwards everything it receives to the returned channel.
+func forwarding(clock Clock) (Ref, chan interface{}) {
+	received := make(chan interface{}, 10)
+	r := StartFunc(func(c Coroutine) {
+		for {
+			received <- c.Recv()
+		}
+	}, WithClock(clock))
+	return r, received
+}
+
+func TestSendAfterFollowsClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	r, received := forwarding(clock)
+	defer r.Stop()
+
+	SendAfter(r, "later", time.Minute)
+	clock.BlockUntil(1)
+	select {
+	case v := <-received:
+		t.Fatalf("expected nothing before the clock moved, got %v", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case v := <-received:
+		if v != "later" {
+			t.Fatalf("expected the delayed message, got %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message once the clock reached its delay")
+	}
+}
+
+func TestSendEveryFollowsClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	r, received := forwarding(clock)
+	defer r.Stop()
+
+	cancel := SendEvery(r, "tick", time.Second)
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("expected tick %d once the clock moved on", i+1)
+		}
+	}
+
+	cancel()
+	clock.Advance(time.Second)
+	select {
+	case v := <-received:
+		t.Fatalf("expected no ticks after cancelling, got %v", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestCallTimeoutFollowsClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	r, _ := forwarding(clock)
+	defer r.Stop()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := Call(r, "never answered", time.Minute)
+		result <- err
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	if err := <-result; err != ErrCallTimeout {
+		t.Fatalf("expected the call to time out once the clock passed the timeout, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package coroutine
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Returned for messages and Calls to a node that a Cluster has found to be down, including Calls that were waiting
+// for a reply from it when it was found to be.
+var ErrNodeDown = errors.New("coroutine: node is down")
+
+// The message a coroutine watching a Cluster receives when a node in it stops answering.
+type NodeDown struct {
+	Node string
+}
+
+// The message a coroutine watching a Cluster receives when a node that was down answers again.
+type NodeUp struct {
+	Node string
+}
+
+// Keeps track of which of a fixed list of nodes, each a Listener in another process, are answering, by pinging every
+// one of them every interval over the same connections LookupRemote uses. A node that hasn't answered for timeout is
+// down: coroutines watching the Cluster receive a NodeDown, remote Calls still waiting on it fail with ErrNodeDown,
+// and so does everything sent to it through a Ref from LookupRemote until it answers again, at which point they
+// receive a NodeUp. Every node starts out up. Safe for concurrent use.
+type Cluster struct {
+	interval time.Duration
+	timeout  time.Duration
+
+	lock     sync.Mutex
+	nodes    map[string]*clusterNode
+	watchers map[Ref]struct{}
+	closed   bool
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+type clusterNode struct {
+	pool     *remotePool
+	up       bool
+	lastSeen time.Time
+	// Set while a ping is on its way, so a slow node doesn't pile them up.
+	pinging bool
+}
+
+// Identifies a watch when it's registered to be cleaned up once its coroutine finishes.
+type clusterWatch struct {
+	c *Cluster
+}
+
+// Starts keeping track of the given nodes, written as for LookupRemote, pinging each every interval and counting it as
+// down once it hasn't answered for timeout, which should be a few intervals so one slow answer isn't taken for a
+// failure. The list usually leaves out the node for this process.
+func NewCluster(nodes []string, interval, timeout time.Duration) *Cluster {
+	c := &Cluster{
+		interval: interval,
+		timeout:  timeout,
+		nodes:    make(map[string]*clusterNode, len(nodes)),
+		watchers: make(map[Ref]struct{}),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	now := time.Now()
+	for _, node := range nodes {
+		c.nodes[node] = &clusterNode{pool: poolFor(node), up: true, lastSeen: now}
+	}
+	go c.run()
+	return c
+}
+
+func (c *Cluster) run() {
+	defer close(c.stopped)
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.check()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Pings every node that isn't still being pinged, and marks down any that has gone too long without answering.
+func (c *Cluster) check() {
+	now := time.Now()
+	var notify []interface{}
+	c.lock.Lock()
+	for name, n := range c.nodes {
+		if !n.pinging {
+			n.pinging = true
+			go c.ping(name, n)
+		}
+		if n.up && now.Sub(n.lastSeen) > c.timeout {
+			n.up = false
+			n.pool.setDown(true)
+			notify = append(notify, NodeDown{Node: name})
+		}
+	}
+	c.lock.Unlock()
+	c.notify(notify)
+}
+
+func (c *Cluster) ping(name string, n *clusterNode) {
+	node, err := n.pool.probe()
+	if err == nil {
+		f := node.request(remoteFrame{Kind: remotePing})
+		if _, err = f.Await(c.timeout); err != nil {
+			f.Cancel()
+		}
+	}
+
+	var notify []interface{}
+	c.lock.Lock()
+	n.pinging = false
+	if err == nil && !c.closed {
+		n.lastSeen = time.Now()
+		if !n.up {
+			n.up = true
+			n.pool.setDown(false)
+			notify = append(notify, NodeUp{Node: name})
+		}
+	}
+	c.lock.Unlock()
+	c.notify(notify)
+}
+
+func (c *Cluster) notify(events []interface{}) {
+	if len(events) == 0 {
+		return
+	}
+	c.lock.Lock()
+	watchers := make([]Ref, 0, len(c.watchers))
+	for r := range c.watchers {
+		watchers = append(watchers, r)
+	}
+	c.lock.Unlock()
+	for _, event := range events {
+		for _, r := range watchers {
+			r.Send(event)
+		}
+	}
+}
+
+// Makes the referenced coroutine receive a NodeDown or NodeUp every time a node goes down or comes back. Coroutines
+// started by this package stop watching automatically when they finish; any other Ref keeps watching until Unwatch is
+// called.
+func (c *Cluster) Watch(r Ref) {
+	c.lock.Lock()
+	c.watchers[r] = struct{}{}
+	c.lock.Unlock()
+
+	if ref, ok := r.(*embeddableRef); ok {
+		registered := ref.onExit(clusterWatch{c}, func() {
+			c.remove(r)
+		})
+		if !registered {
+			c.remove(r)
+		}
+	}
+}
+
+// Stops the referenced coroutine receiving NodeDown and NodeUp messages.
+func (c *Cluster) Unwatch(r Ref) {
+	if ref, ok := r.(*embeddableRef); ok {
+		ref.cancelOnExit(clusterWatch{c})
+	}
+	c.remove(r)
+}
+
+func (c *Cluster) remove(r Ref) {
+	c.lock.Lock()
+	delete(c.watchers, r)
+	c.lock.Unlock()
+}
+
+// Whether the node is up. False for a node that isn't in the Cluster.
+func (c *Cluster) Up(node string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n := c.nodes[node]
+	return n != nil && n.up
+}
+
+// The nodes that are up, sorted.
+func (c *Cluster) Nodes() []string {
+	c.lock.Lock()
+	nodes := make([]string, 0, len(c.nodes))
+	for name, n := range c.nodes {
+		if n.up {
+			nodes = append(nodes, name)
+		}
+	}
+	c.lock.Unlock()
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Stops pinging the nodes. Those it had found to be down are no longer treated as down.
+func (c *Cluster) Close() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.stop)
+	for _, n := range c.nodes {
+		if !n.up {
+			n.up = true
+			n.pool.setDown(false)
+		}
+	}
+	c.lock.Unlock()
+	<-c.stopped
+}
@@ -0,0 +1,246 @@
+package coroutine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Turns the values of messages, events and snapshots into bytes and back, for everything that keeps them outside the
+// program: DiskMailbox, RedisMailbox, FileJournal, FileSnapshotStore and remoting. Set with SetCodec. Decode must
+// give back a value of the same concrete type that was encoded, which for most formats means looking the type up by
+// the name it was given with RegisterType. Must be safe for concurrent use.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// Returned by codecs that need to know a value's type by name when it was never given to RegisterType, either when
+// encoding it or when decoding data that names it.
+var ErrUnregisteredType = errors.New("coroutine: type not registered with RegisterType")
+
+// Every type given to RegisterType, in both directions. The basic types are registered already, under their Go names.
+var (
+	typesLock sync.RWMutex
+	typeNames = make(map[reflect.Type]string)
+	namedType = make(map[string]reflect.Type)
+)
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", []byte(nil), 0, int8(0), int16(0), int32(0), int64(0), uint(0), uint8(0), uint16(0), uint32(0),
+		uint64(0), float32(0), float64(0),
+	} {
+		t := reflect.TypeOf(v)
+		typeNames[t] = t.String()
+		namedType[t.String()] = t
+	}
+}
+
+// Gives the type of v a name, which codecs write alongside each value of that type so they know what to decode it
+// as. The name has to be the same in every process that reads what another writes, and should stay the same for as
+// long as anything written with it is kept, so package paths make poor names. A pointer type is registered separately
+// from the type it points to, and the name is given to gob.RegisterName as well, so GobCodec uses it too, which means
+// the type mustn't also be registered with gob.Register. Registering a name twice panics, as does registering a type
+// twice under different names.
+func RegisterType(name string, v interface{}) {
+	t := reflect.TypeOf(v)
+	typesLock.Lock()
+	defer typesLock.Unlock()
+	if existing, ok := namedType[name]; ok && existing != t {
+		panic(fmt.Sprintf("coroutine: %q is already registered for %v", name, existing))
+	}
+	if existing, ok := typeNames[t]; ok && existing != name {
+		panic(fmt.Sprintf("coroutine: %v is already registered as %q", t, existing))
+	}
+	typeNames[t] = name
+	namedType[name] = t
+	gob.RegisterName(name, v)
+}
+
+// The name v's type was registered with, or "" for nil.
+func typeName(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	typesLock.RLock()
+	name, ok := typeNames[reflect.TypeOf(v)]
+	typesLock.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %T", ErrUnregisteredType, v)
+	}
+	return name, nil
+}
+
+// A pointer to a new zero value of the type registered as name, ready to be decoded into.
+func newNamed(name string) (reflect.Value, error) {
+	typesLock.RLock()
+	t, ok := namedType[name]
+	typesLock.RUnlock()
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%w: %q", ErrUnregisteredType, name)
+	}
+	return reflect.New(t), nil
+}
+
+// Encodes values with encoding/gob, which is the Codec used unless SetCodec is called. Gob records the type of each
+// value itself, so types only need registering, with either RegisterType or gob.Register, and every exported field
+// is kept.
+type GobCodec struct{}
+
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, fmt.Errorf("coroutine: can't encode %T: %w", v, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Encodes values with encoding/json, as an object holding the name the value's type was given with RegisterType and
+// the value itself, so that what's written can be read by people, and by programs not written in Go. Every type sent
+// must be registered with RegisterType.
+type JSONCodec struct{}
+
+type jsonValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	name, err := typeName(v)
+	if err != nil {
+		return nil, err
+	}
+	jv := jsonValue{Type: name}
+	if v != nil {
+		if jv.Value, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("coroutine: can't encode %T: %w", v, err)
+		}
+	}
+	return json.Marshal(jv)
+}
+
+func (JSONCodec) Decode(data []byte) (interface{}, error) {
+	var jv jsonValue
+	if err := json.Unmarshal(data, &jv); err != nil {
+		return nil, err
+	}
+	if jv.Type == "" {
+		return nil, nil
+	}
+	p, err := newNamed(jv.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(jv.Value, p.Interface()); err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
+
+// Encodes values with Protocol Buffers, or any other format that only knows how to encode a value whose type the
+// reader already knows, by writing the name the value's type was given with RegisterType ahead of it. The package
+// doesn't depend on a protobuf library, so Marshal and Unmarshal come from whichever one the program uses. With
+// google.golang.org/protobuf, register pointers to the generated message types, and use
+//
+//	coroutine.ProtobufCodec{
+//		Marshal: func(v interface{}) ([]byte, error) { return proto.Marshal(v.(proto.Message)) },
+//		Unmarshal: func(data []byte, v interface{}) error { return proto.Unmarshal(data, v.(proto.Message)) },
+//	}
+//
+// Unmarshal is given a new value of the registered type to decode into: a pointer to a zero value when a struct was
+// registered, or a new zero message when a pointer was.
+type ProtobufCodec struct {
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+func (c ProtobufCodec) Encode(v interface{}) ([]byte, error) {
+	name, err := typeName(v)
+	if err != nil {
+		return nil, err
+	}
+	data := binary.AppendUvarint(nil, uint64(len(name)))
+	data = append(data, name...)
+	if v == nil {
+		return data, nil
+	}
+	payload, err := c.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("coroutine: can't encode %T: %w", v, err)
+	}
+	return append(data, payload...), nil
+}
+
+func (c ProtobufCodec) Decode(data []byte) (interface{}, error) {
+	n, read := binary.Uvarint(data)
+	if read <= 0 || uint64(len(data)-read) < n {
+		return nil, errors.New("coroutine: data too short for a type name")
+	}
+	name := string(data[read : read+int(n)])
+	payload := data[read+int(n):]
+	if name == "" {
+		return nil, nil
+	}
+	p, err := newNamed(name)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.Type().Elem(); t.Kind() == reflect.Pointer {
+		// A pointer type was registered, such as a generated message, which wants a value of its own to fill in.
+		p.Elem().Set(reflect.New(t.Elem()))
+		if err := c.Unmarshal(payload, p.Elem().Interface()); err != nil {
+			return nil, err
+		}
+	} else if err := c.Unmarshal(payload, p.Interface()); err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
+
+// Wraps the Codec so that atomic.Value always stores the same concrete type.
+type codecHolder struct {
+	c Codec
+}
+
+var currentCodecValue atomic.Value
+
+// Sets the Codec used for everything the package keeps outside the program. Passing nil goes back to GobCodec. It
+// should be set once, before anything is written, and to the same Codec in every process reading what another writes,
+// since data written with one Codec can't be read with another.
+func SetCodec(c Codec) {
+	currentCodecValue.Store(codecHolder{c})
+}
+
+// The Codec set with SetCodec, or GobCodec if none has been, for packages that bridge coroutines to other systems
+// and want to encode values the same way.
+func CurrentCodec() Codec {
+	if holder, _ := currentCodecValue.Load().(codecHolder); holder.c != nil {
+		return holder.c
+	}
+	return GobCodec{}
+}
+
+// Encodes the value of a message, event or snapshot with the Codec set with SetCodec.
+func encodeValue(v interface{}) ([]byte, error) {
+	return CurrentCodec().Encode(v)
+}
+
+// Decodes a value encoded with encodeValue.
+func decodeValue(b []byte) (interface{}, error) {
+	return CurrentCodec().Decode(b)
+}
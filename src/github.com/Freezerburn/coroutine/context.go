@@ -0,0 +1,67 @@
+package coroutine
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Hooks for plugging a distributed tracing system, such as OpenTelemetry, into message passing. Set with
+// SetSpanHooks; they only apply to messages sent with SendContext.
+//
+// An OpenTelemetry implementation starts a producer span in StartSend and ends it straight away, then in
+// StartProcess starts a consumer span linked to the span context found in ctx, returning its End method.
+type SpanHooks interface {
+	// Called by SendContext before the message is delivered. The returned context is carried with the message.
+	StartSend(ctx context.Context, to Ref, v interface{}) context.Context
+	// Called when a coroutine receives a message sent with SendContext, with the context carried with it. The
+	// returned context is what the coroutine's Context method returns while it handles the message, and end is
+	// called once it's done: when it next receives a message, or when it finishes.
+	StartProcess(ctx context.Context, id uint64, name string, v interface{}) (processCtx context.Context, end func())
+}
+
+// Wraps the SpanHooks so that atomic.Value always stores the same concrete type.
+type spanHooksHolder struct {
+	h SpanHooks
+}
+
+var spanHooks atomic.Value
+
+// Sets the hooks used to trace messages sent with SendContext. Passing nil turns them off, which is the default.
+func SetSpanHooks(h SpanHooks) {
+	spanHooks.Store(spanHooksHolder{h})
+}
+
+func currentSpanHooks() SpanHooks {
+	holder, _ := spanHooks.Load().(spanHooksHolder)
+	return holder.h
+}
+
+// The context carried by the message this coroutine most recently received, if it was sent with SendContext.
+// Otherwise, context.Background.
+func (e *Embeddable) Context() context.Context {
+	e.checkpoint()
+	if e.msgCtx == nil {
+		return context.Background()
+	}
+	return e.msgCtx
+}
+
+// Called as each message is received, to finish with the previous message and start on the next.
+func (e *Embeddable) startProcessing(m mail) {
+	e.finishProcessing()
+	e.msgCtx = m.ctx
+	if m.ctx == nil {
+		return
+	}
+	if h := currentSpanHooks(); h != nil {
+		e.msgCtx, e.endProcessing = h.StartProcess(m.ctx, e.id, e.name, m.v)
+	}
+}
+
+func (e *Embeddable) finishProcessing() {
+	if e.endProcessing != nil {
+		e.endProcessing()
+		e.endProcessing = nil
+	}
+	e.msgCtx = nil
+}
@@ -0,0 +1,489 @@
+package coroutinetest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Freezerburn/coroutine"
+)
+
+// A fake coroutine.Coroutine for unit testing a coroutine.Function directly, with no goroutines or timers. The
+// messages it receives are queued up front with Deliver. Once they run out, the next Recv stops the function as if it
+// had been stopped through its Ref, so Run always returns. Time only moves when the function pauses or waits. It
+// implements every optional interface an Embeddable does, such as coroutine.Replier and coroutine.Linker, so a
+// function under test can make the same type assertions on it.
+type Coroutine struct {
+	lock      sync.Mutex
+	mailbox   []delivered
+	sender    coroutine.Ref
+	now       time.Time
+	paused    []time.Duration
+	scheduled []*Scheduled
+	stopped   bool
+	logger    *slog.Logger
+	replies   []interface{}
+	acked     []coroutine.AckToken
+	granted   int
+	links     []coroutine.Ref
+	monitors  []coroutine.Ref
+	behaviors []coroutine.Behavior
+	ticks     int
+	yields    int
+	transfers []Transfer
+}
+
+var (
+	_ coroutine.Coroutine     = (*Coroutine)(nil)
+	_ coroutine.Waiter        = (*Coroutine)(nil)
+	_ coroutine.FrameWaiter   = (*Coroutine)(nil)
+	_ coroutine.Interruptible = (*Coroutine)(nil)
+	_ coroutine.Transferer    = (*Coroutine)(nil)
+	_ coroutine.Becomer       = (*Coroutine)(nil)
+	_ coroutine.Replier       = (*Coroutine)(nil)
+	_ coroutine.Acker         = (*Coroutine)(nil)
+	_ coroutine.Granter       = (*Coroutine)(nil)
+	_ coroutine.Linker        = (*Coroutine)(nil)
+)
+
+// A call to Transfer on the fake.
+type Transfer struct {
+	To    coroutine.Ref
+	Value interface{}
+}
+
+// A message waiting in the fake's mailbox, along with who sent it.
+type delivered struct {
+	v    interface{}
+	from coroutine.Ref
+}
+
+// Creates a fake coroutine with the given messages already in its mailbox. Its time starts at the Unix epoch.
+func NewCoroutine(msgs ...interface{}) *Coroutine {
+	c := &Coroutine{now: time.Unix(0, 0).UTC()}
+	c.Deliver(msgs...)
+	return c
+}
+
+// Adds messages to the end of the mailbox.
+func (c *Coroutine) Deliver(msgs ...interface{}) {
+	c.DeliverFrom(nil, msgs...)
+}
+
+// Adds messages to the end of the mailbox, as if they were sent by from. Sender returns from while they're being
+// handled, which is useful along with a Ref from this package for checking replies.
+func (c *Coroutine) DeliverFrom(from coroutine.Ref, msgs ...interface{}) {
+	c.lock.Lock()
+	for _, v := range msgs {
+		c.mailbox = append(c.mailbox, delivered{v: v, from: from})
+	}
+	c.lock.Unlock()
+}
+
+// Runs f against this fake in the calling goroutine until it returns or stops. Returns true if it stopped, either by
+// calling Stop or by trying to Recv when no messages were left. Any other panic is passed on.
+func (c *Coroutine) Run(f coroutine.Function) (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(coroutine.Stop); !ok {
+				panic(r)
+			}
+			stopped = true
+		}
+	}()
+	f(c)
+	return false
+}
+
+func (c *Coroutine) Pause(d time.Duration) {
+	c.lock.Lock()
+	c.paused = append(c.paused, d)
+	c.now = c.now.Add(d)
+	c.lock.Unlock()
+}
+
+// Counts the yield, for Yields. There's nothing else to run.
+func (c *Coroutine) Yield() {
+	c.lock.Lock()
+	c.yields++
+	c.lock.Unlock()
+}
+
+// How many times Yield has been called.
+func (c *Coroutine) Yields() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.yields
+}
+
+// Polls the same way a real coroutine does, so every poll shows up in Paused. Time only moves when the fake pauses,
+// so with a pollInterval <= 0 each poll waits out the rest of the timeout, rather than spinning without time passing.
+func (c *Coroutine) WaitFor(cond func() bool, pollInterval, timeout time.Duration) bool {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = c.Now().Add(timeout)
+	}
+	for {
+		if cond() {
+			return true
+		}
+
+		wait := pollInterval
+		if !deadline.IsZero() {
+			remaining := deadline.Sub(c.Now())
+			if remaining <= 0 {
+				return false
+			}
+			if wait <= 0 || remaining < wait {
+				wait = remaining
+			}
+		}
+		c.Pause(wait)
+	}
+}
+
+// Retries the same way a real coroutine does, so the waits between attempts show up in Paused.
+func (c *Coroutine) Retry(attempts int, backoff coroutine.Backoff, fn func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= attempts {
+			return err
+		}
+		if backoff != nil {
+			if d := backoff(attempt); d > 0 {
+				c.Pause(d)
+			}
+		}
+	}
+}
+
+// Counts the wait, for Ticks.
+func (c *Coroutine) WaitNextTick() {
+	c.lock.Lock()
+	c.ticks++
+	c.lock.Unlock()
+}
+
+// Counts the n waits, for Ticks.
+func (c *Coroutine) WaitFrames(n int) {
+	if n <= 0 {
+		return
+	}
+	c.lock.Lock()
+	c.ticks += n
+	c.lock.Unlock()
+}
+
+// Pauses for s seconds, so the wait shows up in Paused.
+func (c *Coroutine) WaitSeconds(s float64) {
+	c.Pause(time.Duration(s * float64(time.Second)))
+}
+
+// How many frames WaitNextTick and WaitFrames have waited for.
+func (c *Coroutine) Ticks() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ticks
+}
+
+// Calls f with Context. Nothing can stop the fake while f runs.
+func (c *Coroutine) Do(f func(ctx context.Context) error) error {
+	return f(c.Context())
+}
+
+// Does nothing, since nothing can stop the fake while it's blocked.
+func (c *Coroutine) Guard(closer io.Closer) (release func()) {
+	return func() {}
+}
+
+// Records the transfer, for Transfers, and returns the next message in the mailbox as what was handed back. Stops the
+// function once there are none left, the same as Recv.
+func (c *Coroutine) Transfer(to coroutine.Ref, v interface{}) (interface{}, error) {
+	c.lock.Lock()
+	c.transfers = append(c.transfers, Transfer{To: to, Value: v})
+	c.lock.Unlock()
+	return c.Recv(), nil
+}
+
+// Returns the next message in the mailbox as what was handed over, along with who it was delivered from. Stops the
+// function once there are none left, the same as Recv.
+func (c *Coroutine) AwaitTransfer() (interface{}, coroutine.Ref) {
+	v := c.Recv()
+	return v, c.Sender()
+}
+
+// Every Transfer so far, in order.
+func (c *Coroutine) Transfers() []Transfer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]Transfer(nil), c.transfers...)
+}
+
+func (c *Coroutine) Recv() interface{} {
+	v, ok := c.RecvImmediate()
+	if !ok {
+		panic(coroutine.Stop{})
+	}
+	return v
+}
+
+func (c *Coroutine) RecvFor(d time.Duration) (interface{}, bool) {
+	if v, ok := c.RecvImmediate(); ok {
+		return v, true
+	}
+	c.lock.Lock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	c.lock.Unlock()
+	return nil, false
+}
+
+func (c *Coroutine) RecvImmediate() (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.mailbox) == 0 {
+		return nil, false
+	}
+	m := c.mailbox[0]
+	c.mailbox = c.mailbox[1:]
+	c.sender = m.from
+	return m.v, true
+}
+
+// Recorded rather than delivered. See Scheduled.
+func (c *Coroutine) After(d time.Duration, v interface{}) coroutine.CancelFunc {
+	return c.schedule(&Scheduled{Value: v, After: d})
+}
+
+// Recorded rather than delivered. See Scheduled.
+func (c *Coroutine) Tick(interval time.Duration, v interface{}) coroutine.CancelFunc {
+	return c.schedule(&Scheduled{Value: v, Every: interval})
+}
+
+func (c *Coroutine) schedule(s *Scheduled) coroutine.CancelFunc {
+	c.lock.Lock()
+	s.At = c.now
+	c.scheduled = append(c.scheduled, s)
+	c.lock.Unlock()
+
+	return func() {
+		c.lock.Lock()
+		s.Cancelled = true
+		c.lock.Unlock()
+	}
+}
+
+func (c *Coroutine) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Always context.Background, since messages delivered to the fake don't carry a context.
+func (c *Coroutine) Context() context.Context {
+	return context.Background()
+}
+
+// Whoever was given to DeliverFrom for the message most recently received, or nil.
+func (c *Coroutine) Sender() coroutine.Ref {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.sender
+}
+
+// Sends v straight to env.From, if it's set.
+func (c *Coroutine) ReplyTo(env coroutine.Envelope, v interface{}) bool {
+	if env.From == nil {
+		return false
+	}
+	env.From.Send(v)
+	return true
+}
+
+// Recorded in Replies, then sent to Sender if there is one. Always returns true.
+func (c *Coroutine) Reply(v interface{}) bool {
+	c.lock.Lock()
+	c.replies = append(c.replies, v)
+	sender := c.sender
+	c.lock.Unlock()
+
+	if sender != nil {
+		sender.Send(v)
+	}
+	return true
+}
+
+// Every value passed to Reply so far, in order.
+func (c *Coroutine) Replies() []interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]interface{}(nil), c.replies...)
+}
+
+// Recorded in Acked.
+func (c *Coroutine) Ack(token coroutine.AckToken) {
+	c.lock.Lock()
+	c.acked = append(c.acked, token)
+	c.lock.Unlock()
+}
+
+// Recorded in Granted.
+func (c *Coroutine) Grant(n int) {
+	c.lock.Lock()
+	c.granted += n
+	c.lock.Unlock()
+}
+
+// The total of every n passed to Grant so far.
+func (c *Coroutine) Granted() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.granted
+}
+
+// Every token passed to Ack so far, in order.
+func (c *Coroutine) Acked() []coroutine.AckToken {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]coroutine.AckToken(nil), c.acked...)
+}
+
+// Recorded in Links. Nothing is ever stopped by a link to the fake.
+func (c *Coroutine) Link(r coroutine.Ref) {
+	c.lock.Lock()
+	c.links = append(c.links, r)
+	c.lock.Unlock()
+}
+
+func (c *Coroutine) Unlink(r coroutine.Ref) {
+	c.lock.Lock()
+	c.links = removeRef(c.links, r)
+	c.lock.Unlock()
+}
+
+// Recorded in Monitors. Deliver an Exit to simulate the monitored coroutine finishing.
+func (c *Coroutine) Monitor(r coroutine.Ref) {
+	c.lock.Lock()
+	c.monitors = append(c.monitors, r)
+	c.lock.Unlock()
+}
+
+func (c *Coroutine) Demonitor(r coroutine.Ref) {
+	c.lock.Lock()
+	c.monitors = removeRef(c.monitors, r)
+	c.lock.Unlock()
+}
+
+// Every Ref currently linked with Link, in the order they were linked.
+func (c *Coroutine) Links() []coroutine.Ref {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]coroutine.Ref(nil), c.links...)
+}
+
+// Every Ref currently monitored with Monitor, in the order they were monitored.
+func (c *Coroutine) Monitors() []coroutine.Ref {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]coroutine.Ref(nil), c.monitors...)
+}
+
+func removeRef(refs []coroutine.Ref, r coroutine.Ref) []coroutine.Ref {
+	for i, ref := range refs {
+		if ref == r {
+			return append(refs[:i], refs[i+1:]...)
+		}
+	}
+	return refs
+}
+
+// Recorded, so the test can check which Behavior is current with Behavior.
+func (c *Coroutine) Become(b coroutine.Behavior) {
+	c.lock.Lock()
+	c.behaviors = append(c.behaviors, b)
+	c.lock.Unlock()
+}
+
+func (c *Coroutine) Unbecome() {
+	c.lock.Lock()
+	if n := len(c.behaviors); n > 0 {
+		c.behaviors = c.behaviors[:n-1]
+	}
+	c.lock.Unlock()
+}
+
+// The Behavior most recently passed to Become that hasn't been removed with Unbecome, or nil.
+func (c *Coroutine) Behavior() coroutine.Behavior {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if n := len(c.behaviors); n > 0 {
+		return c.behaviors[n-1]
+	}
+	return nil
+}
+
+// Discards everything unless a logger is given with SetLogger.
+func (c *Coroutine) Logger() *slog.Logger {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.logger == nil {
+		c.logger = slog.New(slog.DiscardHandler)
+	}
+	return c.logger
+}
+
+// Sets the logger returned by Logger.
+func (c *Coroutine) SetLogger(l *slog.Logger) {
+	c.lock.Lock()
+	c.logger = l
+	c.lock.Unlock()
+}
+
+func (c *Coroutine) Stop() {
+	c.lock.Lock()
+	c.stopped = true
+	c.lock.Unlock()
+	panic(coroutine.Stop{})
+}
+
+// Every duration passed to Pause so far, in order.
+func (c *Coroutine) Paused() []time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]time.Duration(nil), c.paused...)
+}
+
+// Every message scheduled with After or Tick so far, in order.
+func (c *Coroutine) Scheduled() []Scheduled {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	scheduled := make([]Scheduled, len(c.scheduled))
+	for i, s := range c.scheduled {
+		scheduled[i] = *s
+	}
+	return scheduled
+}
+
+// The messages that haven't been received yet.
+func (c *Coroutine) Pending() []interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pending := make([]interface{}, len(c.mailbox))
+	for i, m := range c.mailbox {
+		pending[i] = m.v
+	}
+	return pending
+}
+
+// Whether the function called Stop itself.
+func (c *Coroutine) Stopped() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stopped
+}
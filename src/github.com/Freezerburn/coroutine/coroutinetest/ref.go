@@ -0,0 +1,291 @@
+package coroutinetest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Freezerburn/coroutine"
+)
+
+// A message recorded by a Ref, along with when it was sent.
+type Sent struct {
+	Value interface{}
+	At    time.Time
+}
+
+// A send that was scheduled on a Ref with SendAfter or SendEvery. Nothing is ever actually delivered; the test can
+// inspect what was asked for instead.
+type Scheduled struct {
+	Value interface{}
+	// The delay given to SendAfter. Zero for SendEvery.
+	After time.Duration
+	// The interval given to SendEvery. Zero for SendAfter.
+	Every     time.Duration
+	At        time.Time
+	Cancelled bool
+}
+
+// Ids given to Refs start high enough that they'll never clash with a real coroutine.
+var nextRefId uint64 = 1 << 63
+
+// A test double for coroutine.Ref that doesn't run anything. Every Send is recorded, Running reports whatever the
+// test says it should, and Stop just records that it was called. Safe to use from multiple goroutines.
+type Ref struct {
+	// Called after a message is recorded by Send, if set. Useful for scripting replies.
+	OnSend func(v interface{})
+	// Called by Call after the message is recorded, if set, to decide what it returns. Otherwise Call returns nil and
+	// no error.
+	OnCall func(v interface{}) (interface{}, error)
+	// Called by Replace, if set, to decide what it returns. Otherwise Replace returns nil.
+	OnReplace func(handler interface{}) error
+	// Called by Stop, if set, instead of marking the Ref as no longer running.
+	OnStop func()
+	// Used for the timestamps of recorded messages, if set. Defaults to time.Now.
+	Now func() time.Time
+
+	lock      sync.Mutex
+	id        uint64
+	name      string
+	running   bool
+	sent      []Sent
+	scheduled []*Scheduled
+	stops     int
+	replaced  []interface{}
+	resumes   int
+	suspended bool
+	restarts  int
+}
+
+// Creates a Ref with the given name that reports itself as running until it is stopped.
+func NewRef(name string) *Ref {
+	return &Ref{
+		id:      atomic.AddUint64(&nextRefId, 1),
+		name:    name,
+		running: true,
+	}
+}
+
+var _ coroutine.Ref = (*Ref)(nil)
+
+func (r *Ref) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func (r *Ref) Send(v interface{}) {
+	r.lock.Lock()
+	r.sent = append(r.sent, Sent{Value: v, At: r.now()})
+	onSend := r.OnSend
+	r.lock.Unlock()
+
+	if onSend != nil {
+		onSend(v)
+	}
+}
+
+// Recorded the same as Send. The context is ignored.
+func (r *Ref) SendContext(ctx context.Context, v interface{}) {
+	r.Send(v)
+}
+
+// Recorded the same as Send, then answered by OnCall.
+func (r *Ref) Call(v interface{}, timeout time.Duration) (interface{}, error) {
+	r.Send(v)
+	r.lock.Lock()
+	onCall := r.OnCall
+	r.lock.Unlock()
+
+	if onCall != nil {
+		return onCall(v)
+	}
+	return nil, nil
+}
+
+// The same as Call, with the answer already resolved in the returned Future.
+func (r *Ref) Ask(v interface{}) *coroutine.Future {
+	return coroutine.Resolved(r.Call(v, 0))
+}
+
+func (r *Ref) SendAfter(v interface{}, d time.Duration) coroutine.CancelFunc {
+	return r.schedule(&Scheduled{Value: v, After: d})
+}
+
+func (r *Ref) SendEvery(v interface{}, interval time.Duration) coroutine.CancelFunc {
+	return r.schedule(&Scheduled{Value: v, Every: interval})
+}
+
+func (r *Ref) schedule(s *Scheduled) coroutine.CancelFunc {
+	r.lock.Lock()
+	s.At = r.now()
+	r.scheduled = append(r.scheduled, s)
+	r.lock.Unlock()
+
+	return func() {
+		r.lock.Lock()
+		s.Cancelled = true
+		r.lock.Unlock()
+	}
+}
+
+func (r *Ref) Running() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.running
+}
+
+// Sets what Running reports.
+func (r *Ref) SetRunning(running bool) {
+	r.lock.Lock()
+	r.running = running
+	r.lock.Unlock()
+}
+
+func (r *Ref) Name() string {
+	return r.name
+}
+
+func (r *Ref) Id() uint64 {
+	return r.id
+}
+
+// Only Received is filled in, with the number of messages sent so far.
+func (r *Ref) Stats() coroutine.Stats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return coroutine.Stats{Received: uint64(len(r.sent))}
+}
+
+// Answers immediately with whether the Ref is running.
+func (r *Ref) Ping(timeout time.Duration) coroutine.Health {
+	return coroutine.Health{Alive: r.Running()}
+}
+
+// Recorded in Replaced, then answered by OnReplace.
+func (r *Ref) Replace(handler interface{}) error {
+	r.lock.Lock()
+	r.replaced = append(r.replaced, handler)
+	onReplace := r.OnReplace
+	r.lock.Unlock()
+
+	if onReplace != nil {
+		return onReplace(handler)
+	}
+	return nil
+}
+
+// Only recorded. See Suspended.
+func (r *Ref) Suspend() {
+	r.lock.Lock()
+	r.suspended = true
+	r.lock.Unlock()
+}
+
+// Counted, and clears Suspended. See Resumes.
+func (r *Ref) Resume() {
+	r.lock.Lock()
+	r.resumes++
+	r.suspended = false
+	r.lock.Unlock()
+}
+
+// Whether Suspend has been called since the last Resume.
+func (r *Ref) Suspended() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.suspended
+}
+
+// Counted, and marks the Ref as running again. See Restarts.
+func (r *Ref) Restart() error {
+	r.lock.Lock()
+	r.restarts++
+	r.running = true
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *Ref) Stop() {
+	r.lock.Lock()
+	r.stops++
+	onStop := r.OnStop
+	if onStop == nil {
+		r.running = false
+	}
+	r.lock.Unlock()
+
+	if onStop != nil {
+		onStop()
+	}
+}
+
+// Every message sent so far, in the order they were sent.
+func (r *Ref) Sent() []Sent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Sent(nil), r.sent...)
+}
+
+// The values of every message sent so far, in the order they were sent.
+func (r *Ref) Values() []interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	values := make([]interface{}, len(r.sent))
+	for i, s := range r.sent {
+		values[i] = s.Value
+	}
+	return values
+}
+
+// Every send scheduled with SendAfter or SendEvery so far, in the order they were scheduled.
+func (r *Ref) Scheduled() []Scheduled {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	scheduled := make([]Scheduled, len(r.scheduled))
+	for i, s := range r.scheduled {
+		scheduled[i] = *s
+	}
+	return scheduled
+}
+
+// Every handler passed to Replace so far, in order.
+func (r *Ref) Replaced() []interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]interface{}(nil), r.replaced...)
+}
+
+// How many times Resume has been called.
+func (r *Ref) Resumes() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.resumes
+}
+
+// How many times Restart has been called.
+func (r *Ref) Restarts() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.restarts
+}
+
+// How many times Stop has been called.
+func (r *Ref) Stops() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.stops
+}
+
+// Forgets every recorded send, replacement, resume and stop, without changing whether the Ref is running.
+func (r *Ref) Reset() {
+	r.lock.Lock()
+	r.sent = nil
+	r.scheduled = nil
+	r.replaced = nil
+	r.resumes = 0
+	r.stops = 0
+	r.lock.Unlock()
+}
@@ -0,0 +1,84 @@
+// Helpers for testing code built on the coroutine package without relying on real time or the Go scheduler.
+package coroutinetest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Freezerburn/coroutine"
+)
+
+// A deterministic scheduler for tests. Coroutines started through it only run during Step and RunUntilIdle, one at a
+// time and always in the same order, and their Pause and RecvFor calls use virtual time moved by Advance. Every
+// coroutine still running when the test ends is stopped.
+type Scheduler struct {
+	*coroutine.Scheduler
+	t testing.TB
+}
+
+// Creates a Scheduler for the given test. Virtual time starts at the Unix epoch so that times in failure messages
+// are easy to read.
+func NewScheduler(t testing.TB) *Scheduler {
+	s := &Scheduler{
+		Scheduler: coroutine.NewScheduler(time.Unix(0, 0).UTC()),
+		t:         t,
+	}
+	t.Cleanup(s.StopAll)
+	return s
+}
+
+// Starts a coroutine under this scheduler. It doesn't run until the next Step or RunUntilIdle.
+func (s *Scheduler) StartFunc(f coroutine.Function, opts ...coroutine.Option) coroutine.Ref {
+	return s.StartFuncName(s.t.Name(), f, opts...)
+}
+
+// Starts a named coroutine under this scheduler. It doesn't run until the next Step or RunUntilIdle.
+func (s *Scheduler) StartFuncName(name string, f coroutine.Function, opts ...coroutine.Option) coroutine.Ref {
+	return coroutine.StartFuncName(name, f, append(opts, coroutine.WithScheduler(s.Scheduler))...)
+}
+
+// Starts a Starter under this scheduler. It doesn't run until the next Step or RunUntilIdle.
+func (s *Scheduler) Start(st coroutine.Starter, opts ...coroutine.Option) coroutine.Ref {
+	return coroutine.StartName(s.t.Name(), st, append(opts, coroutine.WithScheduler(s.Scheduler))...)
+}
+
+// Moves virtual time forward by d, then runs every coroutine that became ready until they are all idle again.
+func (s *Scheduler) AdvanceAndRun(d time.Duration) int {
+	s.Advance(d)
+	return s.RunUntilIdle()
+}
+
+// Fails the test unless the coroutine's mailbox holds exactly the given messages, in order.
+func (s *Scheduler) ExpectMailbox(r coroutine.Ref, want ...interface{}) {
+	s.t.Helper()
+	got := s.Mailbox(r)
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		s.t.Errorf("mailbox of coroutine [%v / %s] = %v, want %v", r.Id(), r.Name(), got, want)
+	}
+}
+
+// Fails the test unless the coroutine's mailbox is empty.
+func (s *Scheduler) ExpectEmpty(r coroutine.Ref) {
+	s.t.Helper()
+	s.ExpectMailbox(r)
+}
+
+// Fails the test unless the coroutine has finished running.
+func (s *Scheduler) ExpectStopped(r coroutine.Ref) {
+	s.t.Helper()
+	if r.Running() {
+		s.t.Errorf("coroutine [%v / %s] is still running", r.Id(), r.Name())
+	}
+}
+
+// Fails the test unless the coroutine is still running.
+func (s *Scheduler) ExpectRunning(r coroutine.Ref) {
+	s.t.Helper()
+	if !r.Running() {
+		s.t.Errorf("coroutine [%v / %s] has stopped", r.Id(), r.Name())
+	}
+}
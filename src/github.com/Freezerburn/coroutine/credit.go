@@ -0,0 +1,111 @@
+package coroutine
+
+import (
+	"context"
+	"sync"
+)
+
+// Makes the coroutine pull messages sent with SendWithCredit at its own pace, instead of having them pushed at it as
+// fast as producers can go. It starts with the given number of credits, each message sent with SendWithCredit uses
+// one up, and once they've run out producers wait until the coroutine hands out more with Grant. A consumer that
+// grants a credit for every message it finishes keeps at most initial of them waiting in its mailbox.
+//
+// Send and everything else on the Ref never need credit, so replies and control messages can't get stuck behind a
+// producer that is waiting.
+func WithCredits(initial int) Option {
+	return func(e *Embeddable) {
+		if initial < 0 {
+			initial = 0
+		}
+		e.extra().credits = &creditGate{available: initial}
+	}
+}
+
+type creditGate struct {
+	lock      sync.Mutex
+	available int
+	// Closed, and replaced, whenever credits are granted so that waiting producers check again. nil if nothing is
+	// waiting.
+	granted chan struct{}
+}
+
+// Uses up a credit if there is one. If not, returns a channel that is closed once more are granted.
+func (g *creditGate) take() (bool, <-chan struct{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.available > 0 {
+		g.available--
+		return true, nil
+	}
+	if g.granted == nil {
+		g.granted = make(chan struct{})
+	}
+	return false, g.granted
+}
+
+func (g *creditGate) grant(n int) {
+	g.lock.Lock()
+	g.available += n
+	if g.granted != nil {
+		close(g.granted)
+		g.granted = nil
+	}
+	g.lock.Unlock()
+}
+
+// Sends v to r with SendContext once the coroutine has credit for it, using one up, and waiting for it to Grant more
+// if it has none left. Returns the context's error if it's done first, or ErrNotRunning if the coroutine stops while
+// waiting. Refs that aren't coroutines started with WithCredits are sent to straight away.
+func SendWithCredit(ctx context.Context, r Ref, v interface{}) error {
+	ref, ok := localRef(r)
+	if !ok || ref.e.credits() == nil {
+		SendContext(ctx, r, v)
+		return nil
+	}
+
+	e := ref.e
+	for {
+		if !e.isRunning() {
+			return ErrNotRunning
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, granted := e.credits().take()
+		if ok {
+			SendContext(ctx, r, v)
+			return nil
+		}
+		select {
+		case <-granted:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.done:
+			return ErrNotRunning
+		}
+	}
+}
+
+// Implemented by Embeddable for coroutines started with WithCredits, which grant their senders credit as they go.
+type Granter interface {
+	Grant(n int)
+}
+
+var _ Granter = (*Embeddable)(nil)
+
+// Lets producers using SendWithCredit send n more messages to this coroutine. Does nothing unless it was started
+// with WithCredits.
+func (e *Embeddable) Grant(n int) {
+	e.checkpoint()
+	if credits := e.credits(); credits != nil && n > 0 {
+		credits.grant(n)
+	}
+}
+
+// What holds back SendWithCredit, or nil if the coroutine wasn't started with WithCredits.
+func (e *Embeddable) credits() *creditGate {
+	if x := e.ext.Load(); x != nil {
+		return x.credits
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package coroutine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Anything that can decide when something scheduled should next happen. Next is given the time of the previous fire
+// (or the current time if there hasn't been one yet) and returns the next time strictly after it. Returning the zero
+// time means it should never happen again.
+type Calendar interface {
+	Next(after time.Time) time.Time
+}
+
+// Adapts a plain function into a Calendar.
+type CalendarFunc func(after time.Time) time.Time
+
+func (f CalendarFunc) Next(after time.Time) time.Time {
+	return f(after)
+}
+
+// A Calendar that fires once every interval, starting one interval after it is first scheduled.
+func Every(interval time.Duration) Calendar {
+	if interval <= 0 {
+		panic("coroutine: Every requires a positive interval")
+	}
+	return CalendarFunc(func(after time.Time) time.Time {
+		return after.Add(interval)
+	})
+}
+
+// A Calendar that fires exactly once at the given time, or never if that time has already passed.
+func At(t time.Time) Calendar {
+	return CalendarFunc(func(after time.Time) time.Time {
+		if t.After(after) {
+			return t
+		}
+		return time.Time{}
+	})
+}
+
+// A parsed standard five field cron expression. Each field is a bit set of the values that match it.
+type cronSpec struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// Cron treats day of month and day of week specially: if both are restricted, a day matching either one fires.
+	// If only one is restricted, only that one needs to match.
+	domStar bool
+	dowStar bool
+	loc     *time.Location
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{0, 59, nil}
+	cronHour   = cronField{0, 23, nil}
+	cronDom    = cronField{1, 31, nil}
+	cronMonth  = cronField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week allows 7 as well as 0 for Sunday, which is folded into 0 after parsing.
+	cronDow = cronField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronShorthands = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// Parses a cron expression into a Calendar that works in the local time zone. Supported are the standard five fields
+// (minute, hour, day of month, month, day of week) with `*`, lists (`1,15`), ranges (`1-5`), steps (`*/10`, `0-30/5`)
+// and three letter month and weekday names, along with the shorthands @yearly, @annually, @monthly, @weekly, @daily,
+// @midnight, @hourly and `@every <duration>`.
+func ParseCron(spec string) (Calendar, error) {
+	return ParseCronIn(spec, time.Local)
+}
+
+// The same as ParseCron, except the expression is evaluated in the given time zone.
+func ParseCronIn(spec string, loc *time.Location) (Calendar, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("coroutine: bad cron spec %q: %v", spec, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("coroutine: bad cron spec %q: interval must be positive", spec)
+		}
+		return Every(d), nil
+	}
+	if expanded, ok := cronShorthands[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("coroutine: bad cron spec %q: expected 5 fields, found %d", spec, len(fields))
+	}
+
+	c := &cronSpec{loc: loc}
+	var err error
+	if c.minute, err = cronMinute.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("coroutine: bad cron spec %q: minute: %v", spec, err)
+	}
+	if c.hour, err = cronHour.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("coroutine: bad cron spec %q: hour: %v", spec, err)
+	}
+	if c.dom, err = cronDom.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("coroutine: bad cron spec %q: day of month: %v", spec, err)
+	}
+	if c.month, err = cronMonth.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("coroutine: bad cron spec %q: month: %v", spec, err)
+	}
+	if c.dow, err = cronDow.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("coroutine: bad cron spec %q: day of week: %v", spec, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	c.domStar = fields[2] == "*" || strings.HasPrefix(fields[2], "*/")
+	c.dowStar = fields[4] == "*" || strings.HasPrefix(fields[4], "*/")
+	return c, nil
+}
+
+// The same as ParseCron, except it panics if the expression is invalid. Intended for expressions that are constants.
+func MustParseCron(spec string) Calendar {
+	c, err := ParseCron(spec)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := f.min, f.max
+		if part != "*" {
+			if i := strings.IndexByte(part, '-'); i >= 0 {
+				var err error
+				if lo, err = f.value(part[:i]); err != nil {
+					return 0, err
+				}
+				if hi, err = f.value(part[i+1:]); err != nil {
+					return 0, err
+				}
+			} else {
+				var err error
+				if lo, err = f.value(part); err != nil {
+					return 0, err
+				}
+				// A single value with a step, like 5/10, means starting at that value through the max.
+				hi = lo
+				if step > 1 {
+					hi = f.max
+				}
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("bad range in %q", part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Finds the next matching minute by skipping forward a field at a time, from the largest unit to the smallest.
+// Searches at most five years ahead, which is enough for any expression that can match at all (February 29th).
+func (c *cronSpec) Next(after time.Time) time.Time {
+	t := after.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,77 @@
+package coroutine
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Why a message ended up as a DeadLetter.
+type DeadLetterReason int
+
+const (
+	// The message was sent to a coroutine that had already stopped.
+	DeadLetterStopped DeadLetterReason = iota
+	// The message was a reply to a Call that had already given up waiting for it.
+	DeadLetterStaleReply
+	// The message was sent with SendWithTTL, and was still in the mailbox when its time ran out.
+	DeadLetterExpired
+	// The message was Identified, and a coroutine started with WithDeduplication had already been sent one with the
+	// same id.
+	DeadLetterDuplicate
+	// The coroutine's Mailbox returned an error from Enqueue, which is in Err.
+	DeadLetterRejected
+	// The message wasn't of a type the coroutine can handle, such as a value other than its input sent to a pipeline
+	// stage.
+	DeadLetterUnexpected
+)
+
+func (r DeadLetterReason) String() string {
+	switch r {
+	case DeadLetterStopped:
+		return "stopped"
+	case DeadLetterStaleReply:
+		return "stale reply"
+	case DeadLetterExpired:
+		return "expired"
+	case DeadLetterDuplicate:
+		return "duplicate"
+	case DeadLetterRejected:
+		return "rejected"
+	case DeadLetterUnexpected:
+		return "unexpected"
+	}
+	return fmt.Sprintf("DeadLetterReason(%d)", int(r))
+}
+
+// A message that couldn't be delivered, passed to the handler set with SetDeadLetterHandler.
+type DeadLetter struct {
+	Reason DeadLetterReason
+	Value  interface{}
+	// The coroutine the message was meant for, if it's known.
+	To Ref
+	// The coroutine that sent the message, if it's known.
+	From Ref
+	// Why the message was rejected, for DeadLetterRejected.
+	Err error
+}
+
+// Wraps the handler so that atomic.Value always stores the same concrete type.
+type deadLetterHolder struct {
+	h func(d DeadLetter)
+}
+
+var deadLetterHandler atomic.Value
+
+// Sets a function to be called with every message that couldn't be delivered, instead of it silently disappearing.
+// It's called from whichever goroutine tried to deliver the message, so it must be safe for concurrent use, and
+// shouldn't block. Passing nil goes back to the default of dropping them, which still counts them in Totals.Dropped.
+func SetDeadLetterHandler(h func(d DeadLetter)) {
+	deadLetterHandler.Store(deadLetterHolder{h})
+}
+
+func deadLetter(d DeadLetter) {
+	atomic.AddUint64(&totalDropped, 1)
+	if holder, _ := deadLetterHandler.Load().(deadLetterHolder); holder.h != nil {
+		holder.h(d)
+	}
+}
@@ -0,0 +1,129 @@
+package coroutine
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Changes what DebugHandler shows.
+type DebugOption func(d *debugHandler)
+
+// Makes DebugHandler include the contents of each coroutine's mailbox when a single coroutine is asked for. Messages
+// are printed with %v, so this can expose anything that is sent between coroutines and should only be used where
+// that is acceptable.
+func DebugShowMailboxes() DebugOption {
+	return func(d *debugHandler) {
+		d.showMailboxes = true
+	}
+}
+
+// An http.Handler describing every live coroutine, intended to be mounted under /debug/coroutines in the same way as
+// expvar and net/http/pprof. By default it renders an HTML table; adding ?format=json (or asking for
+// application/json) returns the same data as JSON. Adding ?id=N shows a single coroutine along with its Stats and,
+// if DebugShowMailboxes was given, its mailbox.
+func DebugHandler(opts ...DebugOption) http.Handler {
+	d := &debugHandler{}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+type debugHandler struct {
+	showMailboxes bool
+}
+
+// What the debug handler shows for a single coroutine.
+type debugEntry struct {
+	Id           uint64        `json:"id"`
+	Name         string        `json:"name"`
+	State        string        `json:"state"`
+	MailboxLen   int           `json:"mailbox_len"`
+	Started      time.Time     `json:"started"`
+	Uptime       time.Duration `json:"uptime_ns"`
+	Received     uint64        `json:"received"`
+	Processed    uint64        `json:"processed"`
+	LastActivity time.Time     `json:"last_activity"`
+	Mailbox      []string      `json:"mailbox,omitempty"`
+}
+
+func (d *debugHandler) entry(e *Embeddable, withMailbox bool) debugEntry {
+	info := e.info()
+	stats := e.stats()
+	entry := debugEntry{
+		Id:           info.Id,
+		Name:         info.Name,
+		State:        info.State.String(),
+		MailboxLen:   info.MailboxLen,
+		Started:      info.Started,
+		Uptime:       stats.Uptime,
+		Received:     stats.Received,
+		Processed:    stats.Processed,
+		LastActivity: stats.LastActivity,
+	}
+	if withMailbox && d.showMailboxes {
+		e.mailbox.each(func(m mail) {
+			entry.Mailbox = append(entry.Mailbox, fmt.Sprintf("%T: %v", m.v, m.v))
+		})
+	}
+	return entry
+}
+
+func (d *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var entries []debugEntry
+	if idParam := r.URL.Query().Get("id"); idParam != "" {
+		id, err := strconv.ParseUint(idParam, 10, 64)
+		if err != nil {
+			http.Error(w, "bad id: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		e, ok := lookupId(id)
+		if !ok {
+			http.Error(w, "no live coroutine with that id", http.StatusNotFound)
+			return
+		}
+		entries = append(entries, d.entry(e, true))
+	} else {
+		for _, info := range List() {
+			e, ok := lookupId(info.Id)
+			if ok {
+				entries = append(entries, d.entry(e, false))
+			}
+		}
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	debugTemplate.Execute(w, entries)
+}
+
+var debugTemplate = template.Must(template.New("coroutines").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Coroutines</title></head>
+<body>
+<p>{{len .}} coroutine(s) &middot; <a href="?format=json">json</a></p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Id</th><th>Name</th><th>State</th><th>Mailbox</th><th>Received</th><th>Processed</th><th>Uptime</th>
+<th>Last activity</th></tr>
+{{range .}}<tr>
+<td><a href="?id={{.Id}}">{{.Id}}</a></td><td>{{.Name}}</td><td>{{.State}}</td><td>{{.MailboxLen}}</td>
+<td>{{.Received}}</td><td>{{.Processed}}</td><td>{{.Uptime}}</td>
+<td>{{.LastActivity.Format "2006-01-02 15:04:05.000"}}</td>
+</tr>
+{{range .Mailbox}}<tr><td></td><td colspan="7"><code>{{.}}</code></td></tr>
+{{end}}{{end}}</table>
+</body>
+</html>
+`))
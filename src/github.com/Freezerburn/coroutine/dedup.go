@@ -0,0 +1,70 @@
+package coroutine
+
+import (
+	"sync"
+	"time"
+)
+
+// Implemented by messages that carry an id of their own, such as one given to them by an upstream queue, so that a
+// coroutine started with WithDeduplication can tell when it has been sent the same message twice.
+type Identified interface {
+	MessageId() string
+}
+
+// Makes the coroutine receive each message that is Identified only once, however many times it's sent within window.
+// Copies sent before window has passed since the first are dropped as they're sent, going to the dead letter handler
+// with DeadLetterDuplicate. An Envelope counts as Identified if its Payload is. Lets a coroutine fed by a source with
+// at-least-once delivery handle each message once without keeping track itself. Messages that aren't Identified are
+// never dropped.
+func WithDeduplication(window time.Duration) Option {
+	return func(e *Embeddable) {
+		e.extra().dedup = &dedupWindow{window: window, seen: make(map[string]time.Time)}
+	}
+}
+
+// The ids seen by a coroutine within its deduplication window. Guarded by lock, since any number of senders check it
+// at once.
+type dedupWindow struct {
+	lock   sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+	// Every id in seen, oldest first, so they can be forgotten once they fall out of the window.
+	order []seenId
+}
+
+type seenId struct {
+	id string
+	at time.Time
+}
+
+// Whether a message with the given id has already been seen within the window. If it hasn't, it's recorded as seen
+// at now.
+func (d *dedupWindow) duplicate(id string, now time.Time) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for len(d.order) > 0 && now.Sub(d.order[0].at) >= d.window {
+		old := d.order[0]
+		d.order[0] = seenId{}
+		d.order = d.order[1:]
+		if d.seen[old.id] == old.at {
+			delete(d.seen, old.id)
+		}
+	}
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+	d.seen[id] = now
+	d.order = append(d.order, seenId{id: id, at: now})
+	return false
+}
+
+// The id of a message, if it has one.
+func messageId(v interface{}) (string, bool) {
+	if env, ok := v.(Envelope); ok {
+		v = env.Payload
+	}
+	if m, ok := v.(Identified); ok {
+		return m.MessageId(), true
+	}
+	return "", false
+}
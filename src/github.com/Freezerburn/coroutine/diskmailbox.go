@@ -0,0 +1,331 @@
+package coroutine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// When a DiskMailbox makes sure what it has written is on stable storage, rather than just handed to the operating
+// system. A positive FsyncPolicy is an interval, made with FsyncEvery.
+type FsyncPolicy time.Duration
+
+const (
+	// Sync after every message put in or taken out. Nothing is lost even if the machine crashes, but every Send waits
+	// for the disk.
+	FsyncAlways FsyncPolicy = 0
+	// Never sync, leaving it to the operating system. Nothing is lost if the program crashes, but the last few
+	// seconds of messages may be if the machine does.
+	FsyncNever FsyncPolicy = -1
+)
+
+// Syncs at most once every d, from a goroutine of the DiskMailbox's own, so that a crash of the machine loses no more
+// than the last d of messages.
+func FsyncEvery(d time.Duration) FsyncPolicy {
+	if d <= 0 {
+		return FsyncAlways
+	}
+	return FsyncPolicy(d)
+}
+
+// Returned by a DiskMailbox's Enqueue once it has been closed.
+var ErrMailboxClosed = errors.New("coroutine: mailbox is closed")
+
+// The kinds of record in a DiskMailbox's log.
+const (
+	// A message put in, followed by its value encoded with the Codec.
+	diskRecordPut byte = iota
+	// The oldest message not yet taken out was taken out.
+	diskRecordTake
+)
+
+// Only compact the log of an empty DiskMailbox once it's at least this big, so a mailbox that's usually empty isn't
+// truncated after every message.
+const diskCompactSize = 1 << 20
+
+// A Mailbox that writes every message to an append-only log on disk, so that messages still waiting when the program
+// stops are there again when it next opens the same file, to be replayed into a coroutine given the DiskMailbox with
+// WithMailbox. Messages are encoded with the Codec set with SetCodec, so the concrete type of every value sent must be
+// registered with it, and only the value survives: a replayed message has no sender and no Call waiting for it,
+// whatever it had when it was sent.
+//
+// Taking a message out is logged as soon as the coroutine receives it, so one that was being handled when the program
+// stopped isn't delivered again. Coroutines that need that can send with SendAcked, or acknowledge work themselves.
+//
+// The log only grows while messages are waiting, and is emptied once none are and it's grown past a megabyte. Opening
+// it again rewrites it with only what's still waiting. Safe for concurrent use, and must be closed with Close.
+type DiskMailbox struct {
+	lock   sync.Mutex
+	file   *os.File
+	path   string
+	policy FsyncPolicy
+	size   int64
+	// Messages waiting, from pending[head] onwards. Kept in memory as well as on disk, so that Dequeue never reads
+	// the file, and messages sent since the file was opened keep everything the library knows about them.
+	pending []Message
+	head    int
+	// Whether anything has been written since the last sync, for FsyncEvery.
+	dirty  bool
+	closed bool
+	stop   chan struct{}
+	synced chan struct{}
+}
+
+var _ Mailbox = (*DiskMailbox)(nil)
+
+// Opens the log at path, creating it if it doesn't exist, and loads any messages still waiting in it, which the
+// coroutine it's given to receives before anything sent to it. A log cut short part of the way through a record, by
+// a crash while it was being written, is truncated to the last whole record.
+func OpenDiskMailbox(path string, policy FsyncPolicy) (*DiskMailbox, error) {
+	pending, err := replayDiskLog(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write what's still waiting to a fresh log, and swap it in, so records of messages already taken out don't
+	// pile up across runs.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, m := range pending {
+		if err := appendDiskRecord(&buf, diskRecordPut, m.Value); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if _, err := f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	d := &DiskMailbox{
+		file:    f,
+		path:    path,
+		policy:  policy,
+		size:    int64(buf.Len()),
+		pending: pending,
+	}
+	if policy > 0 {
+		d.stop = make(chan struct{})
+		d.synced = make(chan struct{})
+		go d.syncEvery(time.Duration(policy))
+	}
+	return d, nil
+}
+
+// Reads the messages still waiting in the log at path, if there is one.
+func replayDiskLog(path string) ([]Message, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Message
+	taken := 0
+	for len(data) > 0 {
+		if len(data) < 5 {
+			// Cut short while the header was being written.
+			break
+		}
+		n := binary.BigEndian.Uint32(data)
+		kind := data[4]
+		if uint64(len(data)-5) < uint64(n) {
+			break
+		}
+		payload := data[5 : 5+n]
+		data = data[5+n:]
+
+		switch kind {
+		case diskRecordPut:
+			v, err := decodeValue(payload)
+			if err != nil {
+				return nil, fmt.Errorf("coroutine: can't decode message in %s: %w", path, err)
+			}
+			pending = append(pending, Message{Value: v})
+		case diskRecordTake:
+			taken++
+		default:
+			return nil, fmt.Errorf("coroutine: unknown record %d in %s", kind, path)
+		}
+	}
+	if taken > len(pending) {
+		taken = len(pending)
+	}
+	return pending[taken:], nil
+}
+
+// Appends a record to buf: its length, its kind, then v encoded with the Codec for diskRecordPut.
+func appendDiskRecord(buf *bytes.Buffer, kind byte, v interface{}) error {
+	var payload []byte
+	if kind == diskRecordPut {
+		var err error
+		if payload, err = encodeValue(v); err != nil {
+			return err
+		}
+	}
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	header[4] = kind
+	buf.Write(header[:])
+	buf.Write(payload)
+	return nil
+}
+
+// Writes the message to the log, then makes it available to Dequeue.
+func (d *DiskMailbox) Enqueue(m Message) error {
+	var buf bytes.Buffer
+	if err := appendDiskRecord(&buf, diskRecordPut, m.Value); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return ErrMailboxClosed
+	}
+	if err := d.writeLocked(buf.Bytes()); err != nil {
+		return err
+	}
+	d.pending = append(d.pending, m)
+	return nil
+}
+
+// Takes the oldest message out, logging that it has been.
+func (d *DiskMailbox) Dequeue() (Message, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.head == len(d.pending) {
+		return Message{}, false
+	}
+	m := d.pending[d.head]
+	d.pending[d.head] = Message{}
+	d.head++
+	if d.head == len(d.pending) {
+		d.pending = d.pending[:0]
+		d.head = 0
+	} else if d.head > len(d.pending)/2 {
+		// Move what's left to the front, so the slice doesn't keep growing behind head.
+		n := copy(d.pending, d.pending[d.head:])
+		for i := n; i < len(d.pending); i++ {
+			d.pending[i] = Message{}
+		}
+		d.pending = d.pending[:n]
+		d.head = 0
+	}
+
+	if !d.closed {
+		if len(d.pending) == 0 && d.size >= diskCompactSize {
+			d.compactLocked()
+		} else {
+			var buf bytes.Buffer
+			appendDiskRecord(&buf, diskRecordTake, nil)
+			// Nowhere to report a failure to. The message is only delivered again if the program stops before
+			// anything else is written.
+			d.writeLocked(buf.Bytes())
+		}
+	}
+	return m, true
+}
+
+func (d *DiskMailbox) Len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.pending) - d.head
+}
+
+// The path of the log.
+func (d *DiskMailbox) Path() string {
+	return d.path
+}
+
+// Must be called with the lock held.
+func (d *DiskMailbox) writeLocked(b []byte) error {
+	n, err := d.file.Write(b)
+	d.size += int64(n)
+	if err != nil {
+		return err
+	}
+	switch {
+	case d.policy == FsyncAlways:
+		return d.file.Sync()
+	case d.policy > 0:
+		d.dirty = true
+	}
+	return nil
+}
+
+// Empties the log, since nothing in it is waiting any longer. Must be called with the lock held.
+func (d *DiskMailbox) compactLocked() {
+	if err := d.file.Truncate(0); err != nil {
+		return
+	}
+	d.size = 0
+	if d.policy == FsyncAlways {
+		d.file.Sync()
+	}
+}
+
+func (d *DiskMailbox) syncEvery(interval time.Duration) {
+	defer close(d.synced)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			d.lock.Lock()
+			if d.dirty && !d.closed {
+				d.dirty = false
+				d.file.Sync()
+			}
+			d.lock.Unlock()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Syncs the log and closes it, so messages still waiting stay in it for the next OpenDiskMailbox. They can still be
+// taken out with Dequeue, but since that's no longer logged, they're replayed next time as well. Enqueue returns
+// ErrMailboxClosed from now on. Close it once the coroutine it was given to has stopped.
+func (d *DiskMailbox) Close() error {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return nil
+	}
+	d.closed = true
+	err := d.file.Sync()
+	if cerr := d.file.Close(); err == nil {
+		err = cerr
+	}
+	d.lock.Unlock()
+
+	if d.stop != nil {
+		close(d.stop)
+		<-d.synced
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+package coroutine
+
+import (
+	"sync"
+	"time"
+)
+
+// Runs coroutines in step with a game loop, the way coroutines work in engines such as Unity: the host calls Tick
+// once every frame, and coroutines started with WithDriver only run during Tick. Time for them is frame time, which
+// only moves by the dt passed to each Tick, so WaitSeconds and Pause last for however many frames add up to that
+// long, and a game that's paused, or running slowly, doesn't find its timers going off behind its back.
+//
+// A Driver is a Scheduler underneath, so coroutines on it run one at a time, in the same order every time, each
+// handed control by whichever goroutine calls Tick and handing it back when it next waits. They never run alongside
+// the game loop or each other, so they can share the game's state without locking it, and SetBudget can cap how long
+// a frame spends on them. Tick must not be called from one of the driver's own coroutines.
+type Driver struct {
+	s *Scheduler
+
+	lock   sync.Mutex
+	frame  uint64
+	delta  time.Duration
+	budget time.Duration
+}
+
+// Creates a Driver whose frame time starts at the Unix epoch.
+func NewDriver() *Driver {
+	return &Driver{s: NewScheduler(time.Unix(0, 0).UTC())}
+}
+
+// Runs the coroutine on the given Driver, so it only runs during Tick and waits in frame time.
+func WithDriver(d *Driver) Option {
+	return WithScheduler(d.s)
+}
+
+// Moves on to the next frame, dt after the last. Coroutines left over from the last frame by its budget run first,
+// then those waiting in WaitNextTick, in the order they started waiting, then those whose WaitSeconds or Pause ends
+// within dt, then everything else that has become ready, such as by being sent a message since the last frame.
+// Coroutines given a higher priority with WithPriority run ahead of all of these. Returns once none of them has
+// anything more to do this frame, or the budget has been used up, along with how many steps were run.
+func (d *Driver) Tick(dt time.Duration) int {
+	d.lock.Lock()
+	d.frame++
+	d.delta = dt
+	budget := d.budget
+	d.lock.Unlock()
+
+	d.s.tick()
+	d.s.Advance(dt)
+	if budget <= 0 {
+		return d.s.RunUntilIdle()
+	}
+	start := time.Now()
+	steps := 0
+	for time.Since(start) < budget && d.s.Step() {
+		steps++
+	}
+	return steps
+}
+
+// Limits how much real time each Tick spends running coroutines, so that thousands of them, such as a script for
+// every entity in a game, can't make a frame late. Once a Tick has used up its budget, it returns without running
+// the coroutines that are still ready, which run first thing during the next Tick instead. A coroutine is never
+// interrupted partway through a step, so one that runs for long without waiting can still go over. Zero, the
+// default, lets every Tick run until nothing is ready.
+func (d *Driver) SetBudget(budget time.Duration) {
+	d.lock.Lock()
+	d.budget = budget
+	d.lock.Unlock()
+}
+
+// Whether every coroutine got to do everything it was ready to during the last Tick, rather than some being left
+// over for the next by the budget.
+func (d *Driver) Idle() bool {
+	return d.s.Idle()
+}
+
+// How many times Tick has been called.
+func (d *Driver) Frame() uint64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.frame
+}
+
+// The dt passed to the last Tick, for coroutines that move things by how much time the frame took.
+func (d *Driver) Delta() time.Duration {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.delta
+}
+
+// The current frame time, which is the total of every dt passed to Tick.
+func (d *Driver) Now() time.Time {
+	return d.s.Now()
+}
+
+// The number of coroutines on the driver that haven't finished yet.
+func (d *Driver) Len() int {
+	return d.s.Len()
+}
+
+// Stops every coroutine on the driver and runs them until they have all finished.
+func (d *Driver) StopAll() {
+	d.s.StopAll()
+}
+
+// Implemented by Embeddable for coroutines that wait on the ticks of a Driver.
+type FrameWaiter interface {
+	WaitNextTick()
+	WaitFrames(n int)
+	WaitSeconds(s float64)
+}
+
+var _ FrameWaiter = (*Embeddable)(nil)
+
+// Waits until the next frame, for a coroutine started with WithDriver. A coroutine under a plain Scheduler waits in
+// the same way, but nothing other than stopping it ends the wait, since only a Driver has frames. A coroutine that
+// isn't run by either just yields, as Pause(0) does.
+func (e *Embeddable) WaitNextTick() {
+	e.checkpoint()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+	if e.sched == nil {
+		e.Pause(0)
+		return
+	}
+
+	e.setState(StatePaused)
+	// Woken early to handle library control messages, which checkpoint does, before waiting again.
+	for !e.sched.blockUntilTick(e) {
+		e.checkpoint()
+		if !e.isRunning() {
+			panic(Stop{})
+		}
+	}
+	e.checkpoint()
+	e.setState(StateRunning)
+	e.touch()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+}
+
+// Waits for exactly n frames, for a coroutine started with WithDriver, however long they take, resuming during the
+// nth Tick from now. Suits scripted sequences, such as animations and cutscenes, that are laid out frame by frame.
+// Does nothing if n isn't positive. Otherwise the same as calling WaitNextTick n times.
+func (e *Embeddable) WaitFrames(n int) {
+	e.checkpoint()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+	for i := 0; i < n; i++ {
+		e.WaitNextTick()
+	}
+}
+
+// Waits for s seconds of the coroutine's time, which for a coroutine started with WithDriver is frame time, so it
+// resumes during the first Tick that brings the total past s. The same as Pause otherwise.
+func (e *Embeddable) WaitSeconds(s float64) {
+	e.Pause(time.Duration(s * float64(time.Second)))
+}
@@ -1,29 +1,230 @@
 package coroutine
 
 import (
-	"time"
+	"context"
+	"log/slog"
+	"runtime"
 	"sync"
-	"log"
+	"sync/atomic"
+	"time"
 )
 
+// What every coroutine can do to itself. Embeddable is the real implementation; Function receives this interface
+// instead of an Embeddable so that coroutine bodies can be unit tested against a fake, such as the one in the
+// coroutinetest package, without any goroutines or timers.
+//
+// Everything else an Embeddable can do belongs to a small interface of its own, such as Replier or Linker, which a
+// coroutine body gets at with a type assertion when it needs it: `c.(coroutine.Replier).Reply(v)`.
+type Coroutine interface {
+	Pause(d time.Duration)
+	Yield()
+	Recv() interface{}
+	RecvFor(d time.Duration) (interface{}, bool)
+	RecvImmediate() (interface{}, bool)
+	After(d time.Duration, v interface{}) CancelFunc
+	Tick(interval time.Duration, v interface{}) CancelFunc
+	Now() time.Time
+	Logger() *slog.Logger
+	Context() context.Context
+	Stop()
+}
+
+var _ Coroutine = (*Embeddable)(nil)
+
 // The base struct that has all the functions necessary to operate on a coroutine. It is designed to be used in one of
 // two ways:
-// - As the argument to a func passed to one of the Start functions. It can then be used as a proxy for the
-//   coroutine function. e.g.: `msg := e.Recv()`
-// - Embedded directly into another struct, so that the struct can call the coroutine functions as if they were its
-//   own. e.g.: `type A struct { coroutine.Embeddable }` The struct that embeds the coroutine struct can then
-//   implement the Start method on the Starter interface and an instance of it can be passed directly to one of the
-//   Start functions. Embeddable MUST be embedded as a non-pointer, and the struct embedding it MUST be used as a
-//   pointer.
+//   - As the argument to a func passed to one of the Start functions. It can then be used as a proxy for the
+//     coroutine function. e.g.: `msg := e.Recv()`
+//   - Embedded directly into another struct, so that the struct can call the coroutine functions as if they were its
+//     own. e.g.: `type A struct { coroutine.Embeddable }` The struct that embeds the coroutine struct can then
+//     implement the Start method on the Starter interface and an instance of it can be passed directly to one of the
+//     Start functions. Embeddable MUST be embedded as a non-pointer, and the struct embedding it MUST be used as a
+//     pointer.
 type Embeddable struct {
-	id           uint64
-	name         string
-	waitTimer    *time.Timer
-	receiver     chan bool
-	receiveTimer *time.Timer
-	mailbox      []interface{}
-	mailboxLock  sync.Mutex
-	running      bool
+	// What ref returns. Replaced by a new one whenever the Embeddable is started again, other than by Restart.
+	self  *embeddableRef
+	id    uint64
+	name  string
+	clock Clock
+	// Used by both Pause and RecvFor. Only one of them can be waiting at any one time, so they can share it. Created
+	// from the clock the first time it's needed.
+	timer    Timer
+	receiver chan bool
+	mailbox  mailQueue
+	// Set by WithRingMailbox and WithMailbox.
+	ringMailbox   bool
+	customMailbox Mailbox
+	// How the coroutine is blocked on receiver, if it is, accessed atomically. See park.
+	parked int32
+	// Non-zero while the coroutine hasn't been asked to stop. Accessed atomically through isRunning and setRunning.
+	running int32
+	// Closed once the coroutine has completely finished running, so helpers running alongside it know to stop.
+	done chan struct{}
+	// How many messages have been put into the mailbox and taken out by the Recv functions since the coroutine was
+	// started, accessed atomically.
+	enqueued uint64
+	dequeued uint64
+	// Unix nanoseconds according to its clock, accessed atomically, since Restart sets them again while Stats and
+	// Info may be reading them. finished is zero until the coroutine finishes.
+	started      int64
+	lastActivity int64
+	finished     int64
+	// What the coroutine is doing right now, accessed atomically.
+	state int32
+	// Unix nanoseconds of real time when the coroutine last called one of its own methods, accessed atomically. Used
+	// by the watchdog to spot stalls.
+	lastCall int64
+	// The id of the goroutine the coroutine runs on, accessed atomically. Zero until it is running.
+	goid uint64
+	// What to call once the coroutine finishes, and how it finished once exited is set. Guarded by the link lock,
+	// which also guards the links and monitors in its extras. trapExit is set by WithTrapExit.
+	linkLock   sync.Mutex
+	exited     bool
+	exitReason ExitReason
+	exitPanic  interface{}
+	exitHooks  map[interface{}]func()
+	trapExit   bool
+	// Library control messages waiting to be handled by the coroutine itself, separately from its mailbox.
+	// sysPending is set while there are any, and accessed atomically.
+	sysLock    sync.Mutex
+	sysQueue   []func(e *Embeddable)
+	sysPending int32
+	// The value of every message sent with SendAcked that hasn't been acknowledged yet, kept across restarts. onAck
+	// is set by WithAckHandler.
+	ackLock sync.Mutex
+	unacked map[AckToken]interface{}
+	onAck   func(token AckToken)
+	// Set by WithUniqueName and WithLabels.
+	unique bool
+	labels map[string]string
+	// The id of the coroutine's parent, or zero.
+	parent uint64
+	// How many times the coroutine has been restarted, accessed atomically. body and opts are what it was started
+	// with, resetState is the Starter's ResetState if it's a Resetter, and restarting is set by Restart for start.
+	restarts    uint64
+	restartLock sync.Mutex
+	body        func()
+	opts        []Option
+	resetState  func()
+	restarting  bool
+	// snapshotter is the Starter if it's a Snapshotter, and snapshotSeq is how a Persistent says which event its
+	// state is up to. Both are set before start, so they're kept here rather than in the extras.
+	snapshotter Snapshotter
+	snapshotSeq func() uint64
+	// Whether to look up the sender of every message, and the sender of the message most recently received.
+	recordSenders bool
+	sender        Ref
+	// Whether to deliver every message in an Envelope.
+	envelopes bool
+	// The correlation id of the message most recently received, if it came from Call or Ask.
+	call uint64
+	// The correlation ids of every Ask still waiting for this coroutine to reply. Once callsClosed is set, the
+	// coroutine has stopped and no more can be added.
+	callLock    sync.Mutex
+	calls       map[uint64]struct{}
+	callsClosed bool
+	// The context of the message most recently received, and what to call once the coroutine is done with it.
+	msgCtx        context.Context
+	endProcessing func()
+	// The body of the coroutine's goroutine, and whether it has been created yet, accessed atomically. Only created
+	// once something needs the coroutine when lazy is set by WithLazyStart. goroutine is done once it has ended, well
+	// after done is closed, which Restart needs before it can reuse the Embeddable.
+	run       func()
+	launched  int32
+	lazy      bool
+	goroutine sync.WaitGroup
+	// Set by WithPooling. owned is set when the Embeddable was created by StartFuncName rather than embedded in a
+	// Starter, which is the only kind that can be pooled, and recycled when it came from the pool, until start has
+	// reused what it can of it.
+	pooled   bool
+	owned    bool
+	recycled bool
+	// Incremented each time a pooled Embeddable goes back in the pool, accessed atomically. Refs to the coroutine it
+	// ran before then are stale, and act as if it has finished.
+	generation uint64
+	// Non-zero while the coroutine is suspended by Suspend or StartFuncPaused, accessed atomically.
+	suspended int32
+	// Set by WithMaxLifetime and WithDeadline. Zero if not given.
+	maxLifetime time.Duration
+	deadline    time.Time
+	// Set by WithIdleTimeout. Zero if the coroutine can wait in Recv for as long as it likes.
+	idleTimeout time.Duration
+	// Set by WithRateLimit to limit SendBlocking and TrySend.
+	limiter *tokenBucket
+	// Where received messages are recorded, if anywhere.
+	recording *Recording
+	logger    *slog.Logger
+	// Where the coroutine was started from, only recorded while VerifyNone is interested in it.
+	startStack []uintptr
+	// Only set for coroutines run by a Scheduler. Everything below is guarded by the scheduler's lock.
+	sched        *Scheduler
+	schedResume  chan struct{}
+	schedBlocked bool
+	schedRecv    bool
+	schedUntil   time.Time
+	// Set while the coroutine is blocked because it's suspended, rather than waiting for a message or time.
+	schedSuspended bool
+	// Set while the coroutine is blocked in WaitNextTick, and once it has been woken by a tick rather than anything
+	// else.
+	schedTick   bool
+	schedTicked bool
+	// How many steps have run someone else while this coroutine was ready, which raises its priority. See Step.
+	schedPassed int
+	// Set by WithPriority.
+	priority int
+	// Everything that most coroutines never use, allocated by extra the first time any of it is needed, and thrown
+	// away by start. Accessed atomically, since other goroutines reach into it, such as Stats and Link.
+	ext atomic.Pointer[extras]
+}
+
+// The parts of an Embeddable that most coroutines never need, kept apart so that those that don't need them don't
+// pay for them. Replaced as a whole each time the coroutine is started, rather than reset field by field.
+type extras struct {
+	// Coroutines linked to this one and coroutines monitoring it. Guarded by the link lock of the Embeddable.
+	links    map[*Embeddable]struct{}
+	monitors map[*Embeddable]struct{}
+	// What to call as soon as the coroutine is told to stop, registered by Do and Guard.
+	interruptLock sync.Mutex
+	interrupts    map[*interrupt]struct{}
+	// Values handed over by Transfer that haven't been taken yet. Only touched by the coroutine's own goroutine.
+	transfers []transfer
+	// Set by WithDeduplication.
+	dedup *dedupWindow
+	// Set by WithCredits to hold back SendWithCredit.
+	credits *creditGate
+	// Set by WithSnapshots. snapshotRestored is set once the coroutine's state has been restored, and can be saved
+	// over.
+	snapshotId       string
+	snapshotStore    SnapshotStore
+	snapshotRestored bool
+	// Every Behavior passed to Become that hasn't been removed with Unbecome, with the current one last. Only touched
+	// by the coroutine itself.
+	behaviors []Behavior
+	// Set by StartServer and StartBehavior to swap the coroutine's handler for Replace. Only touched by the
+	// coroutine itself.
+	replacer func(handler interface{}) error
+	// Handlers passed to Replace that are waiting for the coroutine to finish the message it's handling, and
+	// whether it's waiting in its receive loop for the next one, when they can be swapped in straight away. Only
+	// touched by the coroutine itself.
+	replacements    []replacement
+	betweenMessages bool
+	// How long messages wait in the mailbox, and how long the coroutine spends on each one. handlingSince is when it
+	// received the message it's working on right now, or zero, and is only touched by the coroutine itself.
+	queueTime     histogram
+	handleTime    histogram
+	handlingSince time.Time
+}
+
+// The coroutine's extras, allocating them if it has none yet.
+func (e *Embeddable) extra() *extras {
+	if x := e.ext.Load(); x != nil {
+		return x
+	}
+	x := &extras{queueTime: newHistogram(), handleTime: newHistogram()}
+	if e.ext.CompareAndSwap(nil, x) {
+		return x
+	}
+	return e.ext.Load()
 }
 
 // Pauses execution of this coroutine for the given duration to allow other coroutines to run.
@@ -31,34 +232,82 @@ type Embeddable struct {
 // If this coroutine has been stopped by external code using the Ref returned by all Start functions, then it will
 // immediately stop, and no further code outside of deferred functions will be executed in this coroutine.
 func (e *Embeddable) Pause(d time.Duration) {
-	if !e.running {
+	e.checkpoint()
+	if !e.isRunning() {
 		// Every coroutine is wrapped in a function that recovers from a panic, so this is guaranteed to immediately
 		// stop execution of the coroutine completely without stopping the rest of the program.
 		panic(Stop{})
 	}
 
-	resetTimer(e.waitTimer, d)
-	<-e.waitTimer.C
+	e.trace(TracePause, nil, d)
+	e.wait(false, d)
+	e.touch()
 
 	// Since there's a period of time that this is doing nothing, there's a chance that external code could stop
 	// this coroutine while it's paused. So we check that before returning control to the coroutine.
-	if !e.running {
+	if !e.isRunning() {
 		panic(Stop{})
 	}
 }
 
-// Instead of constructing a new Timer object, which can be expensive if done frequently, we reset an existing one
-// to a given duration immediately before using it.
-func resetTimer(t *time.Timer, d time.Duration) {
-	if !t.Stop() {
-		// I've seen cases where Stop returns false, but there isn't an item in the channel, causing the receive to
-		// hang forever.
-		select {
-		case <-t.C:
-		default:
+// Gives other coroutines a chance to run, for long computations that never otherwise wait on anything. Call it every
+// so often inside the loop and the coroutine still stops promptly when asked, handles library control messages such
+// as Suspend, and doesn't hold up others sharing the CPU. Under a Scheduler or Driver, whatever else is ready runs
+// before this coroutine carries on, unless it has a lower priority, and a Driver's budget can end the frame here.
+// Otherwise it's runtime.Gosched, so it costs far less than Pause(0), which sets a timer.
+//
+// If this coroutine has been stopped by external code using the Ref returned by all Start functions, then it will
+// immediately stop, and no further code outside of deferred functions will be executed in this coroutine.
+func (e *Embeddable) Yield() {
+	e.checkpoint()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+	if e.sched == nil {
+		runtime.Gosched()
+		return
+	}
+
+	e.sched.block(e, false, 0)
+	e.checkpoint()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+}
+
+// Pauses this coroutine until cond returns true, checking it once every pollInterval. Returns true as soon as cond
+// does, or false if timeout passes first. A timeout <= 0 means wait for as long as it takes. cond is always checked
+// once before pausing, so a condition that is already true returns immediately.
+//
+// If this coroutine has been stopped by external code using the Ref returned by all Start functions, then it will
+// stop at the next poll, and no further code outside of deferred functions will be executed in this coroutine.
+func (e *Embeddable) WaitFor(cond func() bool, pollInterval, timeout time.Duration) bool {
+	e.checkpoint()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = e.clock.Now().Add(timeout)
+	}
+	for {
+		if cond() {
+			return true
 		}
+
+		wait := pollInterval
+		if !deadline.IsZero() {
+			remaining := deadline.Sub(e.clock.Now())
+			if remaining <= 0 {
+				return false
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+		e.Pause(wait)
 	}
-	t.Reset(d)
 }
 
 // Checks the mailbox for any sent messages. If none are in the mailbox, this function will halt the coroutine until
@@ -67,36 +316,41 @@ func resetTimer(t *time.Timer, d time.Duration) {
 // If this coroutine has been stopped by external code using the Ref returned by all Start functions, then it will
 // immediately stop, and no further code outside of deferred functions will be executed in this coroutine.
 func (e *Embeddable) Recv() interface{} {
-	if !e.running {
+	e.checkpoint()
+	e.finishHandling()
+	if !e.isRunning() {
 		panic(Stop{})
 	}
 
-	e.mailboxLock.Lock()
-	if len(e.mailbox) == 0 {
-		e.mailboxLock.Unlock()
-		<-e.receiver
+	for {
+		m, ok := e.take()
+		if !ok {
+			e.wait(true, -1)
 
-		if !e.running {
-			panic(Stop{})
+			if !e.isRunning() {
+				panic(Stop{})
+			}
+			continue
+		}
+		if !e.expired(m) {
+			return e.received(m)
 		}
-
-		e.mailboxLock.Lock()
 	}
-
-	r := e.mailbox[0]
-	e.mailbox = e.mailbox[1:]
-	e.mailboxLock.Unlock()
-	return r
 }
 
 // Checks if the mailbox contains anything. If it does, that value and true are returned. If it doesn't, the
 // coroutine will pause for up to duration time. If a value is put into the mailbox within that time, that value and
 // true are returned. If nothing was put into the mailbox during that time, nil and false are returned.
 //
+// This is also the way to sleep without missing messages, for polling loops that do periodic work while still
+// handling messages promptly: it pauses for up to d, but wakes as soon as a message arrives.
+//
 // If this coroutine has been stopped by external code using the Ref returned by all Start functions, then it will
 // immediately stop, and no further code outside of deferred functions will be executed in this coroutine.
 func (e *Embeddable) RecvFor(d time.Duration) (interface{}, bool) {
-	if !e.running {
+	e.checkpoint()
+	e.finishHandling()
+	if !e.isRunning() {
 		panic(Stop{})
 	}
 
@@ -104,32 +358,30 @@ func (e *Embeddable) RecvFor(d time.Duration) (interface{}, bool) {
 		return e.RecvImmediate()
 	}
 
-	e.mailboxLock.Lock()
-	if len(e.mailbox) == 0 {
-		e.mailboxLock.Unlock()
+	until := e.clock.Now().Add(d)
+	for {
+		m, ok := e.take()
+		if !ok {
+			e.wait(true, d)
 
-		resetTimer(e.receiveTimer, d)
-		select {
-		case <-e.receiver:
-		case <-e.receiveTimer.C:
-		}
+			if !e.isRunning() {
+				panic(Stop{})
+			}
 
-		if !e.running {
-			panic(Stop{})
+			if m, ok = e.take(); !ok {
+				return nil, false
+			}
 		}
 
-		e.mailboxLock.Lock()
-	}
-
-	if len(e.mailbox) == 0 {
-		e.mailboxLock.Unlock()
-		return nil, false
+		if !e.expired(m) {
+			return e.received(m), true
+		}
+		// Carry on waiting for whatever is left of d.
+		d = until.Sub(e.clock.Now())
+		if d <= 0 {
+			return e.RecvImmediate()
+		}
 	}
-
-	r := e.mailbox[0]
-	e.mailbox = e.mailbox[1:]
-	e.mailboxLock.Unlock()
-	return r, true
 }
 
 // Checks if the mailbox contains anything. If it doesn't, nil and false are returned. If something is in the mailbox,
@@ -138,29 +390,315 @@ func (e *Embeddable) RecvFor(d time.Duration) (interface{}, bool) {
 // If this coroutine has been stopped by external code using the Ref returned by all Start functions, then it will
 // immediately stop, and no further code outside of deferred functions will be executed in this coroutine.
 func (e *Embeddable) RecvImmediate() (interface{}, bool) {
-	if !e.running {
+	e.checkpoint()
+	e.finishHandling()
+	if !e.isRunning() {
 		panic(Stop{})
 	}
 
-	e.mailboxLock.Lock()
-	defer e.mailboxLock.Unlock()
-	if len(e.mailbox) == 0 {
-		return nil, false
+	for {
+		m, ok := e.take()
+		if !ok {
+			return nil, false
+		}
+		if !e.expired(m) {
+			return e.received(m), true
+		}
+	}
+}
+
+// Puts v into this coroutine's own mailbox once d has passed, to be picked up by one of the Recv functions. Unlike
+// time.After, the timer is stopped as soon as the coroutine stops, so a coroutine that stops early leaves nothing
+// behind. The returned CancelFunc prevents the message from being delivered if it hasn't been already.
+func (e *Embeddable) After(d time.Duration, v interface{}) CancelFunc {
+	e.checkpoint()
+	return e.ref().SendAfter(v, d)
+}
+
+// Puts v into this coroutine's own mailbox every time the interval passes, to be picked up by one of the Recv
+// functions. Unlike time.Tick, the ticker is stopped as soon as the coroutine stops. The returned CancelFunc stops
+// it earlier.
+func (e *Embeddable) Tick(interval time.Duration, v interface{}) CancelFunc {
+	e.checkpoint()
+	return e.ref().SendEvery(v, interval)
+}
+
+// The current time according to the Clock this coroutine uses for all of its waits. Prefer this over time.Now in
+// coroutines that may be run with a fake clock.
+func (e *Embeddable) Now() time.Time {
+	e.checkpoint()
+	return e.clock.Now()
+}
+
+// The coroutine that sent the message this coroutine most recently received, so that it can be replied to. Known for
+// replies sent with ReplyTo and Envelopes sent with From set. For coroutines started with WithSenders, also known
+// for any message sent from inside another coroutine. nil otherwise, including for messages sent by timers such as
+// SendAfter.
+func (e *Embeddable) Sender() Ref {
+	e.checkpoint()
+	return e.sender
+}
+
+// Called by every Recv variant with each message it's about to hand to the coroutine, once it has been taken out of
+// the mailbox. Anything that needs to see every message as it's received belongs here. Returns the message's value.
+func (e *Embeddable) received(m mail) interface{} {
+	if _, ok := m.v.(stopWhenReached); ok {
+		e.setRunning(false)
+		panic(Stop{})
+	}
+	if m.received != nil {
+		close(m.received)
+	}
+	e.touch()
+	now := e.clock.Now()
+	x := e.extra()
+	x.queueTime.observe(now.Sub(m.at))
+	x.handlingSince = now
+	e.sender = m.from
+	e.call = m.call
+	e.startProcessing(m)
+	if e.recording != nil {
+		e.recording.record(e.clock.Now().Sub(e.startedAt()), m.v)
+	}
+	e.trace(TraceRecv, m.v, 0)
+	return e.unwrap(m)
+}
+
+// Puts a message into the mailbox and lets the coroutine know about it. If the coroutine has already stopped, the
+// message is dropped, since nothing will ever receive it.
+func (e *Embeddable) deliver(m mail) {
+	if !e.isRunning() {
+		deadLetter(DeadLetter{Reason: DeadLetterStopped, Value: m.v, To: e.ref(), From: m.from})
+		return
+	}
+
+	e.trace(TraceSend, m.v, 0)
+	m.at = e.clock.Now()
+	if env, ok := m.v.(Envelope); ok && m.from == nil {
+		m.from = env.From
+	}
+	if e.recordSenders && m.from == nil {
+		if from := callingCoroutine(); from != nil {
+			m.from = from.ref()
+		}
+	}
+	if e.duplicate(m) {
+		deadLetter(DeadLetter{Reason: DeadLetterDuplicate, Value: m.v, To: e.ref(), From: m.from})
+		return
+	}
+	atomic.AddUint64(&e.enqueued, 1)
+	if err := e.mailbox.put(m); err != nil {
+		e.rejected(m, err)
+		return
+	}
+
+	// If the coroutine is waiting on the mailbox, let it know. Otherwise continue immediately so the sender
+	// doesn't get blocked. Order is already settled by this point: the message went in with a single atomic swap,
+	// after anything this sender put in before it, and the coroutine only ever takes messages from the front. A
+	// wakeup that the coroutine doesn't need just finds the mailbox as it is, and one it does need is never lost,
+	// since the receiver channel holds it until the coroutine next waits.
+	e.notify()
+}
+
+// Whether a message is a duplicate that WithDeduplication says to drop.
+func (e *Embeddable) duplicate(m mail) bool {
+	x := e.ext.Load()
+	if x == nil || x.dedup == nil {
+		return false
+	}
+	id, ok := messageId(m.v)
+	return ok && x.dedup.duplicate(id, m.at)
+}
+
+// A single message sitting in a mailbox, along with everything the library carries alongside it.
+type mail struct {
+	v   interface{}
+	ctx context.Context
+	// When the message was put into the mailbox, according to the coroutine's clock.
+	at time.Time
+	// The coroutine that sent the message, if it's known.
+	from Ref
+	// The correlation id of the Call waiting for a reply to the message, or zero.
+	call uint64
+	// How long the message can wait in the mailbox before it's dead-lettered instead of received, or zero.
+	ttl time.Duration
+	// Closed once the message is received, for SendSync. nil for anything else.
+	received chan struct{}
+}
+
+// Blocks the coroutine until something it's waiting for happens. If recv is true, it returns once there is a message
+// in the mailbox. If d >= 0, it returns once the duration has passed. It also returns as soon as the coroutine is
+// asked to stop, so the caller must check running afterwards either way. System messages are handled while it waits,
+// without it returning.
+func (e *Embeddable) wait(recv bool, d time.Duration) {
+	if recv {
+		e.setState(StateWaiting)
+	} else {
+		e.setState(StatePaused)
 	}
+	defer func() {
+		e.checkpoint()
+		e.setState(StateRunning)
+	}()
 
-	r := e.mailbox[0]
-	e.mailbox = e.mailbox[1:]
-	return r, true
+	if !recv && d < 0 {
+		d = 0
+	}
+	var deadline, idleDeadline time.Time
+	if d >= 0 {
+		deadline = e.clock.Now().Add(d)
+	}
+	if recv && e.idleTimeout > 0 {
+		idleDeadline = e.clock.Now().Add(e.idleTimeout)
+	}
+	for {
+		wait := d
+		if !idleDeadline.IsZero() {
+			if idle := idleDeadline.Sub(e.clock.Now()); wait < 0 || idle < wait {
+				wait = idle
+			}
+			if wait < 0 {
+				wait = 0
+			}
+		}
+		e.waitOnce(recv, wait)
+		e.handleSystem()
+		if !e.isRunning() {
+			return
+		}
+		if recv && !e.mailbox.empty() {
+			return
+		}
+		if !idleDeadline.IsZero() && !e.clock.Now().Before(idleDeadline) {
+			e.logDebug("Coroutine stopped after waiting too long for a message.")
+			e.setRunning(false)
+			return
+		}
+		if d >= 0 {
+			// Woken early by something other than what it's waiting for, so wait out the rest of the duration.
+			if d = deadline.Sub(e.clock.Now()); d <= 0 {
+				return
+			}
+		}
+	}
+}
+
+// Blocks until notify is called, or d passes if d >= 0. Wakeups can be spurious, which wait takes care of.
+func (e *Embeddable) waitOnce(recv bool, d time.Duration) {
+	if e.sched != nil {
+		e.sched.block(e, recv, d)
+		return
+	}
+
+	how := parkedPause
+	if recv {
+		how = parkedRecv
+	}
+	if d < 0 {
+		e.park(how, nil)
+		return
+	}
+	e.startTimer(d)
+	e.park(how, e.timer.Chan())
+	e.timer.Stop()
+}
+
+// How the coroutine is blocked on its receiver channel, if it is, so that notify knows whether to wake it.
+const (
+	notParked int32 = iota
+	parkedRecv
+	parkedPause
+	parkedSuspended
+)
+
+// Blocks on the receiver channel, or until timer fires if it isn't nil, with parked set to how. Returns straight away
+// if something it would be woken for has already happened, since notify can't have seen it parked.
+func (e *Embeddable) park(how int32, timer <-chan time.Time) {
+	atomic.StoreInt32(&e.parked, how)
+	// Checked the same way as wakesFor, except that one waiting for a message only needs to wait if there isn't one.
+	ready := how != parkedRecv && e.wakesFor(how)
+	if how == parkedRecv {
+		ready = !e.mailbox.empty() || !e.isRunning() || atomic.LoadInt32(&e.sysPending) != 0
+	}
+	if !ready {
+		select {
+		case <-e.receiver:
+		case <-timer:
+		}
+	}
+	atomic.StoreInt32(&e.parked, notParked)
+}
+
+// Whether notify needs to wake a coroutine parked in the given way. One waiting for a message is woken by anything,
+// while one that is paused or suspended only needs to be woken to stop, handle system messages, or be resumed.
+func (e *Embeddable) wakesFor(how int32) bool {
+	switch {
+	case how == parkedRecv, !e.isRunning(), atomic.LoadInt32(&e.sysPending) != 0:
+		return true
+	case how == parkedSuspended:
+		return !e.isSuspended()
+	}
+	return false
+}
+
+// Lets the coroutine know that something it might be waiting for has happened: a message arrived, it was stopped, or
+// a system message arrived. Never blocks, apart from creating the goroutine of a coroutine that hasn't got one yet.
+// Only the first of any number of notifications while the coroutine is parked wakes it, and one that arrives while
+// it isn't parked costs nothing beyond an atomic load, since it will see what happened before it next parks.
+func (e *Embeddable) notify() {
+	// Whatever it is, a coroutine started with WithLazyStart needs to be running to deal with it.
+	e.launch()
+	if e.sched != nil {
+		e.sched.wake(e)
+		return
+	}
+
+	how := atomic.LoadInt32(&e.parked)
+	if how != notParked && e.wakesFor(how) && atomic.CompareAndSwapInt32(&e.parked, how, notParked) {
+		select {
+		case e.receiver <- true:
+		default:
+		}
+	}
+}
+
+// Sets the coroutine's timer to fire once d has passed, creating it from the coroutine's clock the first time.
+func (e *Embeddable) startTimer(d time.Duration) {
+	if e.timer == nil {
+		e.timer = e.clock.NewTimer(d)
+	} else {
+		e.timer.Reset(d)
+	}
+}
+
+func (e *Embeddable) isRunning() bool {
+	return atomic.LoadInt32(&e.running) != 0
+}
+
+func (e *Embeddable) setRunning(running bool) {
+	var v int32
+	if running {
+		v = 1
+	}
+	atomic.StoreInt32(&e.running, v)
+	if !running {
+		e.interruptBlocked()
+	}
+}
+
+// A Ref to this coroutine, for the times it needs to use the same functionality as external code does.
+// Always the same pointer, so Refs to the same coroutine compare equal.
+func (e *Embeddable) ref() *embeddableRef {
+	return e.self
 }
 
 // Immediately stop this coroutine. No more code in the coroutine will run, so be sure to do any cleanup work before
 // calling this function, or have a deferred function that will do your cleanup work.
 func (e *Embeddable) Stop() {
-	if !e.running {
-		log.Printf("Coroutine [%v / %s] attempted to stop itself when it isn't running, possible bug found.",
-			e.id, e.name)
+	e.checkpoint()
+	if !e.isRunning() {
+		e.logWarn("Coroutine attempted to stop itself when it isn't running, possible bug found.")
 	}
-	e.running = false
+	e.setRunning(false)
 	panic(Stop{})
 }
-
@@ -0,0 +1,63 @@
+package coroutine
+
+// A message along with the metadata that usually ends up being packed into every message struct by hand. Coroutines
+// started with WithEnvelopes receive every message wrapped in one. An Envelope can also be sent directly to any
+// coroutine, in which case its From is what the receiver's Sender returns.
+type Envelope struct {
+	// The coroutine that sent the message, if it's known. Where replies go with ReplyTo.
+	From    Ref
+	Payload interface{}
+	Headers map[string]string
+	// Set on messages that came from Call or Ask, so that ReplyTo answers the call even once other messages have been
+	// received since.
+	CorrelationId uint64
+}
+
+// Makes the coroutine receive every message as an Envelope. Messages that were sent as Envelopes arrive as they
+// are, with From filled in if it was left empty; anything else becomes the Payload of a new Envelope. From is found
+// the same way as with WithSenders, which this implies.
+func WithEnvelopes() Option {
+	return func(e *Embeddable) {
+		e.envelopes = true
+		e.recordSenders = true
+	}
+}
+
+// Sends v back to whoever sent env, returning false if that isn't known. If env came from Call or Ask, the reply goes
+// to the waiting caller instead, or to dead letters if it has given up. The reply carries this coroutine as its sender,
+// so a coroutine receiving envelopes gets it with From set, and can reply in turn. Headers aren't copied into the
+// reply; send an Envelope directly to set them.
+func (e *Embeddable) ReplyTo(env Envelope, v interface{}) bool {
+	e.checkpoint()
+	if env.CorrelationId != 0 {
+		return e.replyToCall(env.CorrelationId, v)
+	}
+	if env.From == nil {
+		return false
+	}
+	if to, ok := localRef(env.From); ok {
+		to.e.deliver(mail{v: v, from: e.ref()})
+	} else {
+		env.From.Send(v)
+	}
+	return true
+}
+
+// What the coroutine receives for a message: the message itself, or the message in an Envelope for coroutines
+// started with WithEnvelopes.
+func (e *Embeddable) unwrap(m mail) interface{} {
+	if !e.envelopes {
+		return m.v
+	}
+	env, ok := m.v.(Envelope)
+	if !ok {
+		env = Envelope{Payload: m.v}
+	}
+	if env.From == nil {
+		env.From = m.from
+	}
+	if env.CorrelationId == 0 {
+		env.CorrelationId = m.call
+	}
+	return env
+}
@@ -0,0 +1,185 @@
+package coroutine
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// What a subscriber receives for every message published to a topic it's subscribed to.
+type Event struct {
+	Topic   string
+	Payload interface{}
+}
+
+// Delivers messages published to a topic to every coroutine subscribed to it, so that many publishers can reach many
+// subscribers without knowing about each other. Each subscriber gets its own copy of every Event in its mailbox.
+// Safe for concurrent use. The zero value isn't usable; create one with NewEventBus.
+//
+// Topics are hierarchical, with segments separated by dots, such as "orders.eu.created". Subscriptions can use
+// wildcards to cover a family of topics: "*" matches exactly one segment, so "orders.*.created" matches
+// "orders.eu.created" but not "orders.eu.north.created", and ">" as the last segment matches one or more segments, so
+// "orders.>" matches every topic under "orders". Topics given to Publish are always taken literally.
+type EventBus struct {
+	lock sync.Mutex
+	// Subscribers by the topic or pattern they subscribed with.
+	topics map[string]map[Ref]struct{}
+	// The patterns in topics that contain wildcards, split into segments, which Publish has to check one by one.
+	patterns map[string][]string
+}
+
+// Identifies a subscription when it's registered to be cleaned up once its coroutine finishes.
+type subscription struct {
+	bus   *EventBus
+	topic string
+}
+
+// Creates an EventBus with no subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		topics:   make(map[string]map[Ref]struct{}),
+		patterns: make(map[string][]string),
+	}
+}
+
+// Subscribes the referenced coroutine to the topic, so it receives an Event for everything published to it. The
+// topic may be a pattern with wildcards, in which case it receives everything published to a matching topic.
+// Subscribing more than once has no extra effect. Coroutines started by this package are unsubscribed automatically
+// when they finish; any other Ref stays subscribed until Unsubscribe is called.
+func (b *EventBus) Subscribe(topic string, r Ref) {
+	b.lock.Lock()
+	subscribers := b.topics[topic]
+	if subscribers == nil {
+		subscribers = make(map[Ref]struct{})
+		b.topics[topic] = subscribers
+		if segments := strings.Split(topic, "."); isPattern(segments) {
+			b.patterns[topic] = segments
+		}
+	}
+	subscribers[r] = struct{}{}
+	b.lock.Unlock()
+
+	if ref, ok := r.(*embeddableRef); ok {
+		// Added first, so that a coroutine finishing at the same time is either cleaned up by its exit hook, or found
+		// to have finished here.
+		registered := ref.onExit(subscription{b, topic}, func() {
+			b.remove(topic, r)
+		})
+		if !registered {
+			b.remove(topic, r)
+		}
+	}
+}
+
+// Stops the referenced coroutine receiving Events for the topic.
+func (b *EventBus) Unsubscribe(topic string, r Ref) {
+	if ref, ok := r.(*embeddableRef); ok {
+		ref.cancelOnExit(subscription{b, topic})
+	}
+	b.remove(topic, r)
+}
+
+func (b *EventBus) remove(topic string, r Ref) {
+	b.lock.Lock()
+	subscribers := b.topics[topic]
+	delete(subscribers, r)
+	if len(subscribers) == 0 {
+		delete(b.topics, topic)
+		delete(b.patterns, topic)
+	}
+	b.lock.Unlock()
+}
+
+// Every coroutine subscribed with exactly the given topic or pattern, ordered by id.
+func (b *EventBus) Subscribers(topic string) []Ref {
+	b.lock.Lock()
+	subscribers := make([]Ref, 0, len(b.topics[topic]))
+	for r := range b.topics[topic] {
+		subscribers = append(subscribers, r)
+	}
+	b.lock.Unlock()
+
+	sortRefs(subscribers)
+	return subscribers
+}
+
+// Every coroutine that would receive a message published to the topic, whether it subscribed to the topic itself
+// or to a matching pattern, ordered by id. Each appears once, however many of its subscriptions match.
+func (b *EventBus) Matching(topic string) []Ref {
+	b.lock.Lock()
+	matched := make(map[Ref]struct{})
+	for r := range b.topics[topic] {
+		matched[r] = struct{}{}
+	}
+	if len(b.patterns) > 0 {
+		segments := strings.Split(topic, ".")
+		for pattern, patternSegments := range b.patterns {
+			if pattern != topic && matchTopic(patternSegments, segments) {
+				for r := range b.topics[pattern] {
+					matched[r] = struct{}{}
+				}
+			}
+		}
+	}
+	b.lock.Unlock()
+
+	subscribers := make([]Ref, 0, len(matched))
+	for r := range matched {
+		subscribers = append(subscribers, r)
+	}
+	sortRefs(subscribers)
+	return subscribers
+}
+
+func sortRefs(refs []Ref) {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Id() < refs[j].Id()
+	})
+}
+
+// Whether any of the segments is a wildcard.
+func isPattern(segments []string) bool {
+	for _, segment := range segments {
+		if segment == "*" || segment == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a topic matches a pattern, both split into segments.
+func matchTopic(pattern, topic []string) bool {
+	for i, segment := range pattern {
+		if segment == ">" && i == len(pattern)-1 {
+			return len(topic) > i
+		}
+		if i >= len(topic) || (segment != "*" && segment != topic[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
+
+// Every topic or pattern with at least one subscriber, sorted.
+func (b *EventBus) Topics() []string {
+	b.lock.Lock()
+	topics := make([]string, 0, len(b.topics))
+	for topic := range b.topics {
+		topics = append(topics, topic)
+	}
+	b.lock.Unlock()
+
+	sort.Strings(topics)
+	return topics
+}
+
+// Sends an Event with the topic and v to every subscriber of the topic and of every matching pattern, in order of id,
+// and returns how many it was sent to. Never blocks on the subscribers.
+func (b *EventBus) Publish(topic string, v interface{}) int {
+	subscribers := b.Matching(topic)
+	ev := Event{Topic: topic, Payload: v}
+	for _, r := range subscribers {
+		r.Send(ev)
+	}
+	return len(subscribers)
+}
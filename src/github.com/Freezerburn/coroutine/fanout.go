@@ -0,0 +1,94 @@
+package coroutine
+
+// Starts a coroutine that forwards every message it receives to each of dests, and returns its Ref for src to send to.
+// Once src finishes, however it finishes, the fan-out forwards whatever src sent before then and returns, and each dest
+// that is still running is stopped once it has received everything forwarded to it. Dests that finish on their own are
+// dropped, and the fan-out returns once none are left. Stopping the fan-out itself doesn't affect src or any dest.
+//
+// Only coroutines started by this package are watched for finishing. If src is anything else, the fan-out keeps
+// running until it is stopped or runs out of dests.
+func FanOut(src Ref, dests ...Ref) Ref {
+	dests = append([]Ref(nil), dests...)
+	return StartFuncName("FanOut", func(c Coroutine) {
+		c.(Linker).Monitor(src)
+		live := make(map[Ref]bool, len(dests))
+		for _, d := range dests {
+			c.(Linker).Monitor(d)
+			live[d] = true
+		}
+
+		for len(live) > 0 {
+			v := c.Recv()
+			if x, ok := v.(Exit); ok {
+				if x.From == src {
+					for _, d := range dests {
+						if live[d] {
+							stopWhenDone(d)
+						}
+					}
+					return
+				}
+				if live[x.From] {
+					delete(live, x.From)
+					continue
+				}
+			}
+			for _, d := range dests {
+				if live[d] {
+					d.Send(v)
+				}
+			}
+		}
+	})
+}
+
+// Starts a coroutine that forwards every message it receives to dest, and returns its Ref for each of srcs to send
+// to. Once every src has finished, however they finish, the fan-in forwards whatever they sent before then and
+// returns, and dest is stopped once it has received everything forwarded to it. It also returns if dest finishes
+// first. Stopping the fan-in itself doesn't affect dest or any src.
+//
+// Only coroutines started by this package are watched for finishing. If any src is anything else, or there are no
+// srcs, the fan-in keeps running until it is stopped or dest finishes.
+func FanIn(dest Ref, srcs ...Ref) Ref {
+	srcs = append([]Ref(nil), srcs...)
+	return StartFuncName("FanIn", func(c Coroutine) {
+		c.(Linker).Monitor(dest)
+		live := make(map[Ref]bool, len(srcs))
+		for _, s := range srcs {
+			c.(Linker).Monitor(s)
+			live[s] = true
+		}
+
+		for {
+			v := c.Recv()
+			if x, ok := v.(Exit); ok {
+				if x.From == dest {
+					return
+				}
+				if live[x.From] {
+					delete(live, x.From)
+					if len(live) == 0 {
+						stopWhenDone(dest)
+						return
+					}
+					continue
+				}
+			}
+			dest.Send(v)
+		}
+	})
+}
+
+// Put in a mailbox to stop the coroutine as soon as it's received, so that everything sent before it is still
+// handled.
+type stopWhenReached struct{}
+
+// Stops the referenced coroutine once it has received everything already sent to it. Anything that isn't a coroutine
+// started by this package is stopped straight away instead.
+func stopWhenDone(r Ref) {
+	if _, ok := localRef(r); ok {
+		r.Send(stopWhenReached{})
+		return
+	}
+	r.Stop()
+}
@@ -0,0 +1,204 @@
+package coroutine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// What happened to a file watched by WatchFiles.
+type FileOp int
+
+const (
+	// The file didn't exist, and now does.
+	FileCreated FileOp = iota
+	// The file's size or modification time changed.
+	FileModified
+	// The file existed, and now doesn't.
+	FileRemoved
+)
+
+func (op FileOp) String() string {
+	switch op {
+	case FileCreated:
+		return "created"
+	case FileModified:
+		return "modified"
+	case FileRemoved:
+		return "removed"
+	}
+	return fmt.Sprintf("FileOp(%d)", int(op))
+}
+
+// The message subscribers of WatchFiles receive for every change to a watched file.
+type FileEvent struct {
+	Path string
+	Op   FileOp
+}
+
+// What WatchFiles knows about a file when it last looked. A file that doesn't exist has the zero value.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// A change that has been seen but not sent yet, because the file hasn't been left alone for long enough.
+type pendingFile struct {
+	state fileState
+	since time.Time
+}
+
+// What the coroutine started by WatchFiles sends itself every interval.
+type fileWatchTick struct{}
+
+// Starts a coroutine that watches files for changes, and sends a FileEvent to every subscriber for each one, such as
+// for reloading configuration when it's edited or rebuilding assets when they change. Each path is either a file, or
+// a directory, in which case every file directly inside it is watched, including ones created later. It works by
+// looking at every file every interval, so it needs nothing from the operating system, and works the same on every
+// platform and filesystem. A file or directory that's removed and created again, as editors often do when saving, is
+// watched again as it reappears.
+//
+// A change is only sent once the file has stayed the same for settle, so a file written in several steps gives one
+// event rather than one per step. With a settle of zero, every change is sent from the first look that sees it.
+// What's there when the coroutine starts isn't reported.
+//
+// Subscribers that finish are dropped, and the coroutine returns once none are left, or straight away if there were
+// none.
+func WatchFiles(paths []string, interval, settle time.Duration, subscribers ...Ref) Ref {
+	paths = append([]string(nil), paths...)
+	subscribers = append([]Ref(nil), subscribers...)
+	return StartFuncName("WatchFiles", func(c Coroutine) {
+		e := c.(*Embeddable)
+		live := make(map[Ref]bool, len(subscribers))
+		for _, s := range subscribers {
+			e.Monitor(s)
+			live[s] = true
+		}
+
+		known := e.scanFiles(paths, nil)
+		pending := make(map[string]pendingFile)
+		c.Tick(interval, fileWatchTick{})
+		for len(live) > 0 {
+			switch v := c.Recv().(type) {
+			case Exit:
+				delete(live, v.From)
+			case fileWatchTick:
+				now := c.Now()
+				current := e.scanFiles(paths, known)
+				var events []FileEvent
+				for _, path := range changedFiles(known, current) {
+					state := current[path]
+					p, ok := pending[path]
+					if !ok || p.state != state {
+						p = pendingFile{state: state, since: now}
+						pending[path] = p
+					}
+					if now.Sub(p.since) < settle {
+						continue
+					}
+					delete(pending, path)
+					events = append(events, FileEvent{Path: path, Op: fileOp(known[path], state)})
+					if state.exists {
+						known[path] = state
+					} else {
+						delete(known, path)
+					}
+				}
+				// Changes that were undone before they settled.
+				for path := range pending {
+					if current[path] == known[path] {
+						delete(pending, path)
+					}
+				}
+				for _, event := range events {
+					for _, s := range subscribers {
+						if live[s] {
+							s.Send(event)
+						}
+					}
+				}
+			}
+		}
+	})
+}
+
+// Looks at every file under paths. A file that can't be looked at for a reason other than not existing keeps the
+// state it had in previous, since nothing is known to have happened to it, and the error is logged.
+func (e *Embeddable) scanFiles(paths []string, previous map[string]fileState) map[string]fileState {
+	files := make(map[string]fileState)
+	add := func(path string, info fs.FileInfo) {
+		files[path] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+	}
+	failed := func(path string, err error) {
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		e.logError("Couldn't look at watched file.", "path", path, "error", err)
+		for p, state := range previous {
+			if p == path || filepath.Dir(p) == filepath.Clean(path) {
+				files[p] = state
+			}
+		}
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			failed(path, err)
+			continue
+		}
+		if !info.IsDir() {
+			add(path, info)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			failed(path, err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			file := filepath.Join(path, entry.Name())
+			if info, err := entry.Info(); err == nil {
+				add(file, info)
+			} else {
+				failed(file, err)
+			}
+		}
+	}
+	return files
+}
+
+// Every path that's different in current from what's known, sorted so events come out in the same order each time.
+func changedFiles(known, current map[string]fileState) []string {
+	var changed []string
+	for path, state := range current {
+		if known[path] != state {
+			changed = append(changed, path)
+		}
+	}
+	for path := range known {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func fileOp(from, to fileState) FileOp {
+	switch {
+	case !from.exists:
+		return FileCreated
+	case !to.exists:
+		return FileRemoved
+	}
+	return FileModified
+}
@@ -0,0 +1,141 @@
+package coroutine
+
+import (
+	"sync"
+	"time"
+)
+
+// The eventual answer to a message sent with Ask. It is resolved exactly once: with the reply, or with an error if
+// the coroutine stopped without replying or the Future was cancelled. Safe to use from multiple goroutines.
+type Future struct {
+	done chan struct{}
+	lock sync.Mutex
+	v    interface{}
+	err  error
+	// Undoes whatever is waiting to resolve the Future, if anything is.
+	cancel func()
+	// What Await's timeout is measured on: the Clock of the coroutine expected to resolve the Future, if it's known,
+	// or the default Clock.
+	clock Clock
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{}), clock: defaultClock()}
+}
+
+// A Future that has already been resolved with the given result.
+func Resolved(v interface{}, err error) *Future {
+	f := newFuture()
+	f.resolve(v, err)
+	return f
+}
+
+// Resolves the Future, returning false if it was already resolved.
+func (f *Future) resolve(v interface{}, err error) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	select {
+	case <-f.done:
+		return false
+	default:
+	}
+	f.v, f.err = v, err
+	close(f.done)
+	return true
+}
+
+// Closed once the Future is resolved, for waiting on several at once with select.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Whether the Future has been resolved yet. Never blocks.
+func (f *Future) Ready() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Waits up to timeout for the Future to be resolved, then returns its result. A timeout <= 0 waits for as long as it
+// takes. If the timeout passes first, ErrCallTimeout is returned and the Future is left as it is, so it can be
+// awaited again or cancelled.
+func (f *Future) Await(timeout time.Duration) (interface{}, error) {
+	if timeout > 0 {
+		t := f.clock.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case <-f.done:
+		case <-t.Chan():
+			return nil, ErrCallTimeout
+		}
+	} else {
+		<-f.done
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.v, f.err
+}
+
+// Gives up on the Future, resolving it with ErrCancelled if it hasn't been resolved yet. A reply that arrives
+// afterwards goes to dead letters.
+func (f *Future) Cancel() {
+	f.lock.Lock()
+	cancel := f.cancel
+	f.cancel = nil
+	f.lock.Unlock()
+
+	if f.resolve(nil, ErrCancelled) && cancel != nil {
+		cancel()
+	}
+}
+
+// A Future resolved with the result of calling fn with this one's value, once it's resolved. If this Future resolves
+// with an error, fn isn't called and the new Future gets the same error. Cancelling the new Future also cancels
+// this one.
+func (f *Future) Then(fn func(v interface{}) (interface{}, error)) *Future {
+	next := newFuture()
+	next.cancel = f.Cancel
+	next.clock = f.clock
+	go func() {
+		<-f.done
+		v, err := f.Await(0)
+		if err == nil {
+			v, err = fn(v)
+		}
+		next.resolve(v, err)
+	}()
+	return next
+}
+
+// A Future resolved with the values of every given Future, in the same order, once they have all resolved. If any of
+// them resolves with an error, it resolves straight away with that error instead. Cancelling it cancels all of them.
+func All(futures ...*Future) *Future {
+	all := newFuture()
+	all.cancel = func() {
+		for _, f := range futures {
+			f.Cancel()
+		}
+	}
+	go func() {
+		values := make([]interface{}, len(futures))
+		for i, f := range futures {
+			select {
+			case <-f.done:
+			case <-all.done:
+				return
+			}
+			v, err := f.Await(0)
+			if err != nil {
+				all.resolve(nil, err)
+				return
+			}
+			values[i] = v
+		}
+		all.resolve(values, nil)
+	}()
+	return all
+}
@@ -0,0 +1,68 @@
+package coroutine
+
+import (
+	"sync/atomic"
+)
+
+// What Next sends a generator's coroutine to ask it for the next value.
+type generatorNext struct{}
+
+// What a generator's coroutine replies to Next with, so that a nil value can't be mistaken for anything else.
+type generated[T any] struct {
+	v T
+}
+
+// Pulls values one at a time from a coroutine started by Generator.
+type Iterator[T any] struct {
+	r Ref
+	// Set once Next has seen the coroutine finish, or Stop has been called, accessed atomically.
+	finished int32
+}
+
+// Starts f as a coroutine that produces values for whoever pulls them with Next, for lazy sequences that are easiest
+// written as a loop, such as reading a paginated API or walking a tree. Each call to yield hands a value to the Next
+// waiting for it, and then waits for the one after, so f only ever runs while someone is waiting for its next value.
+// f doesn't start until the first Next. yield must only be called from f's own goroutine.
+//
+// Once f returns, every Next fails. If the values aren't wanted to the end, call Stop, which stops the coroutine so
+// that yield never returns, and only f's deferred functions run. Otherwise the coroutine waits for the next Next
+// forever.
+func Generator[T any](f func(yield func(T)), opts ...Option) *Iterator[T] {
+	r := StartFuncName("Generator", func(c Coroutine) {
+		c.Recv()
+		f(func(v T) {
+			c.(Replier).Reply(generated[T]{v: v})
+			c.Recv()
+		})
+	}, opts...)
+	return &Iterator[T]{r: r}
+}
+
+// Runs the generator until it yields its next value, and returns it. Returns false once it has returned, panicked or
+// been stopped. Safe to call from any goroutine, including other coroutines, though each value only goes to one of
+// them.
+func (it *Iterator[T]) Next() (T, bool) {
+	var zero T
+	if atomic.LoadInt32(&it.finished) != 0 {
+		return zero, false
+	}
+	v, err := Call(it.r, generatorNext{}, 0)
+	if err != nil {
+		atomic.StoreInt32(&it.finished, 1)
+		return zero, false
+	}
+	return v.(generated[T]).v, true
+}
+
+// Stops the generator's coroutine, if it hasn't finished already. Every Next from then on returns false.
+func (it *Iterator[T]) Stop() {
+	atomic.StoreInt32(&it.finished, 1)
+	if it.r.Running() {
+		it.r.Stop()
+	}
+}
+
+// The generator's coroutine, for monitoring it or seeing it in List.
+func (it *Iterator[T]) Ref() Ref {
+	return it.r
+}
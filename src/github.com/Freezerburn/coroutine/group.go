@@ -0,0 +1,92 @@
+package coroutine
+
+import (
+	"sort"
+	"sync"
+)
+
+// Every group's members, by group name.
+var (
+	groups     = make(map[string]map[Ref]struct{})
+	groupsLock sync.Mutex
+)
+
+// Identifies a group membership when it's registered to be cleaned up once its coroutine finishes.
+type groupMembership string
+
+// Adds the referenced coroutine to the named group, creating the group if it's the first member. Joining a group
+// more than once has no extra effect. Coroutines started by this package leave all of their groups automatically
+// when they finish; any other Ref stays until LeaveGroup is called.
+func JoinGroup(group string, r Ref) {
+	groupsLock.Lock()
+	members := groups[group]
+	if members == nil {
+		members = make(map[Ref]struct{})
+		groups[group] = members
+	}
+	members[r] = struct{}{}
+	groupsLock.Unlock()
+
+	if ref, ok := r.(*embeddableRef); ok {
+		registered := ref.onExit(groupMembership(group), func() {
+			leave(group, r)
+		})
+		if !registered {
+			leave(group, r)
+		}
+	}
+}
+
+// Removes the referenced coroutine from the named group. The group stops existing once its last member leaves.
+func LeaveGroup(group string, r Ref) {
+	if ref, ok := r.(*embeddableRef); ok {
+		ref.cancelOnExit(groupMembership(group))
+	}
+	leave(group, r)
+}
+
+func leave(group string, r Ref) {
+	groupsLock.Lock()
+	members := groups[group]
+	delete(members, r)
+	if len(members) == 0 {
+		delete(groups, group)
+	}
+	groupsLock.Unlock()
+}
+
+// Every member of the named group, ordered by id. Empty if the group doesn't exist.
+func GroupMembers(group string) []Ref {
+	groupsLock.Lock()
+	members := make([]Ref, 0, len(groups[group]))
+	for r := range groups[group] {
+		members = append(members, r)
+	}
+	groupsLock.Unlock()
+
+	sortRefs(members)
+	return members
+}
+
+// The names of every group with at least one member, sorted.
+func Groups() []string {
+	groupsLock.Lock()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	groupsLock.Unlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Sends v to every member of the named group, in order of id, and returns how many members it was sent to. The
+// members are looked up once, so a coroutine joining while this runs might not receive it.
+func GroupSend(group string, v interface{}) int {
+	members := GroupMembers(group)
+	for _, r := range members {
+		r.Send(v)
+	}
+	return len(members)
+}
@@ -0,0 +1,141 @@
+// Lets a bidirectional gRPC stream be handled by a coroutine: every message received on the stream lands in the
+// coroutine's mailbox, and everything sent to the Out it's given is sent on the stream, so a streaming RPC handler can
+// be written as a coroutine reacting to messages. The package doesn't depend on gRPC. It works with a Stream, which
+// grpc.ServerStream and grpc.ClientStream already are, so a handler for a generated service only needs
+//
+//	func (s *server) Chat(stream pb.Chat_ChatServer) error {
+//		return grpcbridge.Serve(stream, func() interface{} { return new(pb.Message) }, s.chat)
+//	}
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/Freezerburn/coroutine"
+)
+
+// What the bridge needs from a gRPC stream. Both grpc.ServerStream and grpc.ClientStream have these methods.
+type Stream interface {
+	Context() context.Context
+	SendMsg(m interface{}) error
+	RecvMsg(m interface{}) error
+}
+
+// The message the coroutine receives once nothing more will arrive on the stream. Err is nil if the other side
+// closed its half of the stream, after which messages can still be sent to it. Otherwise it's the error receiving
+// failed with, such as the RPC being cancelled or its deadline passing, and the stream can't be used any more.
+type Closed struct {
+	Err error
+}
+
+// A coroutine handling a stream, started by Serve. Whatever it returns ends the RPC: nil for success, or an error,
+// usually made with the status package, for the status the other side sees.
+type Handler func(c coroutine.Coroutine, out *Out) error
+
+// Runs h as a coroutine handling the stream, and waits for it to finish. Every message received on the stream, each
+// decoded into a new value from newMsg, is sent to the coroutine, followed by a Closed once there are no more. Meant
+// to be called straight from a streaming RPC method, which returns what Serve does, or on a goroutine of its own for
+// a client stream.
+//
+// Returns the error h returned. If h returned nil, or the coroutine was stopped, the error receiving from or sending to
+// the stream failed with is returned instead, so a broken stream is never reported as a success. The goroutine
+// receiving from the stream only stops once RecvMsg fails, which for a server stream happens as soon as the method
+// returns, and for a client stream once its context is canceled.
+func Serve(stream Stream, newMsg func() interface{}, h Handler, opts ...coroutine.Option) error {
+	out := &Out{stream: stream}
+	var handlerErr error
+	r := coroutine.StartFuncName("grpcbridge", func(c coroutine.Coroutine) {
+		handlerErr = h(c, out)
+	}, opts...)
+
+	go func() {
+		for {
+			m := newMsg()
+			if err := stream.RecvMsg(m); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				} else {
+					out.fail(err)
+				}
+				r.Send(Closed{Err: err})
+				return
+			}
+			r.Send(m)
+		}
+	}()
+
+	// Waits from a coroutine of its own, which is told once the handler's coroutine has finished however it
+	// finished.
+	finished := make(chan struct{})
+	coroutine.StartFuncName("grpcbridge wait", func(c coroutine.Coroutine) {
+		c.(coroutine.Linker).Monitor(r)
+		for {
+			if _, ok := c.Recv().(coroutine.Exit); ok {
+				close(finished)
+				return
+			}
+		}
+	})
+	<-finished
+	if handlerErr != nil {
+		return handlerErr
+	}
+	return out.Err()
+}
+
+// A coroutine.Ref for the sending half of a stream: Send sends the value on it as it is, so it has to be a message
+// of the type the RPC sends. Anything that takes a Ref, such as the subscribers of an EventBus, can send on the stream
+// through one. Safe for concurrent use, unlike the stream itself.
+//
+// Nothing can report a failed send to the caller of Send, so the first error the stream fails with is kept for Err,
+// and everything sent after it is dropped. Name is "grpcbridge" and Id is always 0. Stop does nothing.
+type Out struct {
+	stream Stream
+	lock   sync.Mutex
+	err    error
+}
+
+var _ coroutine.Ref = (*Out)(nil)
+
+// The first error sending or receiving on the stream failed with, or nil if it hasn't.
+func (o *Out) Err() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.err
+}
+
+func (o *Out) fail(err error) {
+	o.lock.Lock()
+	if o.err == nil {
+		o.err = err
+	}
+	o.lock.Unlock()
+}
+
+func (o *Out) Send(v interface{}) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.err != nil {
+		return
+	}
+	o.err = o.stream.SendMsg(v)
+}
+
+// False once the stream's context is done or sending on it has failed.
+func (o *Out) Running() bool {
+	return o.stream.Context().Err() == nil && o.Err() == nil
+}
+
+func (o *Out) Name() string {
+	return "grpcbridge"
+}
+
+func (o *Out) Id() uint64 {
+	return 0
+}
+
+func (o *Out) Stop() {
+}
@@ -0,0 +1,73 @@
+package coroutine
+
+import (
+	"time"
+)
+
+// Implemented by Refs that can check whether their coroutine is responsive, used by Ping.
+type Pinger interface {
+	Ping(timeout time.Duration) Health
+}
+
+// Checks whether the coroutine behind r is still responsive, waiting up to timeout for it to answer, as described on
+// the Ping method of the Ref of a coroutine started by this package. A Ref that isn't a Pinger never answers.
+func Ping(r Ref, timeout time.Duration) Health {
+	if p, ok := refAs[Pinger](r); ok {
+		return p.Ping(timeout)
+	}
+	return Health{}
+}
+
+// The result of Ping.
+type Health struct {
+	// Whether the coroutine answered within the timeout. A coroutine that is running but didn't answer is most likely
+	// wedged: stuck in its own code without calling any of its methods.
+	Alive bool
+	// How long the coroutine took to answer. Only meaningful if Alive is true.
+	Latency time.Duration
+	// What the coroutine was doing once the ping finished.
+	State State
+	// How many messages were waiting in the mailbox once the ping finished. A coroutine that is alive but has a
+	// growing mailbox is busy rather than wedged.
+	MailboxLen int
+}
+
+// Checks whether the coroutine this references is still responsive, waiting up to timeout for it to answer. The
+// probe is a system message, answered by the library rather than by the coroutine's own code, so it never appears in
+// the mailbox: a coroutine waiting in Recv or Pause answers straight away, and one running its own code answers the
+// next time it calls any of its methods. A coroutine that has stopped never answers.
+func (r *embeddableRef) Ping(timeout time.Duration) Health {
+	e := r.e
+	if r.stale() {
+		return Health{State: StateStopping}
+	}
+	begin := e.clock.Now()
+	if !e.isRunning() {
+		return e.health(false, 0)
+	}
+
+	answered := make(chan struct{})
+	e.system(func(e *Embeddable) {
+		close(answered)
+	})
+
+	t := e.clock.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-answered:
+		return e.health(true, e.clock.Now().Sub(begin))
+	case <-e.done:
+	case <-t.Chan():
+	}
+	return e.health(false, 0)
+}
+
+func (e *Embeddable) health(alive bool, latency time.Duration) Health {
+	info := e.info()
+	return Health{
+		Alive:      alive,
+		Latency:    latency,
+		State:      info.State,
+		MailboxLen: info.MailboxLen,
+	}
+}
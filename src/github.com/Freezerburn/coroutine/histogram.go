@@ -0,0 +1,117 @@
+package coroutine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// The upper bounds of every Histogram's buckets, in a 1-2-5 series from a microsecond up to a minute.
+var histogramBounds = func() []time.Duration {
+	var bounds []time.Duration
+	for d := time.Microsecond; d < time.Minute; d *= 10 {
+		bounds = append(bounds, d, 2*d, 5*d)
+	}
+	return append(bounds, time.Minute)
+}()
+
+// A snapshot of how a set of durations is distributed, as found in Stats.
+type Histogram struct {
+	// The upper bound of each bucket, in increasing order.
+	Bounds []time.Duration
+	// How many durations fell into each bucket: Counts[i] is the number that were <= Bounds[i] but greater than the
+	// bound before it. There is one more count than bounds, for the durations longer than the last bound.
+	Counts []uint64
+	// How many durations were recorded, and their total.
+	Count uint64
+	Sum   time.Duration
+}
+
+// The average of every recorded duration, or zero if there are none.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// An estimate of the duration that q of the recorded durations are less than or equal to, where q is between 0 and
+// 1. The estimate is the upper bound of the bucket the quantile falls in, so it is never an underestimate, except
+// for durations longer than the last bound, which are reported as the last bound. Zero if nothing was recorded.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	if rank >= h.Count {
+		rank = h.Count - 1
+	}
+	var seen uint64
+	for i, n := range h.Counts {
+		seen += n
+		if seen > rank {
+			if i < len(h.Bounds) {
+				return h.Bounds[i]
+			}
+			break
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// Records durations into buckets. Safe to observe from one goroutine while others take snapshots, once created with
+// newHistogram.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(histogramBounds) && d > histogramBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func newHistogram() histogram {
+	return histogram{counts: make([]uint64, len(histogramBounds)+1)}
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: append([]time.Duration(nil), histogramBounds...),
+		Counts: make([]uint64, len(h.counts)),
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return s
+}
+
+// Adds the counts of two histograms together. Either may be empty, such as the zero Histogram.
+func (h Histogram) merge(o Histogram) Histogram {
+	if len(h.Counts) == 0 {
+		return o
+	}
+	if len(o.Counts) == 0 {
+		return h
+	}
+	merged := Histogram{
+		Bounds: h.Bounds,
+		Counts: make([]uint64, len(h.Counts)),
+		Count:  h.Count + o.Count,
+		Sum:    h.Sum + o.Sum,
+	}
+	for i := range merged.Counts {
+		merged.Counts[i] = h.Counts[i]
+		if i < len(o.Counts) {
+			merged.Counts[i] += o.Counts[i]
+		}
+	}
+	return merged
+}
@@ -0,0 +1,106 @@
+package coroutine
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Returned by writes to the ResponseWriter in an HTTPRequest once the request it was for has finished.
+var ErrResponseFinished = errors.New("coroutine: response already finished")
+
+// The first message a coroutine started by Handler receives, holding the request it's handling and where to write the
+// response to it.
+type HTTPRequest struct {
+	Request *http.Request
+	Writer  http.ResponseWriter
+}
+
+// Runs every request a server receives in a coroutine of its own, for handlers that are easier to write as a
+// coroutine, such as ones that wait on replies from several others. Each request starts f as a new coroutine, named
+// after the request's method and path, which receives an HTTPRequest as its first message. That message carries the
+// request's context, so the coroutine's Context returns it. The coroutine writes the response to Writer and then
+// calls Reply, with any value, to say it's done, which is also what finishing without replying does.
+//
+// If the request's context ends first, because the client went away or the server timed the request out, the
+// coroutine is stopped. Either way, the request is over once ServeHTTP returns: writes to Writer from then on fail
+// with ErrResponseFinished rather than touching a ResponseWriter that's no longer in use, so a coroutine that's
+// still running, or that handed Writer to another, can't break the server.
+func Handler(f Function, opts ...Option) http.Handler {
+	return httpHandler{f: f, opts: opts}
+}
+
+type httpHandler struct {
+	f    Function
+	opts []Option
+}
+
+func (h httpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	hw := &httpWriter{w: w}
+	defer hw.finish()
+	e := newEmbeddable()
+	start(req.Method+" "+req.URL.Path, e, func() {
+		h.f(e)
+	}, h.opts)
+	r := e.ref()
+
+	ctx := req.Context()
+	f := r.ask(HTTPRequest{Request: req, Writer: hw}, ctx)
+	select {
+	case <-f.Done():
+	case <-ctx.Done():
+		f.Cancel()
+		if r.Running() {
+			r.Stop()
+		}
+	}
+}
+
+// Passes everything through to the server's ResponseWriter until the request has finished.
+type httpWriter struct {
+	lock     sync.Mutex
+	w        http.ResponseWriter
+	finished bool
+}
+
+// Once the request has finished, a new Header each time, which nothing reads.
+func (w *httpWriter) Header() http.Header {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.finished {
+		return make(http.Header)
+	}
+	return w.w.Header()
+}
+
+func (w *httpWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.finished {
+		return 0, ErrResponseFinished
+	}
+	return w.w.Write(b)
+}
+
+func (w *httpWriter) WriteHeader(status int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.finished {
+		w.w.WriteHeader(status)
+	}
+}
+
+// Sends whatever has been written so far to the client, if the server's ResponseWriter can.
+func (w *httpWriter) Flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if f, ok := w.w.(http.Flusher); ok && !w.finished {
+		f.Flush()
+	}
+}
+
+func (w *httpWriter) finish() {
+	w.lock.Lock()
+	w.finished = true
+	w.lock.Unlock()
+}
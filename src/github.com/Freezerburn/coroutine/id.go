@@ -0,0 +1,31 @@
+package coroutine
+
+import (
+	"sync/atomic"
+)
+
+// The id most recently given to a coroutine by the default generator, accessed atomically.
+var lastId uint64
+
+// Wraps the generator so that atomic.Value always stores the same concrete type.
+type idGeneratorHolder struct {
+	f func() uint64
+}
+
+var idGenerator atomic.Value
+
+// Sets what gives every coroutine started after this call its id, in place of the default counter that starts again
+// from 1 every time the program runs. Lets ids be unique across processes, such as by combining a machine id with a
+// timestamp and sequence number like a snowflake id does, so they can be used in distributed traces and to refer to
+// coroutines remotely. The generator is called concurrently, and must never return zero, which is used to mean no
+// coroutine, or an id it has already returned. Passing nil goes back to the default counter.
+func SetIdGenerator(f func() uint64) {
+	idGenerator.Store(idGeneratorHolder{f})
+}
+
+func newId() uint64 {
+	if holder, _ := idGenerator.Load().(idGeneratorHolder); holder.f != nil {
+		return holder.f()
+	}
+	return atomic.AddUint64(&lastId, 1)
+}
@@ -0,0 +1,101 @@
+package coroutine
+
+import (
+	"context"
+	"io"
+)
+
+// Something to call as soon as the coroutine is stopped, to interrupt whatever it's blocked on.
+type interrupt struct {
+	f func()
+}
+
+// Implemented by Embeddable for coroutines that make blocking calls Stop has to be able to interrupt.
+type Interruptible interface {
+	Do(f func(ctx context.Context) error) error
+	Guard(c io.Closer) (release func())
+}
+
+var _ Interruptible = (*Embeddable)(nil)
+
+// Runs f, which blocks on something other than the coroutine's own methods, such as a database query or an HTTP
+// request, with a context that's canceled as soon as the coroutine is stopped. Otherwise nothing could interrupt the
+// wait, and the coroutine wouldn't notice it had been stopped until it finished. The context comes from Context, so
+// it's also canceled along with the one carried by the message being handled. Returns f's error, unless the coroutine
+// was stopped meanwhile, in which case it stops as soon as f returns.
+func (e *Embeddable) Do(f func(ctx context.Context) error) error {
+	e.checkpoint()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+
+	ctx, cancel := context.WithCancel(e.Context())
+	defer cancel()
+	release := e.onStop(cancel)
+	err := f(ctx)
+	release()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+	return err
+}
+
+// Closes c as soon as the coroutine is stopped, until the returned func is called, so that a Read or Write on c
+// that's blocked, such as on a net.Conn, returns an error rather than leaving the coroutine unable to notice it has
+// been stopped. Usually used as
+//
+//	defer c.Guard(conn)()
+//
+// Once the blocked call returns, the coroutine stops at its next call to one of its own methods, as usual. c is
+// closed straight away if the coroutine has already been stopped.
+func (e *Embeddable) Guard(c io.Closer) (release func()) {
+	e.checkpoint()
+	return e.onStop(func() {
+		c.Close()
+	})
+}
+
+// Calls f once the coroutine is stopped, or straight away if it has been already, unless the returned func is called
+// first.
+func (e *Embeddable) onStop(f func()) (release func()) {
+	i := &interrupt{f: f}
+	x := e.extra()
+	x.interruptLock.Lock()
+	if x.interrupts == nil {
+		x.interrupts = make(map[*interrupt]struct{})
+	}
+	x.interrupts[i] = struct{}{}
+	x.interruptLock.Unlock()
+	// Checked again now that it's registered, in case the coroutine was stopped just before.
+	if !e.isRunning() {
+		e.interruptBlocked()
+	}
+	return func() {
+		x.interruptLock.Lock()
+		delete(x.interrupts, i)
+		x.interruptLock.Unlock()
+	}
+}
+
+// Calls everything registered with onStop. Called whenever the coroutine is told to stop.
+func (e *Embeddable) interruptBlocked() {
+	x := e.ext.Load()
+	if x == nil {
+		return
+	}
+	x.interruptLock.Lock()
+	if len(x.interrupts) == 0 {
+		x.interruptLock.Unlock()
+		return
+	}
+	interrupts := make([]*interrupt, 0, len(x.interrupts))
+	for i := range x.interrupts {
+		interrupts = append(interrupts, i)
+	}
+	x.interrupts = nil
+	x.interruptLock.Unlock()
+
+	for _, i := range interrupts {
+		i.f()
+	}
+}
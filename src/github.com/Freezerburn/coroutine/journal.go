@@ -0,0 +1,182 @@
+package coroutine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Returned by Journal.Append when the events don't follow on from the last one stored, because something else has
+// appended to the same persistence id in the meantime, such as a second copy of the same persistent coroutine.
+var ErrJournalConflict = errors.New("coroutine: journal has events this coroutine hasn't seen")
+
+// Where a persistent coroutine stores its events, by persistence id, so that they can be replayed to recover its
+// state. Events are numbered from 1 for each id. Implementations backed by a database, a key-value store or files
+// let that state survive restarts of the program. Must be safe for concurrent use.
+type Journal interface {
+	// Stores events for id, numbered on from seq, which must be one more than the number of the last event stored for
+	// it. Returns ErrJournalConflict if it isn't. Either all of the events are stored or none of them are.
+	Append(id string, seq uint64, events []interface{}) error
+	// Calls f with every event stored for id numbered after the given one, oldest first, along with its number.
+	// Stops at the first error f returns and returns it.
+	Replay(id string, after uint64, f func(seq uint64, event interface{}) error) error
+}
+
+// A Journal that keeps events in memory, which lets persistent coroutines recover their state across restarts of the
+// coroutine, but not of the program. Mostly useful for tests.
+type MemoryJournal struct {
+	lock   sync.Mutex
+	events map[string][]interface{}
+}
+
+var _ Journal = (*MemoryJournal)(nil)
+
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{events: make(map[string][]interface{})}
+}
+
+func (j *MemoryJournal) Append(id string, seq uint64, events []interface{}) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if seq != uint64(len(j.events[id]))+1 {
+		return ErrJournalConflict
+	}
+	j.events[id] = append(j.events[id], events...)
+	return nil
+}
+
+func (j *MemoryJournal) Replay(id string, after uint64, f func(seq uint64, event interface{}) error) error {
+	j.lock.Lock()
+	events := j.events[id]
+	j.lock.Unlock()
+	for i := after; i < uint64(len(events)); i++ {
+		if err := f(i+1, events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Journal that keeps the events for each persistence id in a file of its own in a directory, appending to it and
+// syncing it to disk for every Append. Events are encoded with the Codec set with SetCodec, so the concrete type of
+// every event must be registered with it. Only one FileJournal, in one program, should use a directory at a time.
+type FileJournal struct {
+	dir string
+	// How many events each file that has been looked at holds, and how long it is up to the end of the last whole
+	// event, so that a file cut short by a crash can be truncated before it's appended to.
+	lock   sync.Mutex
+	counts map[string]journalFile
+}
+
+type journalFile struct {
+	events uint64
+	size   int64
+}
+
+var _ Journal = (*FileJournal)(nil)
+
+// Creates a FileJournal in dir, creating it if it doesn't exist.
+func NewFileJournal(dir string) (*FileJournal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileJournal{dir: dir, counts: make(map[string]journalFile)}, nil
+}
+
+func (j *FileJournal) path(id string) string {
+	return filepath.Join(j.dir, url.PathEscape(id)+".journal")
+}
+
+func (j *FileJournal) Append(id string, seq uint64, events []interface{}) error {
+	var buf []byte
+	for _, event := range events {
+		data, err := encodeValue(event)
+		if err != nil {
+			return err
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	jf, ok := j.counts[id]
+	if !ok {
+		var err error
+		if jf, err = j.scan(id, nil); err != nil {
+			return err
+		}
+	}
+	if seq != jf.events+1 {
+		return ErrJournalConflict
+	}
+
+	f, err := os.OpenFile(j.path(id), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Writing at the end of the last whole event overwrites anything a crash left behind.
+	if _, err := f.WriteAt(buf, jf.size); err != nil {
+		return err
+	}
+	if err := f.Truncate(jf.size + int64(len(buf))); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	j.counts[id] = journalFile{events: jf.events + uint64(len(events)), size: jf.size + int64(len(buf))}
+	return nil
+}
+
+func (j *FileJournal) Replay(id string, after uint64, f func(seq uint64, event interface{}) error) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	jf, err := j.scan(id, func(seq uint64, data []byte) error {
+		if seq <= after {
+			return nil
+		}
+		event, err := decodeValue(data)
+		if err != nil {
+			return fmt.Errorf("coroutine: can't decode event %d of %q: %w", seq, id, err)
+		}
+		return f(seq, event)
+	})
+	if err != nil {
+		return err
+	}
+	j.counts[id] = jf
+	return nil
+}
+
+// Reads the file for id, calling f, if it isn't nil, with each whole event in it. Must be called with the lock held.
+func (j *FileJournal) scan(id string, f func(seq uint64, data []byte) error) (journalFile, error) {
+	data, err := os.ReadFile(j.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return journalFile{}, nil
+	}
+	if err != nil {
+		return journalFile{}, err
+	}
+	var jf journalFile
+	for len(data) >= 4 {
+		n := binary.BigEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(n) {
+			break
+		}
+		jf.events++
+		if f != nil {
+			if err := f(jf.events, data[4:4+n]); err != nil {
+				return journalFile{}, err
+			}
+		}
+		data = data[4+n:]
+		jf.size += 4 + int64(n)
+	}
+	return jf, nil
+}
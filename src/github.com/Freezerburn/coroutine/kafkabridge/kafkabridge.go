@@ -0,0 +1,255 @@
+// Connects coroutines to a Kafka consumer group: a Group runs one coroutine for every partition assigned to this
+// consumer, sends it each record from that partition, and commits a record's offset only once the coroutine has
+// acknowledged it with Ack. The package doesn't depend on a Kafka client. Whichever one the program uses tells the
+// Group when partitions are assigned and revoked, and hands it the records it fetches. With github.com/IBM/sarama, a
+// ConsumerGroupHandler is enough:
+//
+//	type handler struct{ g *kafkabridge.Group }
+//
+//	func (handler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+//	func (handler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+//
+//	func (h handler) ConsumeClaim(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+//		h.g.Assign(c.Topic(), c.Partition(), committer{s})
+//		defer h.g.Revoke(c.Topic(), c.Partition())
+//		for m := range c.Messages() {
+//			h.g.Deliver(kafkabridge.Record{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Value: m.Value})
+//		}
+//		return nil
+//	}
+//
+//	type committer struct{ s sarama.ConsumerGroupSession }
+//
+//	func (c committer) Commit(topic string, partition int32, offset int64) error {
+//		c.s.MarkOffset(topic, partition, offset, "")
+//		return nil
+//	}
+package kafkabridge
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Freezerburn/coroutine"
+)
+
+// Returned by Deliver for a record from a partition that isn't assigned.
+var ErrNotAssigned = errors.New("kafkabridge: partition not assigned")
+
+// A record fetched from Kafka, which a partition's coroutine receives as the Value of a coroutine.Delivery.
+type Record struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+}
+
+// Decodes Value with the coroutine package's Codec, for records that were produced by something encoding them the
+// same way.
+func (r Record) Decode() (interface{}, error) {
+	return coroutine.CurrentCodec().Decode(r.Value)
+}
+
+// A partition of a topic.
+type Partition struct {
+	Topic     string
+	Partition int32
+}
+
+func (p Partition) String() string {
+	return fmt.Sprintf("%s/%d", p.Topic, p.Partition)
+}
+
+// Commits offsets for the consumer group. The offset is the one to start reading from next, one past the last record
+// that has been dealt with, as Kafka expects.
+type Committer interface {
+	Commit(topic string, partition int32, offset int64) error
+}
+
+// What a Group passes to its function for each partition's coroutine.
+type Function func(c coroutine.Coroutine, p Partition)
+
+// Runs a coroutine for every partition assigned to the consumer. Each receives the records from its partition, in
+// order, as a coroutine.Delivery holding a Record, and calls Ack with the Delivery's token once it has dealt with it.
+// Offsets are committed in order: a record's offset is only committed once it and every record before it in the
+// partition have been acknowledged, so after a rebalance or a crash the partition is read again from the first record
+// that wasn't finished with, and every record is dealt with at least once. Safe for concurrent use.
+type Group struct {
+	f    Function
+	opts []coroutine.Option
+
+	lock       sync.Mutex
+	partitions map[Partition]*assignment
+}
+
+// Everything a Group keeps for one assigned partition.
+type assignment struct {
+	partition Partition
+	committer Committer
+	ref       coroutine.Ref
+
+	// Every record delivered that hasn't been committed yet, in the order delivered, which is also the order of their
+	// tokens.
+	lock      sync.Mutex
+	pending   []pendingRecord
+	commitErr error
+}
+
+type pendingRecord struct {
+	token  coroutine.AckToken
+	offset int64
+	acked  bool
+}
+
+// Makes a Group that starts every partition's coroutine with f and the given options.
+func NewGroup(f Function, opts ...coroutine.Option) *Group {
+	return &Group{f: f, opts: opts, partitions: make(map[Partition]*assignment)}
+}
+
+// Starts a coroutine for the partition, committing its offsets with c. A partition that's already assigned is revoked
+// first.
+func (g *Group) Assign(topic string, partition int32, c Committer) {
+	p := Partition{Topic: topic, Partition: partition}
+	g.Revoke(topic, partition)
+
+	a := &assignment{partition: p, committer: c}
+	opts := append(append([]coroutine.Option(nil), g.opts...), coroutine.WithAckHandler(a.acked))
+	a.ref = coroutine.StartFuncName("kafkabridge "+p.String(), func(c coroutine.Coroutine) {
+		g.f(c, p)
+	}, opts...)
+
+	g.lock.Lock()
+	g.partitions[p] = a
+	g.lock.Unlock()
+}
+
+// Sends the record to its partition's coroutine. Returns ErrNotAssigned if the partition isn't assigned. Records sent
+// after the coroutine has finished are dead-lettered and never committed.
+func (g *Group) Deliver(r Record) error {
+	g.lock.Lock()
+	a := g.partitions[Partition{Topic: r.Topic, Partition: r.Partition}]
+	g.lock.Unlock()
+	if a == nil {
+		return ErrNotAssigned
+	}
+
+	// Held while sending, so that the record is pending before the coroutine can acknowledge it.
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	token := coroutine.SendAcked(a.ref, r)
+	a.pending = append(a.pending, pendingRecord{token: token, offset: r.Offset})
+	return nil
+}
+
+// Called from the coroutine for every token it acknowledges. Commits the offset after the last of the records at the
+// front of the partition that have all been acknowledged.
+func (a *assignment) acked(token coroutine.AckToken) {
+	a.lock.Lock()
+	i := sort.Search(len(a.pending), func(i int) bool {
+		return a.pending[i].token >= token
+	})
+	if i == len(a.pending) || a.pending[i].token != token {
+		// Sent some other way than by Deliver.
+		a.lock.Unlock()
+		return
+	}
+	a.pending[i].acked = true
+	done := 0
+	for done < len(a.pending) && a.pending[done].acked {
+		done++
+	}
+	if done == 0 {
+		a.lock.Unlock()
+		return
+	}
+	offset := a.pending[done-1].offset + 1
+	a.pending = append(a.pending[:0], a.pending[done:]...)
+	a.lock.Unlock()
+
+	// Only ever called from the coroutine, so commits happen one at a time and in order.
+	err := a.committer.Commit(a.partition.Topic, a.partition.Partition, offset)
+	a.lock.Lock()
+	if a.commitErr == nil {
+		a.commitErr = err
+	}
+	a.lock.Unlock()
+}
+
+// Stops the partition's coroutine and waits for it to finish, once the partition has been taken away from the consumer
+// or the consumer is shutting down, so nothing more is committed for it. Records it had been sent but hadn't
+// acknowledged are read again by whichever consumer the partition goes to next. Returns the first error from
+// committing the partition's offsets, if there was one. Does nothing for a partition that isn't assigned.
+func (g *Group) Revoke(topic string, partition int32) error {
+	p := Partition{Topic: topic, Partition: partition}
+	g.lock.Lock()
+	a := g.partitions[p]
+	delete(g.partitions, p)
+	g.lock.Unlock()
+	if a == nil {
+		return nil
+	}
+
+	// Monitored from a coroutine of its own, which is told once the partition's coroutine has finished, even if it
+	// already had.
+	finished := make(chan struct{})
+	coroutine.StartFuncName("kafkabridge revoke "+p.String(), func(c coroutine.Coroutine) {
+		c.(coroutine.Linker).Monitor(a.ref)
+		for {
+			if _, ok := c.Recv().(coroutine.Exit); ok {
+				close(finished)
+				return
+			}
+		}
+	})
+	if a.ref.Running() {
+		a.ref.Stop()
+	}
+	<-finished
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.commitErr
+}
+
+// Revokes every partition, returning the first error any of them returns.
+func (g *Group) RevokeAll() error {
+	var err error
+	for _, p := range g.Partitions() {
+		if revokeErr := g.Revoke(p.Topic, p.Partition); err == nil {
+			err = revokeErr
+		}
+	}
+	return err
+}
+
+// The coroutine for the partition, if it's assigned.
+func (g *Group) Ref(topic string, partition int32) (coroutine.Ref, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	a := g.partitions[Partition{Topic: topic, Partition: partition}]
+	if a == nil {
+		return nil, false
+	}
+	return a.ref, true
+}
+
+// The partitions currently assigned, sorted by topic and then partition.
+func (g *Group) Partitions() []Partition {
+	g.lock.Lock()
+	partitions := make([]Partition, 0, len(g.partitions))
+	for p := range g.partitions {
+		partitions = append(partitions, p)
+	}
+	g.lock.Unlock()
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+	return partitions
+}
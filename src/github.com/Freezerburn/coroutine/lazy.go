@@ -0,0 +1,25 @@
+package coroutine
+
+import (
+	"sync/atomic"
+)
+
+// Delays creating the coroutine's goroutine until something is sent to it, or it's stopped or pinged. Until then it
+// costs no more than its entry in the registry and its mailbox, so large numbers of named coroutines that may never
+// see any traffic can be started up front. It shows up in List as StateUnstarted. Ignored for coroutines run by a
+// Scheduler, which creates every goroutine straight away.
+func WithLazyStart() Option {
+	return func(e *Embeddable) {
+		e.lazy = true
+	}
+}
+
+// Creates the coroutine's goroutine, unless it has already been created.
+func (e *Embeddable) launch() {
+	if atomic.LoadInt32(&e.launched) != 0 || !atomic.CompareAndSwapInt32(&e.launched, 0, 1) {
+		return
+	}
+	atomic.CompareAndSwapInt32(&e.state, int32(StateUnstarted), int32(StateRunning))
+	e.goroutine.Add(1)
+	go e.run()
+}
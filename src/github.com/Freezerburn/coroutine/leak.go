@@ -0,0 +1,103 @@
+package coroutine
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The parts of testing.TB used by VerifyNone, so that this package doesn't need to import testing.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Cleanup(f func())
+}
+
+// How long VerifyNone gives coroutines to finish on their own before reporting them, since a coroutine that was just
+// stopped may still be running its deferred functions.
+const leakGracePeriod = time.Second
+
+// While above zero, every coroutine records the stack it was started from so that leaks can be reported usefully.
+var captureStacks int32
+
+// Fails the test if any coroutine started after this call is still running when the test ends. Call it at the
+// beginning of a test:
+//
+//	func TestSomething(t *testing.T) {
+//		coroutine.VerifyNone(t)
+//		...
+//	}
+//
+// Each leaked coroutine is reported with its id, name, and the stack it was started from. Coroutines that were
+// already running when VerifyNone was called are ignored.
+func VerifyNone(t TestingT) {
+	t.Helper()
+	before := make(map[uint64]bool)
+	for _, e := range liveCoroutines() {
+		before[e.id] = true
+	}
+	atomic.AddInt32(&captureStacks, 1)
+
+	t.Cleanup(func() {
+		defer atomic.AddInt32(&captureStacks, -1)
+		t.Helper()
+
+		var leaked []*Embeddable
+		deadline := time.Now().Add(leakGracePeriod)
+		for {
+			leaked = leaked[:0]
+			for _, e := range liveCoroutines() {
+				if !before[e.id] {
+					leaked = append(leaked, e)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(leaked) == 0 {
+			return
+		}
+
+		sort.Slice(leaked, func(i, j int) bool {
+			return leaked[i].id < leaked[j].id
+		})
+		var b strings.Builder
+		fmt.Fprintf(&b, "found %d leaked coroutine(s):", len(leaked))
+		for _, e := range leaked {
+			fmt.Fprintf(&b, "\n\nCoroutine [%v / %s] started at:\n%s", e.id, e.name, formatStack(e.startStack))
+		}
+		t.Errorf("%s", b.String())
+	})
+}
+
+// Records the stack of whoever is starting a coroutine, if anyone is interested in it. skip is the number of stack
+// frames between the caller of this function and the user's call to a Start function.
+func recordStartStack(skip int) []uintptr {
+	if atomic.LoadInt32(&captureStacks) == 0 {
+		return nil
+	}
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip+2, pcs)
+	return pcs[:n]
+}
+
+func formatStack(pcs []uintptr) string {
+	if len(pcs) == 0 {
+		return "\t(unknown)"
+	}
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,52 @@
+package coroutine
+
+import (
+	"time"
+)
+
+// Stops the coroutine once d has passed since it started, no matter what it's doing, as if it had been stopped
+// through its Ref. Useful for putting a hard bound on background jobs. Measured with the coroutine's clock.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(e *Embeddable) {
+		e.maxLifetime = d
+	}
+}
+
+// Stops the coroutine once its clock reaches t, no matter what it's doing, as if it had been stopped through its
+// Ref. A coroutine started after t is stopped straight away. If WithMaxLifetime is also given, whichever comes first
+// applies.
+func WithDeadline(t time.Time) Option {
+	return func(e *Embeddable) {
+		e.deadline = t
+	}
+}
+
+// Starts a goroutine to stop the coroutine once its deadline passes, if it has one. Called once the coroutine has
+// been set up, before it starts running.
+func (e *Embeddable) enforceDeadline() {
+	deadline := e.deadline
+	if e.maxLifetime > 0 {
+		if byLifetime := e.startedAt().Add(e.maxLifetime); deadline.IsZero() || byLifetime.Before(deadline) {
+			deadline = byLifetime
+		}
+	}
+	if deadline.IsZero() {
+		return
+	}
+
+	t := e.clock.NewTimer(deadline.Sub(e.startedAt()))
+	done := e.done
+	go func() {
+		defer t.Stop()
+		select {
+		case <-t.Chan():
+		case <-done:
+			return
+		}
+		if e.isRunning() {
+			e.logDebug("Coroutine stopped at its deadline.")
+			e.setRunning(false)
+			e.notify()
+		}
+	}()
+}
@@ -0,0 +1,286 @@
+package coroutine
+
+import (
+	"fmt"
+)
+
+// How a coroutine finished, as reported in Exit.
+type ExitReason int
+
+const (
+	// The coroutine's function returned.
+	ExitReturned ExitReason = iota
+	// The coroutine was stopped, by itself, through its Ref, or by a link.
+	ExitStopped
+	// The coroutine panicked with something other than Stop.
+	ExitPanicked
+	// The coroutine is in another process, and the connection to it was lost, or a Cluster found its node to be down.
+	// It may still be running.
+	ExitDisconnected
+)
+
+func (r ExitReason) String() string {
+	switch r {
+	case ExitReturned:
+		return "returned"
+	case ExitStopped:
+		return "stopped"
+	case ExitPanicked:
+		return "panicked"
+	case ExitDisconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("ExitReason(%d)", int(r))
+}
+
+// The message a coroutine receives when a coroutine it monitors finishes, or when a coroutine it is linked to
+// finishes and it was started with WithTrapExit.
+type Exit struct {
+	From   Ref
+	Reason ExitReason
+	// The value passed to panic, if Reason is ExitPanicked.
+	Panic interface{}
+}
+
+// Makes the coroutine receive an Exit message when a coroutine it's linked to is stopped or panics, instead of being
+// stopped along with it. This is what lets a coroutine supervise others: link to them, then restart them as their
+// Exit messages arrive.
+func WithTrapExit() Option {
+	return func(e *Embeddable) {
+		e.trapExit = true
+	}
+}
+
+// Implemented by Embeddable for coroutines that tie their lifetime to others, or watch them finish. A coroutine body
+// gets at it with `c.(coroutine.Linker)`.
+type Linker interface {
+	Link(r Ref)
+	Unlink(r Ref)
+	Monitor(r Ref)
+	Demonitor(r Ref)
+}
+
+var _ Linker = (*Embeddable)(nil)
+
+// Links this coroutine to the referenced one, in both directions: if either is stopped or panics, the other is
+// stopped too, unless it was started with WithTrapExit, in which case it receives an Exit message instead. Returning
+// normally doesn't affect the other coroutine. Linking to a coroutine that has already finished acts as if it
+// finished straight afterwards. Only coroutines started by this package can be linked; anything else is ignored.
+func (e *Embeddable) Link(r Ref) {
+	e.checkpoint()
+	other, ok := r.(*embeddableRef)
+	if ok && other.stale() {
+		e.handleLinkedExit(other.exit)
+		return
+	}
+	if !ok || other.e == e {
+		return
+	}
+	if !linkPair(e, other.e) {
+		e.linkedExit(other.e)
+	}
+}
+
+// Removes a link made with Link, in both directions. Does nothing if the coroutines aren't linked.
+func (e *Embeddable) Unlink(r Ref) {
+	e.checkpoint()
+	other, ok := localRef(r)
+	if !ok || other.e == e {
+		return
+	}
+	first, second := lockOrder(e, other.e)
+	first.linkLock.Lock()
+	second.linkLock.Lock()
+	e.forgetLink(other.e)
+	other.e.forgetLink(e)
+	second.linkLock.Unlock()
+	first.linkLock.Unlock()
+}
+
+// Makes this coroutine receive an Exit message once the referenced coroutine finishes, however it finishes. Unlike a
+// link, this only goes one way and never stops this coroutine. Monitoring a coroutine that has already finished
+// delivers its Exit straight away. Only coroutines started by this package can be monitored; anything else is
+// ignored.
+//
+// A coroutine in another process, referenced by a Ref from RemoteNode.Ref, LookupRemote or one of the Spawn functions,
+// is monitored over the connection to it. If the connection is lost, or a Cluster finds its node to be down, the Exit
+// has the reason ExitDisconnected, which is the cue for a supervisor, such as a RemoteSupervisor, to start it again
+// elsewhere. A panic arrives as its text.
+func (e *Embeddable) Monitor(r Ref) {
+	e.checkpoint()
+	if remote, ok := r.(*remoteRef); ok {
+		remote.monitor(e)
+		return
+	}
+	other, ok := r.(*embeddableRef)
+	if ok && other.stale() {
+		e.deliver(mail{v: other.exit, from: other})
+		return
+	}
+	if !ok || other.e == e {
+		return
+	}
+	o := other.e
+	o.linkLock.Lock()
+	if o.exited {
+		o.linkLock.Unlock()
+		e.deliver(mail{v: o.exit(), from: other})
+		return
+	}
+	x := o.extra()
+	if x.monitors == nil {
+		x.monitors = make(map[*Embeddable]struct{})
+	}
+	x.monitors[e] = struct{}{}
+	o.linkLock.Unlock()
+}
+
+// Stops monitoring a coroutine monitored with Monitor. An Exit that was already delivered stays in the mailbox.
+func (e *Embeddable) Demonitor(r Ref) {
+	e.checkpoint()
+	if remote, ok := r.(*remoteRef); ok {
+		remote.demonitor(e)
+		return
+	}
+	other, ok := localRef(r)
+	if !ok {
+		return
+	}
+	other.e.linkLock.Lock()
+	if x := other.e.ext.Load(); x != nil {
+		delete(x.monitors, e)
+	}
+	other.e.linkLock.Unlock()
+}
+
+// Links a and b to each other, returning false if either has already finished.
+func linkPair(a, b *Embeddable) bool {
+	first, second := lockOrder(a, b)
+	first.linkLock.Lock()
+	defer first.linkLock.Unlock()
+	second.linkLock.Lock()
+	defer second.linkLock.Unlock()
+	if a.exited || b.exited {
+		return false
+	}
+	ax, bx := a.extra(), b.extra()
+	if ax.links == nil {
+		ax.links = make(map[*Embeddable]struct{})
+	}
+	if bx.links == nil {
+		bx.links = make(map[*Embeddable]struct{})
+	}
+	ax.links[b] = struct{}{}
+	bx.links[a] = struct{}{}
+	return true
+}
+
+// Forgets that this coroutine is linked to peer. Must be called with the link lock held.
+func (e *Embeddable) forgetLink(peer *Embeddable) {
+	if x := e.ext.Load(); x != nil {
+		delete(x.links, peer)
+	}
+}
+
+// Orders two coroutines by id, so that their link locks are always taken in the same order.
+func lockOrder(a, b *Embeddable) (*Embeddable, *Embeddable) {
+	if a.id < b.id {
+		return a, b
+	}
+	return b, a
+}
+
+// The Exit describing how this coroutine finished. Must be called with the link lock held, after it has exited.
+func (e *Embeddable) exit() Exit {
+	return Exit{From: e.ref(), Reason: e.exitReason, Panic: e.exitPanic}
+}
+
+// Tells every linked and monitoring coroutine that this one has finished. Called once it has stopped running, and
+// makes sure no more links or monitors can be added.
+func (e *Embeddable) notifyExit(reason ExitReason, panicked interface{}) {
+	e.linkLock.Lock()
+	e.exited = true
+	e.exitReason = reason
+	e.exitPanic = panicked
+	exit := e.exit()
+	var links, monitors map[*Embeddable]struct{}
+	if x := e.ext.Load(); x != nil {
+		links, monitors = x.links, x.monitors
+		x.links, x.monitors = nil, nil
+	}
+	hooks := e.exitHooks
+	e.exitHooks = nil
+	e.linkLock.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	for peer := range links {
+		peer.linkLock.Lock()
+		peer.forgetLink(e)
+		peer.linkLock.Unlock()
+		peer.linkedExit(e)
+	}
+	for peer := range monitors {
+		peer.deliver(mail{v: exit, from: e.ref()})
+	}
+}
+
+// Reacts to a linked coroutine finishing: anything other than returning stops this coroutine, or is delivered as an
+// Exit if it traps exits.
+func (e *Embeddable) linkedExit(from *Embeddable) {
+	from.linkLock.Lock()
+	exit := from.exit()
+	from.linkLock.Unlock()
+	e.handleLinkedExit(exit)
+}
+
+func (e *Embeddable) handleLinkedExit(exit Exit) {
+	if exit.Reason == ExitReturned {
+		return
+	}
+	if e.trapExit {
+		e.deliver(mail{v: exit, from: exit.From})
+		return
+	}
+	if e.isRunning() {
+		e.logDebug("Coroutine stopped by a linked coroutine.")
+		e.setRunning(false)
+		e.notify()
+	}
+}
+
+// Arranges for f to be called once the coroutine finishes, for the parts of the library that need to clean up after
+// it. Registering again with the same key replaces the earlier f. Returns false without registering anything if the
+// coroutine has already finished.
+func (e *Embeddable) onExit(key interface{}, f func()) bool {
+	e.linkLock.Lock()
+	defer e.linkLock.Unlock()
+	if e.exited {
+		return false
+	}
+	if e.exitHooks == nil {
+		e.exitHooks = make(map[interface{}]func())
+	}
+	e.exitHooks[key] = f
+	return true
+}
+
+// onExit for the coroutine this references, which has already finished if the Ref is stale.
+func (r *embeddableRef) onExit(key interface{}, f func()) bool {
+	return !r.stale() && r.e.onExit(key, f)
+}
+
+func (r *embeddableRef) cancelOnExit(key interface{}) {
+	if !r.stale() {
+		r.e.cancelOnExit(key)
+	}
+}
+
+// Removes a function registered with onExit.
+func (e *Embeddable) cancelOnExit(key interface{}) {
+	e.linkLock.Lock()
+	delete(e.exitHooks, key)
+	e.linkLock.Unlock()
+}
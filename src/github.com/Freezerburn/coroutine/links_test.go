@@ -0,0 +1,22 @@
+package coroutine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnlinkSelf(t *testing.T) {
+	self := make(chan Ref, 1)
+	done := make(chan struct{})
+	r := StartFunc(func(c Coroutine) {
+		c.(Linker).Unlink(<-self)
+		close(done)
+	})
+	self <- r
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected unlinking itself to return straight away")
+	}
+}
@@ -0,0 +1,107 @@
+package coroutine
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Where the library's own log messages go, set with SetLogger. args are alternating keys and values, the same as
+// slog, so a *slog.Logger can be used directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// A Logger that throws everything away, for silencing the library completely.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// Wraps the Logger so that atomic.Value always stores the same concrete type.
+type loggerHolder struct {
+	l Logger
+}
+
+var internalLogger atomic.Value
+
+// Sets where the library's own log messages go: warnings about probable bugs such as stopping a coroutine twice,
+// errors such as coroutines panicking, and debug messages about coroutines starting and finishing. Level filtering
+// is up to the Logger. Passing nil goes back to the default, which logs through the handler set with SetLogHandler.
+func SetLogger(l Logger) {
+	internalLogger.Store(loggerHolder{l})
+}
+
+func libraryLogger() Logger {
+	if holder, _ := internalLogger.Load().(loggerHolder); holder.l != nil {
+		return holder.l
+	}
+	return currentSlogLogger()
+}
+
+// Logged for every coroutine that starts and finishes, so nothing is built for the message unless the logger wants it.
+func (e *Embeddable) logDebug(msg string) {
+	l := libraryLogger()
+	if s, ok := l.(*slog.Logger); ok && !s.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	l.Debug(msg, "coroutine_id", e.id, "coroutine_name", e.name)
+}
+
+func (e *Embeddable) logWarn(msg string) {
+	libraryLogger().Warn(msg, "coroutine_id", e.id, "coroutine_name", e.name)
+}
+
+func (e *Embeddable) logError(msg string, args ...interface{}) {
+	libraryLogger().Error(msg, append([]interface{}{"coroutine_id", e.id, "coroutine_name", e.name}, args...)...)
+}
+
+// Wraps the slog.Handler so that atomic.Value always stores the same concrete type, along with a Logger for it, made
+// once when it's set rather than every time the library logs.
+type logHandlerHolder struct {
+	h slog.Handler
+	l *slog.Logger
+}
+
+var logHandler atomic.Value
+
+// Sets where everything logged through a coroutine's Logger ends up, for coroutines that call Logger after this.
+// Passing nil goes back to the default, which is whatever slog.Default uses at the time.
+func SetLogHandler(h slog.Handler) {
+	if h == nil {
+		logHandler.Store(logHandlerHolder{})
+		return
+	}
+	logHandler.Store(logHandlerHolder{h, slog.New(h)})
+}
+
+func currentLogHandler() slog.Handler {
+	return currentSlogLogger().Handler()
+}
+
+// A Logger for the handler set with SetLogHandler, or slog.Default if there isn't one.
+func currentSlogLogger() *slog.Logger {
+	if holder, _ := logHandler.Load().(logHandlerHolder); holder.l != nil {
+		return holder.l
+	}
+	return slog.Default()
+}
+
+// A structured logger for this coroutine, with every record already tagged with the coroutine's id and name. Records
+// go to the handler set with SetLogHandler. The logger is created the first time it's asked for, so it must only be
+// called from the coroutine itself.
+func (e *Embeddable) Logger() *slog.Logger {
+	e.checkpoint()
+	if e.logger == nil {
+		e.logger = slog.New(currentLogHandler()).With(
+			slog.Uint64("coroutine_id", e.id),
+			slog.String("coroutine_name", e.name),
+		)
+	}
+	return e.logger
+}
@@ -0,0 +1,15 @@
+package coroutine
+
+import (
+	"testing"
+)
+
+func TestDisabledDebugLoggingDoesNotAllocate(t *testing.T) {
+	e := newEmbeddable()
+	allocs := testing.AllocsPerRun(100, func() {
+		e.logDebug("Coroutine finished.")
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations with debug logging off, got %v", allocs)
+	}
+}
@@ -0,0 +1,187 @@
+package coroutine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Where a coroutine keeps the messages sent to it until one of the Recv functions takes them, given to it with
+// WithMailbox. Without one, a coroutine uses a lock-free queue, or a ring buffer with WithRingMailbox, which are built
+// in and cheaper than anything behind this interface. Implementing it lets other kinds of mailbox, such as bounded,
+// priority or persistent ones, be used without changing anything else about the coroutine.
+//
+// Enqueue and Len are called from any goroutine, often several at once, while Dequeue is only ever called by the
+// coroutine itself, but may be called at the same time as Enqueue. A message must be visible to Dequeue and Len by the
+// time Enqueue returns, since that's when the coroutine is woken to receive it. Dequeue must not block: it returns
+// false straight away if there's nothing to receive, and the coroutine waits to be woken by the next Enqueue.
+type Mailbox interface {
+	// Adds a message to the mailbox. Returning an error refuses it, and it goes to the dead letter handler with
+	// DeadLetterRejected and the error.
+	Enqueue(m Message) error
+	// Removes and returns the next message the coroutine should receive.
+	Dequeue() (Message, bool)
+	// How many messages are waiting.
+	Len() int
+}
+
+// Implemented by a Mailbox that messages can arrive in other than through Enqueue, such as one shared with other
+// processes. The coroutine it's given to calls Notify with a function that wakes it when it starts, and again every
+// time it restarts. The Mailbox calls the latest of them, from any goroutine, whenever a message arrives that way.
+type NotifyingMailbox interface {
+	Mailbox
+	Notify(wake func())
+}
+
+// A message waiting in a Mailbox. Mailboxes hand back the Messages they were given as they are. Besides Value and Sent,
+// a Message carries what the library knows about it, such as who sent it and whether a Call is waiting for a reply,
+// which a Mailbox that only keeps Value, for example to write it somewhere, loses.
+type Message struct {
+	// What was sent.
+	Value interface{}
+	// When it was put into the mailbox, according to the coroutine's clock.
+	Sent time.Time
+	// Everything else about the message, or nil if there's nothing else to know.
+	meta *mail
+}
+
+// Gives a coroutine a Mailbox of its own to keep its messages in, instead of the built in one. The Mailbox should only
+// be given to one coroutine, unless it's designed to be shared between several. Unlike the built in mailboxes, it's
+// left alone when the coroutine restarts or finishes, so it keeps any messages still waiting in it.
+//
+// Scheduler.Mailbox and DebugHandler can't see into a Mailbox, so show it as empty.
+func WithMailbox(m Mailbox) Option {
+	return func(e *Embeddable) {
+		e.customMailbox = m
+	}
+}
+
+// Where a coroutine's messages wait until one of the Recv functions takes them, in the order they were put in. put,
+// empty, len and each can be called from any goroutine at any time, while take is only ever called by the coroutine
+// itself, or by something that has taken its place after it finished.
+type mailQueue interface {
+	put(m mail) error
+	take() (mail, bool)
+	empty() bool
+	len() int
+	// Calls f with a snapshot of every message waiting, front first.
+	each(f func(m mail))
+}
+
+// Implemented by mailQueues that can put a batch of messages in at once, next to each other and in order, for
+// SendBatch.
+type batchQueue interface {
+	putAll(ms []mail)
+}
+
+// Keeps the coroutine's mailbox in a ring buffer guarded by a lock, instead of the default lock-free queue. The ring
+// buffer allocates nothing per message once it has grown to fit the coroutine's usual backlog, and shrinks again after
+// a burst, so memory use stays flat for long-lived coroutines with steady traffic. The lock-free queue allocates for
+// every message, but never makes senders wait for each other, so it's the better choice for coroutines with many
+// concurrent senders.
+func WithRingMailbox() Option {
+	return func(e *Embeddable) {
+		e.ringMailbox = true
+	}
+}
+
+// Gives the coroutine an empty mailbox of the kind its options ask for, unless it already has one.
+func (e *Embeddable) initMailbox() {
+	if e.customMailbox != nil {
+		e.mailbox = mailboxQueue{e.customMailbox}
+		return
+	}
+	switch e.mailbox.(type) {
+	case *ringQueue:
+		if e.ringMailbox {
+			return
+		}
+	case *mpscQueue:
+		if !e.ringMailbox {
+			return
+		}
+	}
+	if e.ringMailbox {
+		e.mailbox = newRingQueue()
+	} else {
+		e.mailbox = newMPSCQueue()
+	}
+}
+
+// Takes one message out of the mailbox for one of the Recv functions, counting it as processed.
+func (e *Embeddable) take() (mail, bool) {
+	m, ok := e.mailbox.take()
+	if !ok {
+		return mail{}, false
+	}
+	atomic.AddUint64(&e.dequeued, 1)
+	if m.at.IsZero() {
+		// Made up by a Mailbox, rather than sent.
+		m.at = e.clock.Now()
+	}
+	return m, true
+}
+
+// Handles a message that a Mailbox refused with err, after it was counted as enqueued. A Call waiting for a reply to
+// it gets err straight away, rather than waiting for its timeout.
+func (e *Embeddable) rejected(m mail, err error) {
+	atomic.AddUint64(&e.enqueued, ^uint64(0))
+	deadLetter(DeadLetter{Reason: DeadLetterRejected, Value: m.v, To: e.ref(), From: m.from, Err: err})
+	if m.call != 0 {
+		if f := takeCall(m.call); f != nil {
+			f.resolve(nil, err)
+		}
+	}
+}
+
+// Removes and returns everything in one of the built in mailboxes. Has the same restrictions as mailQueue.take. A
+// Mailbox from WithMailbox is left as it is.
+func (e *Embeddable) drainMailbox() []mail {
+	if _, custom := e.mailbox.(mailboxQueue); custom {
+		return nil
+	}
+	var ms []mail
+	for {
+		m, ok := e.mailbox.take()
+		if !ok {
+			return ms
+		}
+		ms = append(ms, m)
+	}
+}
+
+// Adapts a Mailbox from WithMailbox to a mailQueue.
+type mailboxQueue struct {
+	m Mailbox
+}
+
+func (q mailboxQueue) put(m mail) error {
+	msg := Message{Value: m.v, Sent: m.at}
+	if m.ctx != nil || m.from != nil || m.call != 0 || m.ttl != 0 || m.received != nil {
+		msg.meta = &m
+	}
+	return q.m.Enqueue(msg)
+}
+
+func (q mailboxQueue) take() (mail, bool) {
+	msg, ok := q.m.Dequeue()
+	if !ok {
+		return mail{}, false
+	}
+	var m mail
+	if msg.meta != nil {
+		m = *msg.meta
+	}
+	m.v = msg.Value
+	m.at = msg.Sent
+	return m, true
+}
+
+func (q mailboxQueue) empty() bool {
+	return q.m.Len() == 0
+}
+
+func (q mailboxQueue) len() int {
+	return q.m.Len()
+}
+
+func (q mailboxQueue) each(f func(m mail)) {}
@@ -0,0 +1,44 @@
+package coroutine
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Measures send and receive throughput through each built in mailbox, with 1, 8 and 16 goroutines sending b.N
+// messages between them to a coroutine that receives them all.
+func BenchmarkSend(b *testing.B) {
+	for _, kind := range mailboxKinds {
+		for _, senders := range []int{1, 8, 16} {
+			b.Run(kind.name+"/senders="+strconv.Itoa(senders), func(b *testing.B) {
+				done := make(chan struct{})
+				r := StartFunc(func(c Coroutine) {
+					defer close(done)
+					for i := 0; i < b.N; i++ {
+						c.Recv()
+					}
+				}, kind.opts...)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				var wg sync.WaitGroup
+				for s := 0; s < senders; s++ {
+					n := b.N / senders
+					if s < b.N%senders {
+						n++
+					}
+					wg.Add(1)
+					go func(n int) {
+						defer wg.Done()
+						for i := 0; i < n; i++ {
+							r.Send(i)
+						}
+					}(n)
+				}
+				wg.Wait()
+				<-done
+			})
+		}
+	}
+}
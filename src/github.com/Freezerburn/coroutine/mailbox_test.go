@@ -0,0 +1,61 @@
+package coroutine
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Every mailbox the library builds in, by the options that select it.
+var mailboxKinds = []struct {
+	name string
+	opts []Option
+}{
+	{"mpsc", nil},
+	{"ring", []Option{WithRingMailbox()}},
+}
+
+type sequenced struct {
+	sender int
+	seq    int
+}
+
+func TestMailboxKeepsPerSenderOrder(t *testing.T) {
+	const senders, perSender = 8, 2000
+	for _, kind := range mailboxKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			errs := make(chan string, 1)
+			done := make(chan struct{})
+			r := StartFunc(func(c Coroutine) {
+				defer close(done)
+				next := make([]int, senders)
+				for n := 0; n < senders*perSender; n++ {
+					m := c.Recv().(sequenced)
+					if m.seq != next[m.sender] {
+						errs <- fmt.Sprintf("sender %d sent %d next, but %d arrived", m.sender, next[m.sender], m.seq)
+						return
+					}
+					next[m.sender]++
+				}
+			}, kind.opts...)
+
+			var wg sync.WaitGroup
+			for s := 0; s < senders; s++ {
+				wg.Add(1)
+				go func(s int) {
+					defer wg.Done()
+					for i := 0; i < perSender; i++ {
+						r.Send(sequenced{s, i})
+					}
+				}(s)
+			}
+			wg.Wait()
+			<-done
+			select {
+			case err := <-errs:
+				t.Fatal(err)
+			default:
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Exposes the coroutine package's counters in formats monitoring systems understand: expvar, and the Prometheus text
+// exposition format. Neither needs any dependency outside of the standard library, so the Prometheus handler can be
+// scraped directly without linking the Prometheus client.
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Freezerburn/coroutine"
+)
+
+// A single metric exported from coroutine.Totals.
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(t coroutine.Totals) float64
+}
+
+var all = []metric{
+	{"coroutine_live", "Coroutines currently running.", "gauge",
+		func(t coroutine.Totals) float64 { return float64(t.Live) }},
+	{"coroutine_started_total", "Coroutines started.", "counter",
+		func(t coroutine.Totals) float64 { return float64(t.Started) }},
+	{"coroutine_returned_total", "Coroutines that finished by returning.", "counter",
+		func(t coroutine.Totals) float64 { return float64(t.Returned) }},
+	{"coroutine_stopped_total", "Coroutines that finished by being stopped.", "counter",
+		func(t coroutine.Totals) float64 { return float64(t.Stopped) }},
+	{"coroutine_panicked_total", "Coroutines that finished by panicking.", "counter",
+		func(t coroutine.Totals) float64 { return float64(t.Panicked) }},
+	{"coroutine_send_dropped_total", "Messages that could not be delivered, for any dead letter reason.", "counter",
+		func(t coroutine.Totals) float64 { return float64(t.Dropped) }},
+	{"coroutine_mailbox_messages", "Messages waiting across every live coroutine's mailbox.", "gauge",
+		func(t coroutine.Totals) float64 { return float64(t.MailboxTotal) }},
+	{"coroutine_mailbox_messages_max", "Messages waiting in the fullest live coroutine's mailbox.", "gauge",
+		func(t coroutine.Totals) float64 { return float64(t.MailboxMax) }},
+}
+
+var publish sync.Once
+
+// Publishes the counters as the expvar variable "coroutine", so they show up under /debug/vars. Safe to call more
+// than once; only the first call does anything.
+func Publish() {
+	publish.Do(func() {
+		expvar.Publish("coroutine", expvar.Func(func() interface{} {
+			t := coroutine.ReadTotals()
+			vars := make(map[string]float64, len(all))
+			for _, m := range all {
+				vars[m.name] = m.value(t)
+			}
+			return vars
+		}))
+	})
+}
+
+// Writes the current counters in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer) error {
+	t := coroutine.ReadTotals()
+	bw := bufio.NewWriter(w)
+	for _, m := range all {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", m.name, m.help, m.name, m.kind, m.name, m.value(t))
+	}
+	return bw.Flush()
+}
+
+// An http.Handler serving the counters in the Prometheus text exposition format, to be mounted wherever Prometheus
+// scrapes, such as /metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w)
+	})
+}
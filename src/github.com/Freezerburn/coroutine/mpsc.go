@@ -0,0 +1,107 @@
+package coroutine
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// The default mailQueue: a lock-free queue that any number of goroutines can put messages into at once, and that only
+// the coroutine itself takes them out of. This is Dmitry Vyukov's MPSC queue. Senders never wait for each other or for
+// the coroutine, apart from a single atomic swap each, which matters once many of them send to the same coroutine.
+//
+// The queue always holds a stub node at the front that has already been taken, so that head and tail never have to
+// be updated together. Senders swap themselves in as the new head and then link the old head to themselves, which
+// leaves a moment where a message has been put in but can't be seen from the tail yet. take waits out that moment
+// when n says there is a message, and empty reports the queue as empty during it, which is safe because the sender
+// only notifies the coroutine once its message can be seen.
+type mpscQueue struct {
+	// The node most recently put in, swapped by senders.
+	head unsafe.Pointer
+	// The node most recently taken, only ever moved by the coroutine. Accessed atomically so that snapshots taken from
+	// other goroutines can walk the queue.
+	tail unsafe.Pointer
+	// How many messages are in the queue, accessed atomically. A message is counted before it's linked, so that this
+	// never goes below zero.
+	n int64
+}
+
+type mailNode struct {
+	next unsafe.Pointer
+	m    mail
+}
+
+func newMPSCQueue() *mpscQueue {
+	stub := unsafe.Pointer(&mailNode{})
+	return &mpscQueue{head: stub, tail: stub}
+}
+
+// Puts a message at the back of the queue. Safe to call from any goroutine.
+func (q *mpscQueue) put(m mail) error {
+	n := &mailNode{m: m}
+	q.putChain(n, n, 1)
+	return nil
+}
+
+// Puts every one of ms at the back of the queue, next to each other and in order, with a single swap.
+func (q *mpscQueue) putAll(ms []mail) {
+	if len(ms) == 0 {
+		return
+	}
+	first := &mailNode{m: ms[0]}
+	last := first
+	for _, m := range ms[1:] {
+		n := &mailNode{m: m}
+		last.next = unsafe.Pointer(n)
+		last = n
+	}
+	q.putChain(first, last, len(ms))
+}
+
+// Links the n nodes from first to last, which are already linked to each other, in at the head.
+func (q *mpscQueue) putChain(first, last *mailNode, n int) {
+	atomic.AddInt64(&q.n, int64(n))
+	prev := (*mailNode)(atomic.SwapPointer(&q.head, unsafe.Pointer(last)))
+	atomic.StorePointer(&prev.next, unsafe.Pointer(first))
+}
+
+// Whether there is a message that take can return straight away. Only meaningful to the coroutine itself; a message
+// that another goroutine is putting in at the same moment may or may not be seen.
+func (q *mpscQueue) empty() bool {
+	tail := (*mailNode)(atomic.LoadPointer(&q.tail))
+	return atomic.LoadPointer(&tail.next) == nil
+}
+
+// Removes the first message from the queue, returning false if it is empty. Must only be called by the coroutine
+// itself, or by something that has taken its place after it finished.
+func (q *mpscQueue) take() (mail, bool) {
+	tail := (*mailNode)(atomic.LoadPointer(&q.tail))
+	next := (*mailNode)(atomic.LoadPointer(&tail.next))
+	for next == nil {
+		if q.len() == 0 {
+			return mail{}, false
+		}
+		// A sender is between swapping the head and linking to it, which takes a couple of instructions.
+		runtime.Gosched()
+		next = (*mailNode)(atomic.LoadPointer(&tail.next))
+	}
+	// next becomes the new stub. Its message is left in it, so a snapshot walking the queue never races with this,
+	// and is let go of when the stub is next replaced.
+	atomic.StorePointer(&q.tail, unsafe.Pointer(next))
+	atomic.AddInt64(&q.n, -1)
+	return next.m, true
+}
+
+// How many messages are waiting in the queue. May include one a sender is still putting in.
+func (q *mpscQueue) len() int {
+	return int(atomic.LoadInt64(&q.n))
+}
+
+// Calls f with every message waiting in the queue, front first. Safe to call from any goroutine, but the coroutine
+// may take messages, and senders put more in, while it runs, so it's only a snapshot.
+func (q *mpscQueue) each(f func(m mail)) {
+	tail := (*mailNode)(atomic.LoadPointer(&q.tail))
+	for n := (*mailNode)(atomic.LoadPointer(&tail.next)); n != nil; n = (*mailNode)(atomic.LoadPointer(&n.next)) {
+		f(n.m)
+	}
+}
@@ -0,0 +1,191 @@
+package coroutine
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Creates a Ref that addresses every one of the given Refs at once, so that code written against a single Ref can
+// send to a whole set without changing. Sends go to every Ref in order, and Stop stops all of them. The set is fixed
+// when Multicast is called; use a group for a set that changes.
+//
+// Everything else combines the answers from each Ref:
+//   - Running is true while any of them is running.
+//   - Name joins their names with commas, and Id is always zero, since a multicast isn't a coroutine itself.
+//   - Stats adds their counts and histograms together, with the earliest start and the latest activity.
+//   - Ping pings them all at once, and is only alive if all of them are.
+//   - Replace replaces the handler of each of them in turn, returning the first error.
+//   - Ask's Future resolves with a []interface{} of every reply in order, or the first error. Call waits for it.
+func Multicast(refs ...Ref) Ref {
+	return &multicastRef{refs: append([]Ref(nil), refs...)}
+}
+
+type multicastRef struct {
+	refs []Ref
+}
+
+func (m *multicastRef) Send(v interface{}) {
+	for _, r := range m.refs {
+		r.Send(v)
+	}
+}
+
+func (m *multicastRef) SendContext(ctx context.Context, v interface{}) {
+	for _, r := range m.refs {
+		SendContext(ctx, r, v)
+	}
+}
+
+func (m *multicastRef) SendAfter(v interface{}, d time.Duration) CancelFunc {
+	cancels := make([]CancelFunc, len(m.refs))
+	for i, r := range m.refs {
+		cancels[i] = SendAfter(r, v, d)
+	}
+	return cancelAll(cancels)
+}
+
+func (m *multicastRef) SendEvery(v interface{}, interval time.Duration) CancelFunc {
+	cancels := make([]CancelFunc, len(m.refs))
+	for i, r := range m.refs {
+		cancels[i] = SendEvery(r, v, interval)
+	}
+	return cancelAll(cancels)
+}
+
+func cancelAll(cancels []CancelFunc) CancelFunc {
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+func (m *multicastRef) Ask(v interface{}) *Future {
+	futures := make([]*Future, len(m.refs))
+	for i, r := range m.refs {
+		futures[i] = Ask(r, v)
+	}
+	return All(futures...)
+}
+
+func (m *multicastRef) Call(v interface{}, timeout time.Duration) (interface{}, error) {
+	f := m.Ask(v)
+	replies, err := f.Await(timeout)
+	if err == ErrCallTimeout {
+		f.Cancel()
+	}
+	return replies, err
+}
+
+func (m *multicastRef) Running() bool {
+	for _, r := range m.refs {
+		if r.Running() {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *multicastRef) Name() string {
+	names := make([]string, len(m.refs))
+	for i, r := range m.refs {
+		names[i] = r.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (m *multicastRef) Id() uint64 {
+	return 0
+}
+
+func (m *multicastRef) Stats() Stats {
+	var total Stats
+	for i, r := range m.refs {
+		s := StatsOf(r)
+		total.MailboxLen += s.MailboxLen
+		total.Received += s.Received
+		total.Processed += s.Processed
+		if i == 0 || s.Started.Before(total.Started) {
+			total.Started = s.Started
+		}
+		if s.Uptime > total.Uptime {
+			total.Uptime = s.Uptime
+		}
+		if s.LastActivity.After(total.LastActivity) {
+			total.LastActivity = s.LastActivity
+		}
+		total.QueueTime = total.QueueTime.merge(s.QueueTime)
+		total.HandleTime = total.HandleTime.merge(s.HandleTime)
+	}
+	return total
+}
+
+func (m *multicastRef) Suspend() {
+	for _, r := range m.refs {
+		Suspend(r)
+	}
+}
+
+func (m *multicastRef) Resume() {
+	for _, r := range m.refs {
+		Resume(r)
+	}
+}
+
+func (m *multicastRef) Restart() error {
+	var first error
+	for _, r := range m.refs {
+		if err := Restart(r); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m *multicastRef) Replace(handler interface{}) error {
+	var first error
+	for _, r := range m.refs {
+		if err := Replace(r, handler); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m *multicastRef) Ping(timeout time.Duration) Health {
+	results := make([]Health, len(m.refs))
+	var wg sync.WaitGroup
+	for i, r := range m.refs {
+		wg.Add(1)
+		go func(i int, r Ref) {
+			defer wg.Done()
+			results[i] = Ping(r, timeout)
+		}(i, r)
+	}
+	wg.Wait()
+
+	combined := Health{Alive: len(results) > 0}
+	for i, h := range results {
+		combined.MailboxLen += h.MailboxLen
+		if h.Latency > combined.Latency {
+			combined.Latency = h.Latency
+		}
+		// Report the state of the first member that didn't answer, since that's the one worth looking at.
+		if i == 0 || (combined.Alive && !h.Alive) {
+			combined.State = h.State
+		}
+		combined.Alive = combined.Alive && h.Alive
+	}
+	return combined
+}
+
+// Stops every coroutine that is still running. Those that have already finished are left alone.
+func (m *multicastRef) Stop() {
+	for _, r := range m.refs {
+		if r.Running() {
+			r.Stop()
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Connects coroutines to NATS: Subscribe delivers everything published to a subject to a coroutine's mailbox for as
+// long as the coroutine runs, and a Publisher is a coroutine.Ref whose Send publishes to a subject. The package
+// doesn't depend on the NATS client. It works with a Conn, which takes a few lines to implement around a *nats.Conn:
+//
+//	type natsConn struct{ nc *nats.Conn }
+//
+//	func (c natsConn) Subscribe(subject string, h func(natsbridge.Msg)) (natsbridge.Subscription, error) {
+//		return c.nc.Subscribe(subject, func(m *nats.Msg) {
+//			h(natsbridge.Msg{Subject: m.Subject, Reply: m.Reply, Data: m.Data})
+//		})
+//	}
+//
+//	func (c natsConn) Publish(subject string, data []byte) error { return c.nc.Publish(subject, data) }
+//
+// Adding Request, and IsClosed from *nats.Conn, makes a Publisher's Call and Running work too.
+package natsbridge
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Freezerburn/coroutine"
+)
+
+// A message received from NATS.
+type Msg struct {
+	Subject string
+	// The subject to publish a response to, if the message was sent as a request.
+	Reply string
+	Data  []byte
+}
+
+// Decodes Data with the coroutine package's Codec, for messages published by a Publisher, or by anything else that
+// encodes them the same way.
+func (m Msg) Decode() (interface{}, error) {
+	return coroutine.CurrentCodec().Decode(m.Data)
+}
+
+// What the bridge needs from a NATS connection.
+type Conn interface {
+	// Calls h with every message published to subject, which may contain wildcards, until the Subscription is
+	// unsubscribed. h is called from one goroutine at a time for each subscription.
+	Subscribe(subject string, h func(m Msg)) (Subscription, error)
+	Publish(subject string, data []byte) error
+}
+
+// A subscription made through a Conn. *nats.Subscription already is one.
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Implemented by a Conn that can send a request and wait for the response, which a Publisher's Call and Ask need.
+type Requester interface {
+	Request(subject string, data []byte, timeout time.Duration) ([]byte, error)
+}
+
+// Implemented by a Conn that knows when it has been closed, which a Publisher's Running reports. *nats.Conn already
+// has IsClosed.
+type Closer interface {
+	IsClosed() bool
+}
+
+// A subscription that sends every message to a coroutine, from Subscribe.
+type Bridge struct {
+	sub     Subscription
+	once    sync.Once
+	err     error
+	watcher coroutine.Ref
+}
+
+// Subscribes to subject, sending every message published to it to the referenced coroutine as a Msg, until the
+// coroutine finishes or Close is called. The coroutine can Decode the Msg, and respond to a request with a Publisher
+// for its Reply subject.
+//
+// Only a Ref that coroutine.Monitor works with, such as one to a coroutine started by the coroutine package,
+// unsubscribes by itself when the coroutine finishes. For anything else the Bridge stays open until it's closed.
+func Subscribe(conn Conn, subject string, r coroutine.Ref) (*Bridge, error) {
+	sub, err := conn.Subscribe(subject, func(m Msg) {
+		r.Send(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	b := &Bridge{sub: sub}
+	// Watches r from a coroutine of its own, which is only told when r finishes if r is a coroutine.
+	b.watcher = coroutine.StartFuncName("natsbridge "+subject, func(c coroutine.Coroutine) {
+		c.(coroutine.Linker).Monitor(r)
+		for {
+			if _, ok := c.Recv().(coroutine.Exit); ok {
+				b.unsubscribe()
+				return
+			}
+		}
+	})
+	return b, nil
+}
+
+// Unsubscribes, so the coroutine receives nothing more from the subject. Safe to call more than once; only the first
+// call unsubscribes, and every call returns its error.
+func (b *Bridge) Close() error {
+	err := b.unsubscribe()
+	if b.watcher.Running() {
+		b.watcher.Stop()
+	}
+	return err
+}
+
+func (b *Bridge) unsubscribe() error {
+	b.once.Do(func() {
+		b.err = b.sub.Unsubscribe()
+	})
+	return b.err
+}
+
+// A coroutine.Ref for a NATS subject: Send encodes the value with the coroutine package's Codec and publishes it, and
+// Call sends it as a request and decodes the response, if the Conn is a Requester. Anything that takes a Ref, such as
+// a Router or the subscribers of an EventBus, can publish to NATS through one.
+//
+// Nothing can report a failed publish to the caller of Send, so the last error is kept for Err. Name is the subject
+// and Id is always 0. Stop does nothing.
+type Publisher struct {
+	conn    Conn
+	subject string
+	lock    sync.Mutex
+	err     error
+}
+
+var (
+	_ coroutine.Ref    = (*Publisher)(nil)
+	_ coroutine.Caller = (*Publisher)(nil)
+)
+
+func NewPublisher(conn Conn, subject string) *Publisher {
+	return &Publisher{conn: conn, subject: subject}
+}
+
+// The error from the last Send, or nil if it worked.
+func (p *Publisher) Err() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.err
+}
+
+func (p *Publisher) Send(v interface{}) {
+	data, err := coroutine.CurrentCodec().Encode(v)
+	if err == nil {
+		err = p.conn.Publish(p.subject, data)
+	}
+	p.lock.Lock()
+	p.err = err
+	p.lock.Unlock()
+}
+
+// Sends v as a request and waits up to timeout for the response, which is passed to the Conn's Request as it is.
+// Returns coroutine.ErrRemoteUnsupported if the Conn isn't a Requester.
+func (p *Publisher) Call(v interface{}, timeout time.Duration) (interface{}, error) {
+	requester, ok := p.conn.(Requester)
+	if !ok {
+		return nil, coroutine.ErrRemoteUnsupported
+	}
+	codec := coroutine.CurrentCodec()
+	data, err := codec.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := requester.Request(p.subject, data, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(reply)
+}
+
+// Makes the same request as Call, on a goroutine of its own, with a timeout of zero.
+func (p *Publisher) Ask(v interface{}) *coroutine.Future {
+	return coroutine.Resolved(nil, nil).Then(func(interface{}) (interface{}, error) {
+		return p.Call(v, 0)
+	})
+}
+
+// False once the Conn has been closed, if it's a Closer. Otherwise always true.
+func (p *Publisher) Running() bool {
+	if c, ok := p.conn.(Closer); ok {
+		return !c.IsClosed()
+	}
+	return true
+}
+
+func (p *Publisher) Name() string {
+	return p.subject
+}
+
+func (p *Publisher) Id() uint64 {
+	return 0
+}
+
+func (p *Publisher) Stop() {
+}
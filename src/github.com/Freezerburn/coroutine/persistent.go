@@ -0,0 +1,126 @@
+package coroutine
+
+import (
+	"errors"
+)
+
+// Returned by Persist when it's called from Apply, while events are being applied, which would store the same change
+// twice.
+var ErrPersistInApply = errors.New("coroutine: can't Persist while applying events")
+
+// Embedded in place of Embeddable by a Starter whose state is built up from events, so that its state survives it
+// stopping. Rather than changing its fields directly, the coroutine hands each change to Persist as an event, which
+// stores it in a Journal and then applies it with the Starter's Apply. When it's started with StartPersistent, every
+// event already stored for its persistence id is applied, oldest first, before Start is called, so Start begins with
+// the state it had when it last stopped, whether that was a Restart, a crash or the end of the program.
+type PersistentEmbeddable struct {
+	Embeddable
+	persistenceId string
+	journal       Journal
+	apply         func(event interface{})
+	// The number of the last event stored for the persistence id that has been applied.
+	seq      uint64
+	applying bool
+}
+
+func (e *PersistentEmbeddable) PersistentEmbedded() *PersistentEmbeddable {
+	return e
+}
+
+// A Starter that can be started with StartPersistent, by embedding PersistentEmbeddable. Apply changes the Starter's
+// fields to reflect a single event, and is called both for events passed to Persist and for those replayed from the
+// Journal when it starts, so it must do nothing else: no sending, no waiting and no Persist, which returns
+// ErrPersistInApply when called from it.
+type Persistent interface {
+	Starter
+	Apply(event interface{})
+	PersistentEmbedded() *PersistentEmbeddable
+}
+
+// Starts the Persistent, named after the persistence id, after applying every event stored in the Journal for the id,
+// which should be unique to it, and the same every time it's started. Only one coroutine should persist to an id at a
+// time, since the second to do so gets ErrJournalConflict from Persist. If the events can't be replayed, the error is
+// logged and the coroutine stops before Start is called.
+//
+// Restart replays the events again from the start, or from its snapshot if it's also a Snapshotter started with
+// WithSnapshots, so a Persistent that's restarted should also be a Resetter, with ResetState putting its fields back
+// to how they are before any event has been applied.
+func StartPersistent(id string, j Journal, p Persistent, opts ...Option) Ref {
+	pe := p.PersistentEmbedded()
+	pe.persistenceId = id
+	pe.journal = j
+	pe.apply = p.Apply
+	pe.seq = 0
+	pe.applying = false
+
+	e := p.Embedded()
+	e.owned = false
+	e.snapshotter = nil
+	if sn, ok := p.(Snapshotter); ok {
+		e.snapshotter = sn
+	}
+	e.snapshotSeq = pe.LastSequence
+	e.resetState = func() {
+		pe.seq = 0
+	}
+	if r, ok := p.(Resetter); ok {
+		e.resetState = func() {
+			pe.seq = 0
+			r.ResetState()
+		}
+	}
+	return start(id, e, func() {
+		pe.recover()
+		p.Start()
+	}, opts)
+}
+
+// Restores the snapshot, if there is one, then applies every event stored after it was taken.
+func (e *PersistentEmbeddable) recover() {
+	e.seq = e.restoreSnapshot()
+	e.applying = true
+	err := e.journal.Replay(e.persistenceId, e.seq, func(seq uint64, event interface{}) error {
+		e.apply(event)
+		e.seq = seq
+		return nil
+	})
+	e.applying = false
+	if err != nil {
+		e.logError("Couldn't recover persistent coroutine.", "persistence_id", e.persistenceId, "error", err)
+		panic(Stop{})
+	}
+	e.markSnapshotRestored()
+}
+
+// Stores the events in the Journal, then applies them, in order. If storing them fails, none of them are applied, and
+// the error is returned. ErrJournalConflict means something else has persisted to the same persistence id since this
+// coroutine last recovered, and its state is out of date; restarting it recovers what the other stored.
+func (e *PersistentEmbeddable) Persist(events ...interface{}) error {
+	e.checkpoint()
+	if e.applying {
+		return ErrPersistInApply
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	if err := e.journal.Append(e.persistenceId, e.seq+1, events); err != nil {
+		return err
+	}
+	e.applying = true
+	for _, event := range events {
+		e.apply(event)
+		e.seq++
+	}
+	e.applying = false
+	return nil
+}
+
+// The persistence id given to StartPersistent.
+func (e *PersistentEmbeddable) PersistenceId() string {
+	return e.persistenceId
+}
+
+// The number of the last event applied, counting from 1, or 0 if none has been.
+func (e *PersistentEmbeddable) LastSequence() uint64 {
+	return e.seq
+}
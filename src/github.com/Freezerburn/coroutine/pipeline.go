@@ -0,0 +1,160 @@
+package coroutine
+
+import (
+	"sync"
+	"time"
+)
+
+// One step of a Pipeline, run inside its own coroutine for every value that reaches it. Returning false drops the
+// value instead of passing the result on, which is how a stage filters.
+type Stage[In, Out any] func(c Coroutine, v In) (Out, bool)
+
+// A chain of stages that each take the previous stage's output as their input, built up with NewPipeline and Then.
+// Building a pipeline doesn't start anything, and a built pipeline can be started any number of times. Every Then
+// returns a new Pipeline, so a pipeline can also be used as the common start of several others.
+type Pipeline[In, Out any] struct {
+	stages []pipelineStage
+}
+
+type pipelineStage struct {
+	name string
+	f    func(c Coroutine, v interface{}) (interface{}, bool)
+}
+
+// What a stage sends on to the next one once it has been told to stop, after everything that was sent before it.
+type pipelineStop struct{}
+
+// Creates an empty pipeline that takes values of type T. Started as it is, it passes them straight through.
+func NewPipeline[T any]() *Pipeline[T, T] {
+	return &Pipeline[T, T]{}
+}
+
+// Adds a stage to the end of the pipeline. Its coroutine is given the name, so it can be told apart in List.
+//
+// This is a function rather than a method because Go methods can't introduce type parameters of their own.
+func Then[In, Mid, Out any](p *Pipeline[In, Mid], name string, stage Stage[Mid, Out]) *Pipeline[In, Out] {
+	stages := make([]pipelineStage, len(p.stages), len(p.stages)+1)
+	copy(stages, p.stages)
+	stages = append(stages, pipelineStage{
+		name: name,
+		f: func(c Coroutine, v interface{}) (interface{}, bool) {
+			mid, ok := v.(Mid)
+			if !ok {
+				// Such as an Exit, if the stages were started with WithTrapExit, or anything sent straight to a stage.
+				d := DeadLetter{Reason: DeadLetterUnexpected, Value: v}
+				if e, ok := c.(*Embeddable); ok {
+					d.From, d.To = e.Sender(), e.ref()
+				}
+				deadLetter(d)
+				return nil, false
+			}
+			return stage(c, mid)
+		},
+	})
+	return &Pipeline[In, Out]{stages: stages}
+}
+
+// Starts a coroutine for every stage, each started with the given options, and wires each one's output into the
+// next one's mailbox. Whatever comes out of the last stage is sent to out, or discarded if out is nil.
+//
+// The stages are linked to each other, so if one of them panics or is stopped through its Ref, the rest are stopped
+// with it. Use RunningPipeline.Stop to stop the pipeline without losing anything already sent to it.
+func (p *Pipeline[In, Out]) Start(out Ref, opts ...Option) *RunningPipeline[In] {
+	stages := p.stages
+	if len(stages) == 0 {
+		stages = []pipelineStage{{
+			name: "Pipeline",
+			f: func(c Coroutine, v interface{}) (interface{}, bool) {
+				return v, true
+			},
+		}}
+	}
+
+	r := &RunningPipeline[In]{
+		stages: make([]Ref, len(stages)),
+		clock:  optionsClock(opts),
+		done:   make(chan struct{}),
+	}
+	// Started back to front so that every stage already knows where its output goes.
+	next := out
+	for i := len(stages) - 1; i >= 0; i-- {
+		r.stages[i] = StartFuncName(stages[i].name, r.stage(stages[i], next, i == len(stages)-1), opts...)
+		next = r.stages[i]
+	}
+	for i := 0; i < len(r.stages)-1; i++ {
+		a, b := r.stages[i].(*embeddableRef).e, r.stages[i+1].(*embeddableRef).e
+		if !linkPair(a, b) {
+			a.linkedExit(b)
+		}
+	}
+	return r
+}
+
+// The body of a single stage's coroutine.
+func (r *RunningPipeline[In]) stage(s pipelineStage, next Ref, last bool) Function {
+	return func(c Coroutine) {
+		if last {
+			defer r.finished()
+		}
+		for {
+			v := c.Recv()
+			if _, ok := v.(pipelineStop); ok {
+				if !last {
+					next.Send(v)
+				}
+				return
+			}
+			if out, ok := s.f(c, v); ok && next != nil {
+				next.Send(out)
+			}
+		}
+	}
+}
+
+// A pipeline that has been started, returned by Pipeline.Start. Safe for concurrent use.
+type RunningPipeline[In any] struct {
+	stages   []Ref
+	clock    Clock
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (r *RunningPipeline[In]) finished() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// Sends v to the first stage.
+func (r *RunningPipeline[In]) Send(v In) {
+	r.stages[0].Send(v)
+}
+
+// The Ref of every stage's coroutine, in order.
+func (r *RunningPipeline[In]) Stages() []Ref {
+	return append([]Ref(nil), r.stages...)
+}
+
+// Asks the pipeline to stop once everything already sent to it has made its way through. Each stage finishes what's
+// in its mailbox, tells the next stage to stop, and then returns, so the stages stop in order from first to last.
+// Returns straight away; use Wait to find out when the last stage has finished.
+func (r *RunningPipeline[In]) Stop() {
+	r.stages[0].Send(pipelineStop{})
+}
+
+// Waits up to timeout for the last stage to finish, whether because of Stop or because the stages were stopped some
+// other way. The timeout is measured on the stages' Clock, and a timeout <= 0 waits for as long as it takes. Returns false if the timeout passed first.
+func (r *RunningPipeline[In]) Wait(timeout time.Duration) bool {
+	if timeout <= 0 {
+		<-r.done
+		return true
+	}
+	t := r.clock.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-r.done:
+		return true
+	case <-t.Chan():
+		return false
+	}
+}
@@ -0,0 +1,297 @@
+package coroutine
+
+import (
+	"sync"
+	"time"
+)
+
+// A unit of work run by a Pool. It runs inside one of the pool's worker coroutines, so it can use the Coroutine to
+// Pause or WaitFor and remain stoppable. Its result resolves the Future returned by Submit.
+type Job func(c Coroutine) (interface{}, error)
+
+// A snapshot of how busy a Pool is, returned by Pool.Stats.
+type PoolStats struct {
+	// How many workers the pool is aiming for, as given to NewPool or Resize, and how many it has right now. They
+	// differ for a moment after a Resize.
+	Size    int
+	Workers int
+	// Workers running a job right now, and jobs waiting for a worker.
+	Busy   int
+	Queued int
+	// Jobs ever submitted, and jobs that finished, without an error and with one. Jobs stopped part way through count
+	// as failed.
+	Submitted uint64
+	Completed uint64
+	Failed    uint64
+}
+
+// Runs submitted jobs on a bounded number of worker coroutines. Jobs queue up while every worker is busy, and are
+// run in the order they were submitted as workers free up. Safe for concurrent use.
+type Pool struct {
+	lock    sync.Mutex
+	opts    []Option
+	clock   Clock
+	size    int
+	workers map[Ref]struct{}
+	idle    []Ref
+	queue   []*poolJob
+	busy    int
+	closed  bool
+	// Closed once the pool is closed and has nothing left to run.
+	drained chan struct{}
+
+	submitted uint64
+	completed uint64
+	failed    uint64
+}
+
+type poolJob struct {
+	job Job
+	f   *Future
+}
+
+// Starts a pool of size worker coroutines, each started with the given options. WithIdleTimeout, WithMaxLifetime and
+// WithDeadline are ignored, since a pool's workers are meant to wait for jobs for as long as the pool is open. A
+// worker that finishes anyway, such as by a job stopping it, is replaced.
+func NewPool(size int, opts ...Option) *Pool {
+	p := &Pool{
+		opts:    append(opts[:len(opts):len(opts)], poolWorker),
+		clock:   optionsClock(opts),
+		workers: make(map[Ref]struct{}),
+		drained: make(chan struct{}),
+	}
+	p.Resize(size)
+	return p
+}
+
+// Queues the job to be run by the next free worker, and returns a Future for its result. If the pool has been
+// drained or stopped, the Future is resolved with ErrNotRunning straight away.
+func (p *Pool) Submit(job Job) *Future {
+	j := &poolJob{job: job, f: newFuture()}
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		j.f.resolve(nil, ErrNotRunning)
+		return j.f
+	}
+	p.submitted++
+	p.queue = append(p.queue, j)
+	var wake Ref
+	if n := len(p.idle); n > 0 {
+		wake = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	}
+	p.lock.Unlock()
+
+	// The job stays in the queue, and the worker takes it from there once it wakes up. If another worker gets to it
+	// first, the woken one just goes back to being idle.
+	if wake != nil {
+		wake.Send(poolWake{})
+	}
+	return j.f
+}
+
+// What an idle worker is sent to let it know there's a job in the queue.
+type poolWake struct{}
+
+// Given to every worker after the pool's own options, so that none of them can make a worker finish of its own
+// accord.
+func poolWorker(e *Embeddable) {
+	e.idleTimeout = 0
+	e.maxLifetime = 0
+	e.deadline = time.Time{}
+}
+
+// Changes how many workers the pool has. New workers start straight away. When shrinking, idle workers are stopped
+// straight away and busy ones finish their current job first; no queued job is lost either way.
+func (p *Pool) Resize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.size = size
+	var retire []Ref
+	for len(p.workers) > p.size && len(p.idle) > 0 {
+		w := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		delete(p.workers, w)
+		retire = append(retire, w)
+	}
+	p.startWorkers()
+	p.lock.Unlock()
+
+	for _, w := range retire {
+		w.Stop()
+	}
+}
+
+// Starts workers until there are as many as the pool is aiming for. Must be called with the lock held, so each worker
+// is counted before it can look at the pool.
+func (p *Pool) startWorkers() {
+	for len(p.workers) < p.size {
+		p.workers[StartFuncName("Pool worker", p.work, p.opts...)] = struct{}{}
+	}
+}
+
+// The body of every worker coroutine.
+func (p *Pool) work(c Coroutine) {
+	self := c.(*Embeddable).ref()
+	defer p.exited(self)
+
+	for {
+		j, retire := p.take(self)
+		if retire {
+			return
+		}
+		if j == nil {
+			// Idle until Submit sends a wakeup.
+			c.Recv()
+			continue
+		}
+		p.run(c, j)
+	}
+}
+
+// Called by a worker whenever it's ready for another job. Returns the next queued job, or nil once the worker has
+// been marked idle, or true if it should retire because the pool has shrunk.
+func (p *Pool) take(self Ref) (*poolJob, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.workers) > p.size {
+		delete(p.workers, self)
+		p.removeIdle(self)
+		return nil, true
+	}
+	if len(p.queue) > 0 {
+		j := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.busy++
+		return j, false
+	}
+	p.removeIdle(self)
+	p.idle = append(p.idle, self)
+	return nil, false
+}
+
+// Must be called with the lock held.
+func (p *Pool) removeIdle(w Ref) {
+	for i, idle := range p.idle {
+		if idle == w {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return
+		}
+	}
+}
+
+// Runs a single job, making sure its Future is resolved even if the worker is stopped part way through.
+func (p *Pool) run(c Coroutine, j *poolJob) {
+	finished := false
+	var err error
+	defer func() {
+		if !finished {
+			j.f.resolve(nil, ErrNotRunning)
+		}
+		p.lock.Lock()
+		p.busy--
+		if err != nil || !finished {
+			p.failed++
+		} else {
+			p.completed++
+		}
+		p.checkDrained()
+		p.lock.Unlock()
+	}()
+
+	var v interface{}
+	v, err = j.job(c)
+	finished = true
+	j.f.resolve(v, err)
+}
+
+// Called as a worker finishes, however it finishes. One that didn't retire because the pool shrank is replaced, so
+// queued jobs always have a worker to run them until the pool is closed.
+func (p *Pool) exited(self Ref) {
+	p.lock.Lock()
+	delete(p.workers, self)
+	p.removeIdle(self)
+	if !p.closed {
+		p.startWorkers()
+	}
+	p.lock.Unlock()
+}
+
+// Must be called with the lock held.
+func (p *Pool) checkDrained() {
+	if p.closed && p.busy == 0 && len(p.queue) == 0 {
+		select {
+		case <-p.drained:
+		default:
+			close(p.drained)
+		}
+	}
+}
+
+// A snapshot of how busy the pool is.
+func (p *Pool) Stats() PoolStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return PoolStats{
+		Size:      p.size,
+		Workers:   len(p.workers),
+		Busy:      p.busy,
+		Queued:    len(p.queue),
+		Submitted: p.submitted,
+		Completed: p.completed,
+		Failed:    p.failed,
+	}
+}
+
+// Stops accepting jobs, then waits up to timeout for every queued and running job to finish before stopping the
+// workers. The timeout is measured on the workers' Clock, and a timeout <= 0 waits for as long as it takes. Returns false if the timeout passed first, in which case the
+// pool is left to carry on with what it has; call Stop to give up on it.
+func (p *Pool) Drain(timeout time.Duration) bool {
+	p.lock.Lock()
+	p.closed = true
+	p.checkDrained()
+	p.lock.Unlock()
+
+	if timeout > 0 {
+		t := p.clock.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case <-p.drained:
+		case <-t.Chan():
+			return false
+		}
+	} else {
+		<-p.drained
+	}
+	p.Stop()
+	return true
+}
+
+// Stops accepting jobs and stops every worker straight away. Queued jobs, and jobs that are stopped part way
+// through, are resolved with ErrNotRunning.
+func (p *Pool) Stop() {
+	p.lock.Lock()
+	p.closed = true
+	queue := p.queue
+	p.queue = nil
+	workers := make([]Ref, 0, len(p.workers))
+	for w := range p.workers {
+		workers = append(workers, w)
+	}
+	p.lock.Unlock()
+
+	for _, j := range queue {
+		j.f.resolve(nil, ErrNotRunning)
+	}
+	for _, w := range workers {
+		w.Stop()
+	}
+}
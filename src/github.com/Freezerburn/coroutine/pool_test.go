@@ -0,0 +1,41 @@
+package coroutine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPoolReplacesStoppedWorkers(t *testing.T) {
+	p := NewPool(1)
+	defer p.Stop()
+
+	if _, err := p.Submit(func(c Coroutine) (interface{}, error) {
+		c.Stop()
+		return nil, nil
+	}).Await(time.Second); err != ErrNotRunning {
+		t.Fatalf("expected the stopped job to fail with ErrNotRunning, got %v", err)
+	}
+
+	v, err := p.Submit(func(c Coroutine) (interface{}, error) {
+		return "ran", nil
+	}).Await(time.Second)
+	if err != nil || v != "ran" {
+		t.Fatalf("expected a replacement worker to run the next job, got %v, %v", v, err)
+	}
+	if n := p.Stats().Workers; n != 1 {
+		t.Errorf("expected 1 worker, got %d", n)
+	}
+}
+
+func TestPoolIgnoresIdleTimeout(t *testing.T) {
+	p := NewPool(1, WithIdleTimeout(time.Millisecond))
+	defer p.Stop()
+
+	time.Sleep(20 * time.Millisecond)
+	v, err := p.Submit(func(c Coroutine) (interface{}, error) {
+		return "ran", nil
+	}).Await(time.Second)
+	if err != nil || v != "ran" {
+		t.Fatalf("expected the idle worker to still run jobs, got %v, %v", v, err)
+	}
+}
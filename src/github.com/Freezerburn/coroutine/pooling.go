@@ -0,0 +1,67 @@
+package coroutine
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Embeddables of finished coroutines started with WithPooling, ready to be reused by StartFunc and StartFuncName.
+var embeddablePool sync.Pool
+
+// Reuses the Embeddable, receiver channel, timer and mailbox of a finished coroutine for a later one, instead of
+// allocating new ones for every start. Meant for programs that start and stop huge numbers of short lived coroutines,
+// such as one per request, where those allocations add up to a lot of work for the garbage collector.
+//
+// Each coroutine started on a reused Embeddable gets a new Ref. A Ref to the coroutine that ran on it before acts as
+// if that coroutine has finished, as it has: it isn't running, messages sent to it are dead-lettered, and Restart
+// returns ErrNotRunning, so a pooled coroutine can't be restarted once it has finished. Only coroutines started with
+// StartFunc or StartFuncName are pooled; it's ignored for a Starter, which owns its own Embeddable.
+func WithPooling() Option {
+	return func(e *Embeddable) {
+		e.pooled = true
+	}
+}
+
+// An Embeddable for StartFuncName, reused from the pool if there's one in it.
+func newEmbeddable() *Embeddable {
+	if e, ok := embeddablePool.Get().(*Embeddable); ok {
+		e.recycled = true
+		return e
+	}
+	return &Embeddable{owned: true}
+}
+
+// Puts the Embeddable of a finished coroutine back in the pool, once nothing in the library needs it any more. Called
+// as the very last thing its goroutine does.
+func (e *Embeddable) release() {
+	e.drainMailbox()
+	if e.customMailbox != nil {
+		// Belongs to whoever gave it to WithMailbox.
+		e.mailbox = nil
+		e.customMailbox = nil
+	}
+	// A wakeup left over from the old coroutine would only cost the new one a spurious wait, but there's no need.
+	select {
+	case <-e.receiver:
+	default:
+	}
+	atomic.StoreUint64(&e.restarts, 0)
+	e.ackLock.Lock()
+	e.unacked = nil
+	e.ackLock.Unlock()
+	// Let go of everything the old coroutine referred to.
+	e.body = nil
+	e.opts = nil
+	e.run = nil
+	e.recording = nil
+	e.logger = nil
+	e.sender = nil
+	e.ext.Store(nil)
+	// Filled in before the generation moves on, so that a Ref that finds itself stale can rely on them.
+	old := e.self
+	old.id = e.id
+	old.name = e.name
+	old.exit = e.exit()
+	atomic.AddUint64(&e.generation, 1)
+	embeddablePool.Put(e)
+}
@@ -0,0 +1,54 @@
+package coroutine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStaleRefDoesNotReachReusedEmbeddable(t *testing.T) {
+	received := make(chan interface{}, 10)
+	body := func(c Coroutine) {
+		for {
+			received <- c.Recv()
+		}
+	}
+
+	// The pool is free to throw away what's put in it, so this keeps going until an Embeddable is reused.
+	for attempt := 0; attempt < 100; attempt++ {
+		old := StartFunc(body, WithPooling())
+		id := old.Id()
+		old.Stop()
+		er := old.(*embeddableRef)
+		for !er.stale() {
+			time.Sleep(time.Millisecond)
+		}
+
+		next := StartFunc(body, WithPooling())
+		if next.(*embeddableRef).e != er.e {
+			next.Stop()
+			continue
+		}
+		defer next.Stop()
+
+		if old.Running() {
+			t.Fatal("expected the stale Ref to report its coroutine as finished")
+		}
+		if old.Id() != id || next.Id() == id {
+			t.Fatalf("expected each Ref to keep its own id, got %d and %d", old.Id(), next.Id())
+		}
+		old.Send("stale")
+		old.Stop()
+		if err := Restart(old); err != ErrNotRunning {
+			t.Fatalf("expected restarting the stale Ref to fail with ErrNotRunning, got %v", err)
+		}
+		next.Send("current")
+		if v := <-received; v != "current" {
+			t.Fatalf("expected only the message sent through the current Ref, got %v", v)
+		}
+		if !next.Running() {
+			t.Fatal("expected stopping through the stale Ref to leave the new coroutine running")
+		}
+		return
+	}
+	t.Skip("the pool never handed back an Embeddable")
+}
@@ -0,0 +1,122 @@
+package coroutine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limits how quickly messages can be sent to the coroutine with SendBlocking and TrySend, using a token bucket that
+// holds up to burst tokens and refills at perSecond tokens a second. Every message sent with one of those takes a
+// token. The bucket starts full, so a burst of messages can go through straight away before the limit kicks in.
+//
+// Send and everything else on the Ref are never limited, so replies and control messages can't get stuck behind a
+// producer that is being held back.
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(e *Embeddable) {
+		if burst < 1 {
+			burst = 1
+		}
+		e.limiter = &tokenBucket{rate: perSecond, burst: float64(burst)}
+	}
+}
+
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	// When the bucket was last refilled. Zero until the first token is taken, which is when the bucket starts full.
+	last time.Time
+}
+
+// Takes a token if one is available. If not, returns how long until one will be.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.last.IsZero() {
+		b.tokens = b.burst
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, -1
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait <= 0 {
+		wait = 1
+	}
+	return false, wait
+}
+
+// Sends v to r with SendContext once the coroutine's rate limit allows it, waiting for a token if there isn't one.
+// Returns the context's error if it's done first, or ErrNotRunning if the coroutine stops while waiting. Refs that
+// aren't coroutines started with WithRateLimit are sent to straight away.
+func SendBlocking(ctx context.Context, r Ref, v interface{}) error {
+	ref, ok := localRef(r)
+	if !ok || ref.e.limiter == nil {
+		SendContext(ctx, r, v)
+		return nil
+	}
+
+	e := ref.e
+	var timer Timer
+	for {
+		if !e.isRunning() {
+			return ErrNotRunning
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, wait := e.limiter.take(e.clock.Now())
+		if ok {
+			SendContext(ctx, r, v)
+			return nil
+		}
+
+		var fired <-chan time.Time
+		if wait >= 0 {
+			if timer == nil {
+				timer = e.clock.NewTimer(wait)
+				defer timer.Stop()
+			} else {
+				timer.Reset(wait)
+			}
+			fired = timer.Chan()
+		}
+		select {
+		case <-fired:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.done:
+			return ErrNotRunning
+		}
+	}
+}
+
+// Sends v to r if the coroutine's rate limit allows it right now, returning false without sending anything if it
+// doesn't or the coroutine has stopped. Refs that aren't coroutines started with WithRateLimit are always sent to.
+func TrySend(r Ref, v interface{}) bool {
+	ref, ok := localRef(r)
+	if !ok || ref.e.limiter == nil {
+		r.Send(v)
+		return true
+	}
+	if !ref.e.isRunning() {
+		return false
+	}
+	if ok, _ := ref.e.limiter.take(ref.e.clock.Now()); !ok {
+		return false
+	}
+	r.Send(v)
+	return true
+}
@@ -0,0 +1,107 @@
+package coroutine
+
+import (
+	"sync"
+	"time"
+)
+
+// A single message received by a recorded coroutine.
+type RecordedMessage struct {
+	// Position of the message in the order it was received, starting at zero.
+	Seq int
+	// How long after the coroutine started that it received the message.
+	At    time.Duration
+	Value interface{}
+}
+
+// A log of every message a coroutine receives, in the order it received them and with when it received them. The
+// log can be replayed into a fresh instance of the same coroutine to reproduce what happened to it. Messages are
+// stored as-is, so any that are mutated after being received will be replayed as they are now, not as they were.
+type Recording struct {
+	lock     sync.Mutex
+	messages []RecordedMessage
+}
+
+// Creates an empty Recording.
+func NewRecording() *Recording {
+	return &Recording{}
+}
+
+// Records every message the coroutine receives into the given Recording. Several coroutines can share a Recording,
+// but their messages will be interleaved.
+func WithRecording(r *Recording) Option {
+	return func(e *Embeddable) {
+		e.recording = r
+	}
+}
+
+func (r *Recording) record(at time.Duration, v interface{}) {
+	r.lock.Lock()
+	r.messages = append(r.messages, RecordedMessage{
+		Seq:   len(r.messages),
+		At:    at,
+		Value: v,
+	})
+	r.lock.Unlock()
+}
+
+// Every message recorded so far, in the order they were received.
+func (r *Recording) Messages() []RecordedMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]RecordedMessage(nil), r.messages...)
+}
+
+// The number of messages recorded so far.
+func (r *Recording) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.messages)
+}
+
+// Forgets every message recorded so far.
+func (r *Recording) Reset() {
+	r.lock.Lock()
+	r.messages = nil
+	r.lock.Unlock()
+}
+
+// Sends every recorded message to the referenced coroutine straight away, in the order they were originally
+// received.
+func (r *Recording) Replay(to Ref) {
+	for _, m := range r.Messages() {
+		to.Send(m.Value)
+	}
+}
+
+// Sends every recorded message to the referenced coroutine with the same timing, relative to now, that they were
+// originally received with, measured on the coroutine's Clock. Messages are always sent in their original order. The
+// returned CancelFunc stops any messages that haven't been sent yet.
+func (r *Recording) ReplayTimed(to Ref) CancelFunc {
+	messages := r.Messages()
+	cancel := make(chan struct{})
+	c := clockOf(to)
+	start := c.Now()
+	go func() {
+		t := c.NewTimer(0)
+		defer t.Stop()
+		for _, m := range messages {
+			if wait := m.At - c.Now().Sub(start); wait > 0 {
+				t.Reset(wait)
+				select {
+				case <-t.Chan():
+				case <-cancel:
+					return
+				}
+			}
+			to.Send(m.Value)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(cancel)
+		})
+	}
+}
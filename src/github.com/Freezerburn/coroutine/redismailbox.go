@@ -0,0 +1,201 @@
+package coroutine
+
+import (
+	"sync"
+	"time"
+)
+
+// The Redis list commands a RedisMailbox needs, so that it works with whichever Redis client the program already
+// uses. With go-redis, for example, each is a line or two around the client's RPush, LPush, BLPop and LLen, with
+// BLPop returning false on redis.Nil.
+type RedisList interface {
+	// Appends data to the end of the list at key.
+	RPush(key string, data []byte) error
+	// Prepends data to the start of the list at key, so it's the next item BLPop returns.
+	LPush(key string, data []byte) error
+	// Removes and returns the first item of the list at key, waiting up to timeout for there to be one. Returns false
+	// without an error if timeout passes first.
+	BLPop(key string, timeout time.Duration) ([]byte, bool, error)
+	// The length of the list at key.
+	LLen(key string) (int, error)
+}
+
+// How long a RedisMailbox waits in each BLPop, which is also how long Close can take.
+const redisPollTimeout = time.Second
+
+// How long a RedisMailbox waits before trying again after BLPop fails, so a Redis outage doesn't turn into a busy loop.
+const redisRetryDelay = time.Second
+
+// A Mailbox kept in a Redis list, so that coroutines in different processes given RedisMailboxes for the same key
+// share one queue, with each message going to whichever of them takes it first. Starting the same worker coroutine in
+// more processes is then all it takes to handle more messages. Messages sent to any of the coroutines go onto the
+// list, as do those pushed onto it by anything else that encodes them the same way.
+//
+// Values are encoded with the Codec set with SetCodec, so the concrete type of every value sent must be registered
+// with it. Only the value survives the trip through Redis, so a Call or Ask sent to a coroutine with a
+// RedisMailbox never gets its reply, and Sender is always nil. Each message is taken off the list just before it's
+// received, one at a time, so a process that stops loses at most the one it was about to receive.
+//
+// Len only counts a message that has been taken off the list and is waiting to be received; Backlog asks Redis how
+// long the list is. Must be closed with Close once the coroutine it was given to has stopped.
+type RedisMailbox struct {
+	list RedisList
+	key  string
+	// Holds a message taken off the list until the coroutine receives it, and is signalled once it has.
+	next  chan Message
+	taken chan struct{}
+	// What to call when a message is taken off the list, set by Notify.
+	lock sync.Mutex
+	wake func()
+	// Closed by Close, and by the goroutine taking messages off the list once it has returned.
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	// The last error from Redis, if there has been one since the last message was taken off the list.
+	err error
+}
+
+var _ NotifyingMailbox = (*RedisMailbox)(nil)
+
+// Creates a RedisMailbox for the list at key, and starts taking messages off it, which wait until the coroutine it's
+// given to with WithMailbox has started.
+func NewRedisMailbox(list RedisList, key string) *RedisMailbox {
+	m := &RedisMailbox{
+		list:    list,
+		key:     key,
+		next:    make(chan Message, 1),
+		taken:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go m.fetch()
+	return m
+}
+
+// Takes messages off the list one at a time, waiting until the coroutine has received each before taking the next,
+// so the others sharing the list get the rest.
+func (m *RedisMailbox) fetch() {
+	defer close(m.stopped)
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		data, ok, err := m.list.BLPop(m.key, redisPollTimeout)
+		if err != nil {
+			m.setErr(err)
+			select {
+			case <-time.After(redisRetryDelay):
+			case <-m.stop:
+				return
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		v, err := decodeValue(data)
+		if err != nil {
+			// Something else put it on the list, and no coroutine could receive it anyway.
+			deadLetter(DeadLetter{Reason: DeadLetterRejected, Value: data, Err: err})
+			continue
+		}
+		m.setErr(nil)
+
+		select {
+		case m.next <- Message{Value: v}:
+			m.notify()
+		case <-m.stop:
+			// Put it back at the front for one of the others, where it was before it was taken.
+			m.list.LPush(m.key, data)
+			return
+		}
+		select {
+		case <-m.taken:
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *RedisMailbox) setErr(err error) {
+	m.lock.Lock()
+	m.err = err
+	m.lock.Unlock()
+}
+
+func (m *RedisMailbox) notify() {
+	m.lock.Lock()
+	wake := m.wake
+	m.lock.Unlock()
+	if wake != nil {
+		wake()
+	}
+}
+
+// Pushes the message onto the end of the list.
+func (m *RedisMailbox) Enqueue(msg Message) error {
+	select {
+	case <-m.stop:
+		return ErrMailboxClosed
+	default:
+	}
+	data, err := encodeValue(msg.Value)
+	if err != nil {
+		return err
+	}
+	return m.list.RPush(m.key, data)
+}
+
+// Returns the message most recently taken off the list, if the coroutine hasn't received it yet.
+func (m *RedisMailbox) Dequeue() (Message, bool) {
+	select {
+	case msg := <-m.next:
+		m.taken <- struct{}{}
+		return msg, true
+	default:
+		return Message{}, false
+	}
+}
+
+// Whether a message has been taken off the list and is waiting to be received, as 1 or 0.
+func (m *RedisMailbox) Len() int {
+	return len(m.next)
+}
+
+func (m *RedisMailbox) Notify(wake func()) {
+	m.lock.Lock()
+	m.wake = wake
+	m.lock.Unlock()
+}
+
+// How many messages are waiting in the list, for every coroutine sharing it.
+func (m *RedisMailbox) Backlog() (int, error) {
+	return m.list.LLen(m.key)
+}
+
+// The last error from Redis while taking messages off the list, or nil once it has worked again.
+func (m *RedisMailbox) Err() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.err
+}
+
+// Stops taking messages off the list, and waits for the goroutine doing it to finish. A message already taken off the
+// list that the coroutine hasn't received is put back at the front of it. Enqueue returns ErrMailboxClosed from now on.
+func (m *RedisMailbox) Close() error {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+	<-m.stopped
+	select {
+	case msg := <-m.next:
+		if data, err := encodeValue(msg.Value); err == nil {
+			return m.list.LPush(m.key, data)
+		}
+	default:
+	}
+	return nil
+}
@@ -1,11 +1,18 @@
 package coroutine
 
 import (
-	"log"
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
 )
 
 // Simple reference to a coroutine. Allows external code to send messages to that coroutine, stop it, and check
 // various bits of data about it.
+//
+// Everything else that can be done to a coroutine through its Ref, such as Call, Ping or Restart, is a function of
+// this package taking the Ref. Each one uses a small interface of its own, such as Caller or Pinger, which the Refs
+// of coroutines started by this package implement, and falls back on something sensible for a Ref that doesn't.
 type Ref interface {
 	Send(v interface{})
 	Running() bool
@@ -14,56 +21,250 @@ type Ref interface {
 	Stop()
 }
 
+// Returned by the functions taking a Ref when the Ref doesn't implement what they need, such as Call for a Ref that
+// isn't a Caller.
+var ErrUnsupported = errors.New("coroutine: not supported by this Ref")
+
+// Implemented by Refs whose messages can carry a context, used by SendContext.
+type ContextSender interface {
+	SendContext(ctx context.Context, v interface{})
+}
+
+// Implemented by Refs that schedule their own delayed and repeated sends, used by SendAfter and SendEvery.
+type TimedSender interface {
+	SendAfter(v interface{}, d time.Duration) CancelFunc
+	SendEvery(v interface{}, interval time.Duration) CancelFunc
+}
+
+// Implemented by Refs that wrap another, such as Throttle and NewCircuitBreaker, so that the functions taking a Ref
+// reach the wrapped one for anything the wrapper doesn't implement itself.
+type wrappingRef interface {
+	wrapped() Ref
+}
+
+// r, or the first Ref it wraps that is a T.
+func refAs[T any](r Ref) (T, bool) {
+	for {
+		if t, ok := r.(T); ok {
+			return t, true
+		}
+		w, ok := r.(wrappingRef)
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		r = w.wrapped()
+	}
+}
+
+// Sends v to r carrying ctx, for the coroutine to get back with Context once it has received it. A Ref that isn't a
+// ContextSender is sent v with its own Send, without the context.
+func SendContext(ctx context.Context, r Ref, v interface{}) {
+	if s, ok := refAs[ContextSender](r); ok {
+		s.SendContext(ctx, v)
+		return
+	}
+	r.Send(v)
+}
+
+// Sends v to r once d has passed, without blocking the caller, unless the returned CancelFunc is called first. A Ref
+// that isn't a TimedSender is sent v with its own Send, waiting on r's Clock, as long as it's still running by then.
+func SendAfter(r Ref, v interface{}, d time.Duration) CancelFunc {
+	if s, ok := refAs[TimedSender](r); ok {
+		return s.SendAfter(v, d)
+	}
+	return sendAfter(r, v, d)
+}
+
+// Sends v to r every time interval passes, without blocking the caller, until the returned CancelFunc is called or r
+// stops. A Ref that isn't a TimedSender is sent v with its own Send, waiting on r's Clock.
+func SendEvery(r Ref, v interface{}, interval time.Duration) CancelFunc {
+	if s, ok := refAs[TimedSender](r); ok {
+		return s.SendEvery(v, interval)
+	}
+	return sendEvery(r, v, interval)
+}
+
+// SendAfter for Refs that aren't coroutines themselves, going through r's own Send once d has passed on r's Clock.
+func sendAfter(r Ref, v interface{}, d time.Duration) CancelFunc {
+	return afterFunc(clockOf(r), d, nil, func() {
+		if r.Running() {
+			r.Send(v)
+		}
+	})
+}
+
+// SendEvery for Refs that aren't coroutines themselves, going through r's own Send every interval on r's Clock until
+// cancelled or r is no longer running.
+func sendEvery(r Ref, v interface{}, interval time.Duration) CancelFunc {
+	return everyFunc(clockOf(r), interval, nil, func() bool {
+		if !r.Running() {
+			return false
+		}
+		r.Send(v)
+		return true
+	})
+}
+
+// Returned by functions that schedule work to happen later. Calling it prevents that work from happening if it
+// hasn't happened yet. It is always safe to call, even multiple times or after the work has already been done.
+type CancelFunc func()
+
 // Separate struct from the Embeddable coroutine so that the Stop function can behave differently for external code
 // versus internal to the coroutine.
 type embeddableRef struct {
 	e *Embeddable
+	// The generation of e this refers to. Once a pooled Embeddable has moved on to the next one, this refers to a
+	// coroutine that has finished, and leaves e alone.
+	gen uint64
+	// The id, name and exit of the coroutine this refers to, filled in only once it's stale.
+	id   uint64
+	name string
+	exit Exit
 }
 
-// Puts a message into the mailbox of the coroutine this references.
+var (
+	_ Ref           = (*embeddableRef)(nil)
+	_ ContextSender = (*embeddableRef)(nil)
+	_ TimedSender   = (*embeddableRef)(nil)
+	_ Caller        = (*embeddableRef)(nil)
+	_ Pinger        = (*embeddableRef)(nil)
+	_ Replaceable   = (*embeddableRef)(nil)
+	_ Suspendable   = (*embeddableRef)(nil)
+	_ StatsReporter = (*embeddableRef)(nil)
+	_ Restartable   = (*embeddableRef)(nil)
+)
+
+// Whether the coroutine this references finished and its Embeddable has been reused for another since, as can happen
+// with WithPooling.
+func (r *embeddableRef) stale() bool {
+	return atomic.LoadUint64(&r.e.generation) != r.gen
+}
+
+// The Ref as an *embeddableRef, provided it is one and isn't stale. Takes the place of a type assertion wherever the
+// library reaches into the Embeddable behind a Ref, so that what a stale Ref is left with is its methods, which act as
+// if the coroutine has finished.
+func localRef(r Ref) (*embeddableRef, bool) {
+	er, ok := r.(*embeddableRef)
+	if !ok || er.stale() {
+		return nil, false
+	}
+	return er, true
+}
+
+func (r *embeddableRef) deliver(m mail) {
+	if r.stale() {
+		deadLetter(DeadLetter{Reason: DeadLetterStopped, Value: m.v, To: r, From: m.from})
+		return
+	}
+	r.e.deliver(m)
+}
+
+// Puts a message into the mailbox of the coroutine this references. If the coroutine has already stopped, the message
+// is dropped, since nothing will ever receive it.
+//
+// Messages sent one after another from the same goroutine are always received in the order they were sent, whatever
+// else is sending to the coroutine at the same time. Messages from different goroutines are received in the order
+// their sends happened to reach the mailbox.
 func (r *embeddableRef) Send(v interface{}) {
-	r.e.mailboxLock.Lock()
-	r.e.mailbox = append(r.e.mailbox, v)
-	r.e.mailboxLock.Unlock()
-
-	// If the coroutine is waiting on the mailbox, let it know. Otherwise continue immediately so the sender
-	// doesn't get blocked.
-	select {
-	case r.e.receiver <- true:
-	default:
+	r.deliver(mail{v: v})
+}
+
+// The same as Send, but the message carries ctx with it. The coroutine can get it back with Context once it has
+// received the message. If span hooks are set with SetSpanHooks, they're used to start tracing spans for sending and
+// processing the message.
+func (r *embeddableRef) SendContext(ctx context.Context, v interface{}) {
+	if h := currentSpanHooks(); h != nil {
+		ctx = h.StartSend(ctx, r, v)
 	}
+	r.deliver(mail{v: v, ctx: ctx})
+}
+
+// Puts a message into the mailbox of the coroutine this references once the given duration has passed, without
+// blocking the caller. If the coroutine has stopped by the time the duration passes, the message is dropped. The
+// returned CancelFunc can be used to prevent the message from being sent.
+func (r *embeddableRef) SendAfter(v interface{}, d time.Duration) CancelFunc {
+	e := r.e
+	if !r.Running() {
+		return func() {}
+	}
+	// Waits on the coroutine's own Clock, and gives up as soon as the coroutine stops rather than hanging around
+	// until d has passed.
+	return afterFunc(e.clock, d, e.done, func() {
+		if r.Running() {
+			r.Send(v)
+		}
+	})
+}
+
+// Puts a message into the mailbox of the coroutine this references every time the interval passes, without blocking
+// the caller. Sending continues until the returned CancelFunc is called or the coroutine stops. Like time.Ticker, if
+// the coroutine is slow to process its mailbox the messages will pile up rather than being dropped, so the interval
+// should be comfortably longer than the time it takes to handle one.
+func (r *embeddableRef) SendEvery(v interface{}, interval time.Duration) CancelFunc {
+	e := r.e
+	if r.stale() {
+		return func() {}
+	}
+	return everyFunc(e.clock, interval, e.done, func() bool {
+		if !r.Running() {
+			return false
+		}
+		r.Send(v)
+		return true
+	})
+}
+
+func (r *embeddableRef) refClock() Clock {
+	if r.stale() {
+		return defaultClock()
+	}
+	return r.e.clock
 }
 
 // Whether or not the coroutine this references is still running.
 func (r *embeddableRef) Running() bool {
-	return r.e.running
+	return !r.stale() && r.e.isRunning()
 }
 
 // The name given to the coroutine this references at start time. If no name was given, a generic name is assigned.
 func (r *embeddableRef) Name() string {
+	if r.stale() {
+		return r.name
+	}
 	return r.e.name
 }
 
 // The unique ID of the coroutine this references.
 func (r *embeddableRef) Id() uint64 {
+	if r.stale() {
+		return r.id
+	}
 	return r.e.id
 }
 
+// A snapshot of how busy the coroutine this references is.
+func (r *embeddableRef) Stats() Stats {
+	if r.stale() {
+		return Stats{}
+	}
+	return r.e.stats()
+}
+
 // Stops the coroutine this references. Will not immediately halt execution of the coroutine, but when it calls any
 // of the methods on the Embeddable struct, execution will halt at that point. So if it's in a tight loop, that
 // loop will finish.
 func (r *embeddableRef) Stop() {
-	if !r.e.running {
-		log.Printf("Coroutine [%v / %s] attempted to be stopped when it isn't running, possible bug found.",
-			r.e.id, r.e.name)
+	if r.stale() {
+		return
+	}
+	if !r.e.isRunning() {
+		r.e.logWarn("Coroutine attempted to be stopped when it isn't running, possible bug found.")
 		return
 	}
 
-	r.e.running = false
+	r.e.setRunning(false)
 	// If the coroutine is in the middle of attempting to receive something, immediately cause it to stop attempting
 	// to receive so it can detect that it needs to stop.
-	select {
-	case r.e.receiver <- true:
-	default:
-	}
+	r.e.notify()
 }
@@ -0,0 +1,242 @@
+package coroutine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Every coroutine that has been started and hasn't finished yet, by id, and by the id of the goroutine it runs on
+// once it has started running. Both are sync.Maps, each key only ever written by the coroutine it belongs to, so that
+// starting and finishing coroutines from many goroutines at once don't wait for each other.
+var (
+	registry   sync.Map
+	goroutines sync.Map
+)
+
+// Every coroutine started with WithUniqueName that hasn't finished yet, by name. Only these coroutines take the lock.
+var (
+	names     = make(map[string]*Embeddable)
+	namesLock sync.Mutex
+)
+
+// Returned by Restart when the coroutine was started with WithUniqueName and another coroutine took its name while
+// it was being restarted.
+var ErrNameTaken = errors.New("coroutine: name is taken by another coroutine")
+
+// Adds the coroutine to the registry, unless it was started with WithUniqueName and another coroutine that is still
+// running already has its name, in which case that one is returned instead.
+func register(e *Embeddable) *Embeddable {
+	if e.unique {
+		namesLock.Lock()
+		if existing := names[e.name]; existing != nil && existing.isRunning() {
+			namesLock.Unlock()
+			return existing
+		}
+		names[e.name] = e
+		namesLock.Unlock()
+	}
+	registry.Store(e.id, e)
+	return nil
+}
+
+// Called from the coroutine's own goroutine as soon as it starts running.
+func registerGoroutine(e *Embeddable) {
+	id := currentGoroutineId()
+	atomic.StoreUint64(&e.goid, id)
+	goroutines.Store(id, e)
+}
+
+func unregister(e *Embeddable) {
+	registry.CompareAndDelete(e.id, e)
+	if e.unique {
+		namesLock.Lock()
+		if names[e.name] == e {
+			delete(names, e.name)
+		}
+		namesLock.Unlock()
+	}
+	if id := atomic.LoadUint64(&e.goid); id != 0 {
+		goroutines.CompareAndDelete(id, e)
+	}
+}
+
+// The live coroutine with the given id, if there is one.
+func lookupId(id uint64) (*Embeddable, bool) {
+	e, ok := registry.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return e.(*Embeddable), true
+}
+
+// The coroutine the calling goroutine belongs to, or nil if it isn't one. Finding out which goroutine is calling is
+// relatively slow, so this shouldn't be done on every message unless asked for.
+func callingCoroutine() *Embeddable {
+	e, ok := goroutines.Load(currentGoroutineId())
+	if !ok {
+		return nil
+	}
+	return e.(*Embeddable)
+}
+
+// A snapshot of every live coroutine. The coroutines may finish at any time after this returns.
+func liveCoroutines() []*Embeddable {
+	var live []*Embeddable
+	registry.Range(func(id, e interface{}) bool {
+		live = append(live, e.(*Embeddable))
+		return true
+	})
+	return live
+}
+
+// Makes the coroutine the only one with its name, so it can be found with Whereis, for singletons such as a
+// coroutine managing configuration. If a coroutine with the same name was started with this option and is still
+// running, nothing is started, and the Start function returns that coroutine's Ref instead. The name is free again
+// once the coroutine has been asked to stop.
+func WithUniqueName() Option {
+	return func(e *Embeddable) {
+		e.unique = true
+	}
+}
+
+// The coroutine started with WithUniqueName that has the given name, if it's still running.
+func Whereis(name string) (Ref, bool) {
+	namesLock.Lock()
+	e := names[name]
+	namesLock.Unlock()
+	if e == nil || !e.isRunning() {
+		return nil, false
+	}
+	return e.ref(), true
+}
+
+// Attaches labels to the coroutine, such as the component it belongs to or the tenant it works for, so that it can
+// be found with Find. They're also reported in Info.
+func WithLabels(labels map[string]string) Option {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return func(e *Embeddable) {
+		e.labels = copied
+	}
+}
+
+// Every running coroutine that has all of the labels in selector, with the same values, ordered by id. An empty
+// selector matches every running coroutine. Pass the result to Multicast to send to all of them, or stop them all at
+// once. The coroutines may finish at any time after this returns.
+func Find(selector map[string]string) []Ref {
+	live := liveCoroutines()
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].id < live[j].id
+	})
+	var found []Ref
+	for _, e := range live {
+		if e.isRunning() && e.hasLabels(selector) {
+			found = append(found, e.ref())
+		}
+	}
+	return found
+}
+
+func (e *Embeddable) hasLabels(selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := e.labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// What a coroutine is doing at the moment, as reported by Info.
+type State int32
+
+const (
+	// Running its own code.
+	StateRunning State = iota
+	// Waiting in one of the Recv functions for a message to arrive.
+	StateWaiting
+	// Waiting for a Pause to finish.
+	StatePaused
+	// Asked to stop, but hasn't reached a point where it notices yet.
+	StateStopping
+	// Suspended with Suspend, or started with StartFuncPaused, and waiting for Resume.
+	StateSuspended
+	// Started with WithLazyStart, and nothing has needed it to run yet.
+	StateUnstarted
+)
+
+func (s State) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateWaiting:
+		return "waiting"
+	case StatePaused:
+		return "paused"
+	case StateStopping:
+		return "stopping"
+	case StateSuspended:
+		return "suspended"
+	case StateUnstarted:
+		return "unstarted"
+	}
+	return fmt.Sprintf("State(%d)", int32(s))
+}
+
+// A description of a single live coroutine, returned by List.
+type Info struct {
+	Id   uint64
+	Name string
+	// The id of the coroutine given to WithParent, or zero if it has none.
+	Parent     uint64
+	State      State
+	MailboxLen int
+	Started    time.Time
+	// How many times the coroutine has been restarted.
+	Restarts uint64
+	// The labels given to WithLabels, or nil. Must not be modified.
+	Labels map[string]string
+}
+
+func (e *Embeddable) setState(s State) {
+	atomic.StoreInt32(&e.state, int32(s))
+}
+
+func (e *Embeddable) info() Info {
+	state := State(atomic.LoadInt32(&e.state))
+	if !e.isRunning() {
+		state = StateStopping
+	}
+
+	n := e.mailbox.len()
+
+	return Info{
+		Id:         e.id,
+		Name:       e.name,
+		Parent:     e.parent,
+		State:      state,
+		MailboxLen: n,
+		Started:    e.startedAt(),
+		Restarts:   atomic.LoadUint64(&e.restarts),
+		Labels:     e.labels,
+	}
+}
+
+// Describes every coroutine that is running right now, ordered by id. The coroutines may change state or finish at
+// any time after this returns.
+func List() []Info {
+	live := liveCoroutines()
+	infos := make([]Info, len(live))
+	for i, e := range live {
+		infos[i] = e.info()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos
+}
@@ -0,0 +1,702 @@
+package coroutine
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// Returned for messages sent through a RemoteNode once its connection has closed, and by Calls still waiting
+	// for a reply when it does.
+	ErrRemoteClosed = errors.New("coroutine: remote connection closed")
+	// Returned by what can't be done over the wire, such as a Transfer to a coroutine in another process.
+	ErrRemoteUnsupported = errors.New("coroutine: not supported by remote refs")
+	// Returned by Spawn when the other process has nothing registered with RegisterSpawnable under the name asked for.
+	ErrNotSpawnable = errors.New("coroutine: no function registered to spawn with that name")
+)
+
+// The kinds of remoteFrame.
+const (
+	// A message for the coroutine named To.
+	remoteSend byte = iota
+	// A message for the coroutine named To, whose reply is sent back with the same Id.
+	remoteAsk
+	// The reply to the remoteAsk or remoteRunning with the same Id, or why there isn't one in Err.
+	remoteReply
+	// Asks whether the coroutine named To is running, with the answer sent back with the same Id.
+	remoteRunning
+	// Stops the coroutine named To.
+	remoteStop
+	// Checks the connection, with an empty remoteReply sent back with the same Id.
+	remotePing
+	// Monitors the coroutine named To, with a remoteExit sent back with the same Id once it finishes.
+	remoteMonitor
+	// The coroutine monitored by the remoteMonitor with the same Id has finished for Reason, with the panic in Err.
+	remoteExit
+	// Starts the function registered as Function, as a coroutine named To, with an empty remoteReply sent back with
+	// the same Id once it has started, or why it couldn't be in Err.
+	remoteSpawn
+)
+
+// What's sent over a remoting connection, encoded with gob. Values are encoded separately with the Codec, so that one
+// that can't be decoded only loses that message, rather than breaking the whole connection.
+type remoteFrame struct {
+	Kind    byte
+	Id      uint64
+	To      string
+	Value   []byte
+	Timeout time.Duration
+	Err     string
+	Reason  ExitReason
+	// The name of the function a remoteSpawn starts, as given to RegisterSpawnable.
+	Function string
+}
+
+// The errors that keep their identity across the wire, so that the caller of a remote Call can compare against them.
+var remoteErrors = []error{
+	ErrCallTimeout, ErrNotRunning, ErrCancelled, ErrRemoteClosed, ErrRemoteUnsupported, ErrNotSpawnable,
+}
+
+func remoteError(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, err := range remoteErrors {
+		if err.Error() == s {
+			return err
+		}
+	}
+	return errors.New(s)
+}
+
+// One end of a remoting connection. Frames are written by whichever goroutine has one to send, and read by a
+// goroutine of the connection's own.
+type remoteConn struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	enc       *gob.Encoder
+	dec       *gob.Decoder
+}
+
+func newRemoteConn(conn net.Conn) *remoteConn {
+	return &remoteConn{conn: conn, enc: gob.NewEncoder(conn), dec: gob.NewDecoder(conn)}
+}
+
+func (c *remoteConn) write(f remoteFrame) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.enc.Encode(f)
+}
+
+func (c *remoteConn) read() (remoteFrame, error) {
+	var f remoteFrame
+	err := c.dec.Decode(&f)
+	return f, err
+}
+
+// Hosts the coroutines of this process for other processes, which reach them through RemoteNodes from Dial. Messages
+// arriving are sent to the coroutine with the name they're addressed to, as found by Whereis, so only coroutines
+// started with WithUniqueName can be reached. One sent to a name with no coroutine goes to the dead letter handler.
+//
+// Values are encoded with the Codec set with SetCodec, which must be the same in both processes, so the concrete type
+// of every value sent, and of every reply, must be registered with it in both. There is no authentication or
+// encryption: a Listener should only be reachable by processes that are trusted to send messages to any of its
+// coroutines.
+type Listener struct {
+	l       net.Listener
+	lock    sync.Mutex
+	conns   map[*remoteConn]struct{}
+	closed  bool
+	serving sync.WaitGroup
+}
+
+// Listens on the given network and address, as for net.Listen, such as "tcp" and ":7000" or "unix" and a socket path,
+// and starts accepting connections from other processes.
+func Listen(network, address string) (*Listener, error) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return Serve(l), nil
+}
+
+// Starts accepting connections from other processes on l, which the Listener closes when it's closed. Lets the
+// connections be wrapped, such as with crypto/tls.
+func Serve(l net.Listener) *Listener {
+	s := &Listener{l: l, conns: make(map[*remoteConn]struct{})}
+	s.serving.Add(1)
+	go s.accept()
+	return s
+}
+
+func (s *Listener) accept() {
+	defer s.serving.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		c := newRemoteConn(conn)
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.serving.Add(1)
+		s.lock.Unlock()
+		go s.serve(c)
+	}
+}
+
+// Identifies a remoteMonitor when it's registered to be told once its coroutine finishes.
+type remoteMonitorKey struct {
+	c  *remoteConn
+	id uint64
+}
+
+func (s *Listener) serve(c *remoteConn) {
+	defer s.serving.Done()
+	// The coroutines monitored over this connection that haven't finished yet, which stop telling it when they finish
+	// once it has closed. Locked since each one removes itself as it finishes.
+	monitored := make(map[remoteMonitorKey]*Embeddable)
+	var monitoredLock sync.Mutex
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, c)
+		s.lock.Unlock()
+		c.conn.Close()
+		monitoredLock.Lock()
+		for key, e := range monitored {
+			e.cancelOnExit(key)
+		}
+		monitoredLock.Unlock()
+	}()
+	for {
+		f, err := c.read()
+		if err != nil {
+			return
+		}
+		switch f.Kind {
+		case remoteSend:
+			v, err := decodeValue(f.Value)
+			if err != nil {
+				deadLetter(DeadLetter{Reason: DeadLetterRejected, Value: f.Value, Err: err})
+				continue
+			}
+			to, ok := Whereis(f.To)
+			if !ok {
+				deadLetter(DeadLetter{Reason: DeadLetterStopped, Value: v})
+				continue
+			}
+			to.Send(v)
+		case remoteAsk:
+			// Answered from a goroutine of its own, so a slow reply doesn't hold up everything behind it.
+			go s.answer(c, f)
+		case remoteRunning:
+			_, ok := Whereis(f.To)
+			reply := remoteFrame{Kind: remoteReply, Id: f.Id}
+			reply.Value, _ = encodeValue(ok)
+			c.write(reply)
+		case remoteStop:
+			if to, ok := Whereis(f.To); ok {
+				to.Stop()
+			}
+		case remotePing:
+			c.write(remoteFrame{Kind: remoteReply, Id: f.Id})
+		case remoteSpawn:
+			reply := remoteFrame{Kind: remoteReply, Id: f.Id}
+			if err := spawn(f.Function, f.To); err != nil {
+				reply.Err = err.Error()
+			}
+			c.write(reply)
+		case remoteMonitor:
+			to, ok := Whereis(f.To)
+			ref, local := to.(*embeddableRef)
+			if !ok || !local {
+				c.write(remoteFrame{Kind: remoteExit, Id: f.Id, Reason: ExitStopped})
+				continue
+			}
+			key := remoteMonitorKey{c, f.Id}
+			monitoredLock.Lock()
+			monitored[key] = ref.e
+			monitoredLock.Unlock()
+			exited := func() {
+				monitoredLock.Lock()
+				delete(monitored, key)
+				monitoredLock.Unlock()
+				ref.e.linkLock.Lock()
+				exit := ref.e.exit()
+				ref.e.linkLock.Unlock()
+				frame := remoteFrame{Kind: remoteExit, Id: f.Id, Reason: exit.Reason}
+				if exit.Reason == ExitPanicked {
+					frame.Err = fmt.Sprint(exit.Panic)
+				}
+				// From a goroutine of its own, since this runs while the coroutine is finishing.
+				go c.write(frame)
+			}
+			if !ref.e.onExit(key, exited) {
+				exited()
+			}
+		}
+	}
+}
+
+func (s *Listener) answer(c *remoteConn, f remoteFrame) {
+	reply := remoteFrame{Kind: remoteReply, Id: f.Id}
+	v, err := decodeValue(f.Value)
+	if err == nil {
+		if to, ok := Whereis(f.To); ok {
+			v, err = Call(to, v, f.Timeout)
+		} else {
+			v, err = nil, ErrNotRunning
+		}
+	}
+	if err == nil {
+		reply.Value, err = encodeValue(v)
+	}
+	if err != nil {
+		reply.Err = err.Error()
+	}
+	c.write(reply)
+}
+
+// A function other processes can start, and the options to start it with.
+type spawnable struct {
+	f    Function
+	opts []Option
+}
+
+// Every function registered with RegisterSpawnable, by the name it was registered under.
+var (
+	spawnables     = make(map[string]spawnable)
+	spawnablesLock sync.Mutex
+)
+
+// Lets other processes start coroutines running f, with the given options, through a Listener in this process, by
+// asking for function with RemoteNode.Spawn or SpawnRemote. Only the name crosses the wire, never the code, so f has
+// to be registered in the process that runs it. Each coroutine is started with WithUniqueName under the name the
+// other process asks for, so it can be reached by that name straight away. Registering a function under a name that
+// is already taken replaces the previous one.
+func RegisterSpawnable(function string, f Function, opts ...Option) {
+	spawnablesLock.Lock()
+	spawnables[function] = spawnable{f: f, opts: append([]Option(nil), opts...)}
+	spawnablesLock.Unlock()
+}
+
+// Starts the function registered under the given name as a coroutine called name. If a coroutine with that name is
+// already running, it's left alone, so asking again after an answer was lost doesn't start a second one.
+func spawn(function, name string) error {
+	spawnablesLock.Lock()
+	s, ok := spawnables[function]
+	spawnablesLock.Unlock()
+	if !ok {
+		return ErrNotSpawnable
+	}
+	StartFuncName(name, s.f, append(append([]Option(nil), s.opts...), WithUniqueName())...)
+	return nil
+}
+
+// The address the Listener is listening on, for finding out which port it was given when listening on port 0.
+func (s *Listener) Addr() net.Addr {
+	return s.l.Addr()
+}
+
+// Stops accepting connections, closes the ones already made, and waits for them to finish. Messages already handed to
+// local coroutines are still delivered.
+func (s *Listener) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.lock.Unlock()
+	err := s.l.Close()
+	s.serving.Wait()
+	return err
+}
+
+// A connection to a Listener in another process, through which its coroutines can be sent messages by name. Safe for
+// concurrent use.
+type RemoteNode struct {
+	c       *remoteConn
+	address string
+	lock    sync.Mutex
+	// Every remoteAsk waiting for its reply, and every coroutine monitoring one in the other process, by id. Once
+	// closed is set, no more are added.
+	calls    map[uint64]*Future
+	monitors map[uint64]remoteMonitoring
+	nextCall uint64
+	closed   bool
+	done     chan struct{}
+}
+
+// Connects to the Listener at the given network and address, as for net.Dial, giving up after remoteDialTimeout.
+func Dial(network, address string) (*RemoteNode, error) {
+	conn, err := net.DialTimeout(network, address, remoteDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewRemoteNode(conn), nil
+}
+
+// Uses a connection that has already been made to a Listener, such as one wrapped with crypto/tls.
+func NewRemoteNode(conn net.Conn) *RemoteNode {
+	n := &RemoteNode{
+		c:        newRemoteConn(conn),
+		address:  conn.RemoteAddr().String(),
+		calls:    make(map[uint64]*Future),
+		monitors: make(map[uint64]remoteMonitoring),
+		done:     make(chan struct{}),
+	}
+	go n.read()
+	return n
+}
+
+func (n *RemoteNode) read() {
+	for {
+		f, err := n.c.read()
+		if err != nil {
+			n.shutdown(ErrRemoteClosed)
+			return
+		}
+		if f.Kind == remoteExit {
+			n.exited(f)
+			continue
+		}
+		if f.Kind != remoteReply {
+			continue
+		}
+		n.lock.Lock()
+		future := n.calls[f.Id]
+		delete(n.calls, f.Id)
+		n.lock.Unlock()
+
+		var v interface{}
+		err = remoteError(f.Err)
+		if err == nil && len(f.Value) > 0 {
+			v, err = decodeValue(f.Value)
+		}
+		if future == nil || !future.resolve(v, err) {
+			if err == nil {
+				deadLetter(DeadLetter{Reason: DeadLetterStaleReply, Value: v})
+			}
+		}
+	}
+}
+
+// Closes the connection and fails every Call still waiting for a reply with err.
+func (n *RemoteNode) shutdown(err error) {
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		return
+	}
+	n.closed = true
+	calls := n.calls
+	n.calls = nil
+	monitors := n.monitors
+	n.monitors = nil
+	n.lock.Unlock()
+
+	n.c.conn.Close()
+	for _, f := range calls {
+		f.resolve(nil, err)
+	}
+	for id, m := range monitors {
+		m.e.cancelOnExit(remoteMonitorKey{n.c, id})
+		m.e.deliver(mail{v: Exit{From: m.ref, Reason: ExitDisconnected}, from: m.ref})
+	}
+	close(n.done)
+}
+
+// A Ref to the coroutine with the given name in the other process. Nothing is sent to find out whether there is one:
+// messages sent to a name with no coroutine go to the dead letter handler in the other process, and Calls to it fail
+// with ErrNotRunning.
+//
+// Send and the functions built on it, Call, Ask, Running and Stop work over the wire, with Running asking the other
+// process and waiting up to remoteQueryTimeout for the answer. The context given to SendContext stays behind, and Id
+// is always 0. Nothing else can be done over the wire, so StatsOf is always empty, Ping always reports it as not
+// alive, Suspend and Resume do nothing, Restart returns ErrUnsupported and Replace returns ErrNotReplaceable. Once the
+// connection has closed, the Ref stays broken; LookupRemote returns Refs that reconnect instead.
+func (n *RemoteNode) Ref(name string) Ref {
+	return &remoteRef{n: n, name: name}
+}
+
+// Starts the function registered with RegisterSpawnable under the given name in the other process, as a coroutine
+// called name, and returns a Ref to it like the one from Ref. Waits up to remoteQueryTimeout for the other process to
+// say it has started. If a coroutine called name is already running there, that one is returned rather than another
+// being started. Returns ErrNotSpawnable if the other process has nothing registered under function.
+func (n *RemoteNode) Spawn(function, name string) (Ref, error) {
+	r := &remoteRef{n: n, name: name}
+	if err := r.spawn(function); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// The address of the Listener this is connected to.
+func (n *RemoteNode) Address() string {
+	return n.address
+}
+
+// Closed once the connection has closed, because of Close or because it failed.
+func (n *RemoteNode) Done() <-chan struct{} {
+	return n.done
+}
+
+// Closes the connection. Calls still waiting for replies fail with ErrRemoteClosed, and messages sent afterwards go to
+// the dead letter handler.
+func (n *RemoteNode) Close() error {
+	n.shutdown(ErrRemoteClosed)
+	return nil
+}
+
+func (n *RemoteNode) isOpen() bool {
+	select {
+	case <-n.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// A Ref to a coroutine in another process, from RemoteNode.Ref, which always uses n, or from LookupRemote, which uses
+// whichever connection its pool has open.
+type remoteRef struct {
+	n    *RemoteNode
+	pool *remotePool
+	name string
+}
+
+var _ Ref = (*remoteRef)(nil)
+
+func (r *remoteRef) node() (*RemoteNode, error) {
+	if r.pool != nil {
+		return r.pool.get()
+	}
+	if !r.n.isOpen() {
+		return nil, ErrRemoteClosed
+	}
+	return r.n, nil
+}
+
+// Writes a frame to the connection, closing it if that fails so the next use of a pooled Ref reconnects.
+func (n *RemoteNode) write(f remoteFrame) error {
+	if err := n.c.write(f); err != nil {
+		n.shutdown(ErrRemoteClosed)
+		return err
+	}
+	return nil
+}
+
+func (r *remoteRef) Send(v interface{}) {
+	data, err := encodeValue(v)
+	if err == nil {
+		var n *RemoteNode
+		if n, err = r.node(); err == nil {
+			err = n.write(remoteFrame{Kind: remoteSend, To: r.name, Value: data})
+		}
+	}
+	if err != nil {
+		deadLetter(DeadLetter{Reason: DeadLetterRejected, Value: v, To: r, Err: err})
+	}
+}
+
+// Waits on this process's Clock, since the remote coroutine's isn't known here.
+func (r *remoteRef) SendAfter(v interface{}, d time.Duration) CancelFunc {
+	return afterFunc(defaultClock(), d, r.done(), func() {
+		r.Send(v)
+	})
+}
+
+func (r *remoteRef) SendEvery(v interface{}, interval time.Duration) CancelFunc {
+	return everyFunc(defaultClock(), interval, r.done(), func() bool {
+		r.Send(v)
+		return true
+	})
+}
+
+// Closed once the Ref can't be used any more, which for a pooled one is never.
+func (r *remoteRef) done() <-chan struct{} {
+	if r.pool != nil {
+		return nil
+	}
+	return r.n.done
+}
+
+func (r *remoteRef) Ask(v interface{}) *Future {
+	data, err := encodeValue(v)
+	if err != nil {
+		return Resolved(nil, err)
+	}
+	return r.request(remoteFrame{Kind: remoteAsk, To: r.name, Value: data})
+}
+
+// Sends a frame that's answered with a remoteReply, with a Future for the answer.
+func (r *remoteRef) request(frame remoteFrame) *Future {
+	n, err := r.node()
+	if err != nil {
+		return Resolved(nil, err)
+	}
+	return n.request(frame)
+}
+
+func (n *RemoteNode) request(frame remoteFrame) *Future {
+	f := newFuture()
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		f.resolve(nil, ErrRemoteClosed)
+		return f
+	}
+	n.nextCall++
+	id := n.nextCall
+	n.calls[id] = f
+	n.lock.Unlock()
+	f.cancel = func() {
+		n.lock.Lock()
+		delete(n.calls, id)
+		n.lock.Unlock()
+	}
+
+	frame.Id = id
+	// Failing closes the connection, which resolves f.
+	n.write(frame)
+	return f
+}
+
+func (r *remoteRef) Call(v interface{}, timeout time.Duration) (interface{}, error) {
+	data, err := encodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	// The other process Calls the coroutine with the same timeout.
+	f := r.request(remoteFrame{Kind: remoteAsk, To: r.name, Value: data, Timeout: timeout})
+	reply, err := f.Await(timeout)
+	if err == ErrCallTimeout {
+		f.Cancel()
+	}
+	return reply, err
+}
+
+// Asks the other process to start function as the coroutine this references.
+func (r *remoteRef) spawn(function string) error {
+	f := r.request(remoteFrame{Kind: remoteSpawn, To: r.name, Function: function})
+	_, err := f.Await(remoteQueryTimeout)
+	if err != nil {
+		f.Cancel()
+	}
+	return err
+}
+
+// Asks the other process whether the coroutine is running. False if it couldn't be asked.
+func (r *remoteRef) Running() bool {
+	running, err := r.running()
+	return err == nil && running
+}
+
+func (r *remoteRef) running() (bool, error) {
+	f := r.request(remoteFrame{Kind: remoteRunning, To: r.name})
+	v, err := f.Await(remoteQueryTimeout)
+	if err != nil {
+		f.Cancel()
+		return false, err
+	}
+	running, _ := v.(bool)
+	return running, nil
+}
+
+func (r *remoteRef) Name() string {
+	return r.name
+}
+
+func (r *remoteRef) Id() uint64 {
+	return 0
+}
+
+// Asks the other process to stop the coroutine, without waiting for it to.
+func (r *remoteRef) Stop() {
+	if n, err := r.node(); err == nil {
+		n.write(remoteFrame{Kind: remoteStop, To: r.name})
+	}
+}
+
+// A coroutine monitoring one in the other process, and the Ref it monitored it through, which is what its Exit comes
+// from.
+type remoteMonitoring struct {
+	e   *Embeddable
+	ref Ref
+}
+
+// Asks the other process to say when the coroutine finishes, delivering an Exit to e when it does, or when the
+// connection is lost first.
+func (r *remoteRef) monitor(e *Embeddable) {
+	n, err := r.node()
+	if err != nil {
+		e.deliver(mail{v: Exit{From: r, Reason: ExitDisconnected}, from: r})
+		return
+	}
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		e.deliver(mail{v: Exit{From: r, Reason: ExitDisconnected}, from: r})
+		return
+	}
+	n.nextCall++
+	id := n.nextCall
+	n.monitors[id] = remoteMonitoring{e: e, ref: r}
+	n.lock.Unlock()
+
+	// Forgotten once the monitoring coroutine finishes, so the connection doesn't keep it alive.
+	key := remoteMonitorKey{n.c, id}
+	forget := func() {
+		n.lock.Lock()
+		delete(n.monitors, id)
+		n.lock.Unlock()
+	}
+	if !e.onExit(key, forget) {
+		forget()
+		return
+	}
+	// Failing closes the connection, which delivers the Exit.
+	n.write(remoteFrame{Kind: remoteMonitor, Id: id, To: r.name})
+}
+
+// Stops e being told when the coroutine finishes. The other process still sends its remoteExit, which is ignored.
+func (r *remoteRef) demonitor(e *Embeddable) {
+	n, err := r.node()
+	if err != nil {
+		return
+	}
+	n.lock.Lock()
+	for id, m := range n.monitors {
+		if m.e == e && m.ref == Ref(r) {
+			delete(n.monitors, id)
+			e.cancelOnExit(remoteMonitorKey{n.c, id})
+		}
+	}
+	n.lock.Unlock()
+}
+
+// Delivers the Exit for a remoteExit to the coroutine monitoring it.
+func (n *RemoteNode) exited(f remoteFrame) {
+	n.lock.Lock()
+	m, ok := n.monitors[f.Id]
+	delete(n.monitors, f.Id)
+	n.lock.Unlock()
+	if !ok {
+		return
+	}
+	m.e.cancelOnExit(remoteMonitorKey{n.c, f.Id})
+	exit := Exit{From: m.ref, Reason: f.Reason}
+	if f.Reason == ExitPanicked {
+		exit.Panic = f.Err
+	}
+	m.e.deliver(mail{v: exit, from: m.ref})
+}
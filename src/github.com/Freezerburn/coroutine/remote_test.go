@@ -0,0 +1,126 @@
+package coroutine
+
+import (
+	"testing"
+	"time"
+)
+
+func init() {
+	RegisterSpawnable("echo", func(c Coroutine) {
+		for {
+			v := c.Recv()
+			c.(Replier).Reply(v)
+		}
+	})
+}
+
+func listen(t *testing.T) (*Listener, string) {
+	l, err := Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, l.Addr().String()
+}
+
+// Waits up to a second for cond to become true.
+func eventually(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting: " + what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSpawnStartsRegisteredFunction(t *testing.T) {
+	l, addr := listen(t)
+	defer l.Close()
+	n, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	if _, err := n.Spawn("missing", "spawned missing"); err != ErrNotSpawnable {
+		t.Fatalf("expected ErrNotSpawnable for an unregistered function, got %v", err)
+	}
+
+	r, err := n.Spawn("echo", "spawned echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	if v, err := Call(r, "hello", time.Second); err != nil || v != "hello" {
+		t.Fatalf("expected the spawned coroutine to echo, got %v, %v", v, err)
+	}
+	if _, ok := Whereis("spawned echo"); !ok {
+		t.Fatal("expected the spawned coroutine to be registered under its name")
+	}
+}
+
+func TestRemoteSupervisorMovesToNextNode(t *testing.T) {
+	defer CloseRemotes()
+	first, firstAddr := listen(t)
+	second, secondAddr := listen(t)
+	defer second.Close()
+
+	s := SuperviseRemote("echo", "supervised echo", []string{firstAddr, secondAddr})
+	defer s.Stop()
+	eventually(t, "the coroutine to start on the first node", func() bool {
+		_, node := s.Child()
+		return node == firstAddr
+	})
+
+	// Both nodes are this process, so the coroutine carries on running and the second node finds it already there.
+	first.Close()
+	eventually(t, "the coroutine to move to the second node", func() bool {
+		_, node := s.Child()
+		return node == secondAddr
+	})
+	if n := s.Restarts(); n != 1 {
+		t.Errorf("expected 1 restart, got %d", n)
+	}
+	child, _ := s.Child()
+	if v, err := Call(child, "hello", time.Second); err != nil || v != "hello" {
+		t.Fatalf("expected the coroutine to answer through the second node, got %v, %v", v, err)
+	}
+}
+
+func TestRemoteSupervisorStopStopsChild(t *testing.T) {
+	defer CloseRemotes()
+	l, addr := listen(t)
+	defer l.Close()
+
+	s := SuperviseRemote("echo", "stopped echo", []string{addr})
+	eventually(t, "the coroutine to start", func() bool {
+		child, _ := s.Child()
+		return child != nil
+	})
+	s.Stop()
+	eventually(t, "the coroutine to stop", func() bool {
+		_, ok := Whereis("stopped echo")
+		return !ok && !s.Running()
+	})
+}
+
+func TestRemoteSupervisorFinishesWithChild(t *testing.T) {
+	defer CloseRemotes()
+	l, addr := listen(t)
+	defer l.Close()
+
+	s := SuperviseRemote("echo", "finished echo", []string{addr})
+	defer s.Stop()
+	eventually(t, "the coroutine to start", func() bool {
+		child, _ := s.Child()
+		return child != nil
+	})
+	r, _ := Whereis("finished echo")
+	r.Stop()
+	eventually(t, "the supervisor to finish", func() bool {
+		return !s.Running()
+	})
+	if n := s.Restarts(); n != 0 {
+		t.Errorf("expected a stopped coroutine not to be restarted, got %d restarts", n)
+	}
+}
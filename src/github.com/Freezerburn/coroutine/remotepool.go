@@ -0,0 +1,174 @@
+package coroutine
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned by LookupRemote when the other process has no running coroutine with the name.
+var ErrNotFound = errors.New("coroutine: no coroutine with that name")
+
+const (
+	// How long Dial, and reconnecting a Ref from LookupRemote, waits for the connection to be made.
+	remoteDialTimeout = 5 * time.Second
+	// How long LookupRemote and a remote Ref's Running wait for the other process to answer.
+	remoteQueryTimeout = 5 * time.Second
+	// How long a Ref from LookupRemote waits after failing to reconnect before it tries again, so that a node that's
+	// down doesn't have every Send to it wait for a dial to fail. Sends in the meantime fail straight away.
+	remoteRedialDelay = time.Second
+)
+
+// The connection shared by every Ref from LookupRemote to the same node, which is made again whenever one of them
+// finds it has closed.
+type remotePool struct {
+	network string
+	address string
+	lock    sync.Mutex
+	node    *RemoteNode
+	// When the last attempt to connect was made, and why it failed, if it did.
+	dialed  time.Time
+	dialErr error
+	// How many Clusters currently think the node is down, in which case nothing is sent to it.
+	down int
+}
+
+var (
+	remotePools     = make(map[string]*remotePool)
+	remotePoolsLock sync.Mutex
+)
+
+// The open connection to the node, connecting again if it has closed.
+func (p *remotePool) get() (*RemoteNode, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.down > 0 {
+		return nil, ErrNodeDown
+	}
+	if p.node != nil && p.node.isOpen() {
+		return p.node, nil
+	}
+	if p.dialErr != nil && time.Since(p.dialed) < remoteRedialDelay {
+		return nil, p.dialErr
+	}
+	p.dialed = time.Now()
+	p.node, p.dialErr = Dial(p.network, p.address)
+	return p.node, p.dialErr
+}
+
+// Like get, but connects however recently the last attempt failed, and whether or not the node is thought to be down,
+// which is how a Cluster finds out whether it's back. Connects without holding the lock, so that Sends to a node
+// that's down fail straight away rather than waiting for the dial to.
+func (p *remotePool) probe() (*RemoteNode, error) {
+	p.lock.Lock()
+	if p.node != nil && p.node.isOpen() {
+		n := p.node
+		p.lock.Unlock()
+		return n, nil
+	}
+	p.lock.Unlock()
+
+	n, err := Dial(p.network, p.address)
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.node != nil && p.node.isOpen() {
+		// Something else connected in the meantime.
+		n.Close()
+		return p.node, nil
+	}
+	p.node, p.dialed, p.dialErr = n, time.Now(), nil
+	return n, nil
+}
+
+// Records whether a Cluster thinks the node is down. The first to think so closes the connection, failing every Call
+// waiting on it with ErrNodeDown.
+func (p *remotePool) setDown(down bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !down {
+		p.down--
+		return
+	}
+	p.down++
+	if p.down == 1 && p.node != nil {
+		p.node.shutdown(ErrNodeDown)
+	}
+}
+
+// The pool for the node, creating it if this is the first time it has been asked for.
+func poolFor(node string) *remotePool {
+	network, address := parseNode(node)
+	key := network + "://" + address
+
+	remotePoolsLock.Lock()
+	defer remotePoolsLock.Unlock()
+	p := remotePools[key]
+	if p == nil {
+		p = &remotePool{network: network, address: address}
+		remotePools[key] = p
+	}
+	return p
+}
+
+// Splits a node given to LookupRemote into a network and address.
+func parseNode(node string) (network, address string) {
+	if i := strings.Index(node, "://"); i >= 0 {
+		return node[:i], node[i+3:]
+	}
+	return "tcp", node
+}
+
+// A Ref to the coroutine with the given name in the process listening at node, which is either "host:port" for TCP
+// or "network://address", such as "unix:///run/app.sock". Returns ErrNotFound if the other process has no coroutine
+// started with WithUniqueName running under that name, or the error from connecting to it.
+//
+// Every Ref to the same node shares one connection, made the first time one is looked up, which is made again as soon
+// as one of them is used after it has closed, so a Ref carries on working once a node that went away comes back.
+// While it's away, Sends go to the dead letter handler and Calls fail, with ErrNodeDown once a Cluster has noticed.
+// Otherwise the Ref behaves like one from RemoteNode.Ref, with Running and Stop reaching the coroutine in the other
+// process.
+func LookupRemote(node, name string) (Ref, error) {
+	r := &remoteRef{pool: poolFor(node), name: name}
+	running, err := r.running()
+	if err != nil {
+		return nil, err
+	}
+	if !running {
+		return nil, ErrNotFound
+	}
+	return r, nil
+}
+
+// Starts the function registered with RegisterSpawnable under the given name in the process listening at node,
+// written as for LookupRemote, as a coroutine called name. The Ref it returns shares the node's connection and
+// behaves like one from LookupRemote. As with RemoteNode.Spawn, a coroutine called name that is already running there
+// is returned rather than another being started.
+func SpawnRemote(node, function, name string) (Ref, error) {
+	r := &remoteRef{pool: poolFor(node), name: name}
+	if err := r.spawn(function); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Closes every connection made by LookupRemote and SpawnRemote. Refs from it reconnect if they're used again.
+func CloseRemotes() {
+	remotePoolsLock.Lock()
+	pools := make([]*remotePool, 0, len(remotePools))
+	for _, p := range remotePools {
+		pools = append(pools, p)
+	}
+	remotePoolsLock.Unlock()
+
+	for _, p := range pools {
+		p.lock.Lock()
+		if p.node != nil {
+			p.node.Close()
+		}
+		p.lock.Unlock()
+	}
+}
@@ -0,0 +1,141 @@
+package coroutine
+
+import (
+	"sync"
+)
+
+// Keeps a coroutine running in one of a list of other processes, created with SuperviseRemote. The coroutine is
+// started with SpawnRemote on the first node that will start it and monitored from a supervising coroutine in this
+// process. What happens when it finishes depends on why:
+//   - If it panicked, it's started again on the same node. A panic takes the process it happened in down with it
+//     unless something recovers it, in which case starting it there again fails and it moves on to the next node.
+//   - If the connection to its node was lost, or a Cluster found the node to be down, it's started again on the next
+//     node in the list, wrapping around to the first. The old one may still be running if its node only dropped off
+//     the network, so the function should be safe to run twice for a moment, or the old one should stop itself once
+//     it finds it can't be reached.
+//   - If it returned or was stopped, it's done, and so is the supervisor.
+//
+// A node that won't start it, because it can't be reached or has nothing registered under the function, is skipped
+// for the next one. Once every node has been tried, the supervisor waits remoteRedialDelay before going around them
+// again.
+type RemoteSupervisor struct {
+	function string
+	name     string
+	nodes    []string
+	ref      Ref
+
+	lock sync.Mutex
+	// The Ref of the coroutine being supervised and the node it's running on, or nil and "" while one is being
+	// started and once the supervisor is done.
+	child     Ref
+	childNode string
+	restarts  int
+}
+
+// Starts supervising a coroutine called name, running the function registered with RegisterSpawnable under function
+// in the processes listening at nodes, each written as for LookupRemote. The supervising coroutine in this process is
+// started with the given options. Panics if nodes is empty, since there would be nowhere to start it.
+func SuperviseRemote(function, name string, nodes []string, opts ...Option) *RemoteSupervisor {
+	if len(nodes) == 0 {
+		panic("coroutine: SuperviseRemote requires at least one node")
+	}
+	s := &RemoteSupervisor{function: function, name: name, nodes: append([]string(nil), nodes...)}
+	s.ref = StartFuncName("RemoteSupervisor "+name, s.run, opts...)
+	return s
+}
+
+func (s *RemoteSupervisor) run(c Coroutine) {
+	// Set while the coroutine is running, so that stopping the supervisor stops it along with it.
+	var child Ref
+	defer func() {
+		if child != nil {
+			child.Stop()
+		}
+		s.setChild(nil, "")
+	}()
+
+	i := 0
+	for {
+		child, i = s.start(c, i)
+		c.(Linker).Monitor(child)
+		var exit Exit
+		for {
+			// Nothing else is sent to the supervisor, since its Ref is never handed out.
+			if e, ok := c.Recv().(Exit); ok && e.From == child {
+				exit = e
+				break
+			}
+		}
+		child = nil
+
+		switch exit.Reason {
+		case ExitPanicked:
+			c.Logger().Warn("Supervised remote coroutine panicked, starting it again.", "name", s.name,
+				"node", s.nodes[i], "panic", exit.Panic)
+		case ExitDisconnected:
+			c.Logger().Warn("Lost the node of a supervised remote coroutine, starting it on the next.",
+				"name", s.name, "node", s.nodes[i])
+			i = (i + 1) % len(s.nodes)
+		default:
+			return
+		}
+		s.setChild(nil, "")
+		s.lock.Lock()
+		s.restarts++
+		s.lock.Unlock()
+	}
+}
+
+// Spawns the coroutine on the node at index i, or the first one after it that will start it, returning its Ref and
+// the index of the node it was started on.
+func (s *RemoteSupervisor) start(c Coroutine, i int) (Ref, int) {
+	for tried := 0; ; tried++ {
+		if tried > 0 && tried%len(s.nodes) == 0 {
+			c.Pause(remoteRedialDelay)
+		}
+		node := s.nodes[i]
+		r, err := SpawnRemote(node, s.function, s.name)
+		if err == nil {
+			s.setChild(r, node)
+			return r, i
+		}
+		c.Logger().Warn("Failed to start a supervised remote coroutine.", "name", s.name, "node", node,
+			"error", err)
+		i = (i + 1) % len(s.nodes)
+	}
+}
+
+func (s *RemoteSupervisor) setChild(r Ref, node string) {
+	s.lock.Lock()
+	s.child, s.childNode = r, node
+	s.lock.Unlock()
+}
+
+// The Ref of the coroutine being supervised, and the node it's running on. Returns nil and "" while it's being
+// started, and once the supervisor is done. A new Ref is used every time it's started again, so this should be asked
+// for again rather than kept.
+func (s *RemoteSupervisor) Child() (Ref, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.child, s.childNode
+}
+
+// How many times the coroutine has been started again after it panicked or its node was lost.
+func (s *RemoteSupervisor) Restarts() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.restarts
+}
+
+// Whether the supervisor is still looking after the coroutine. False once the coroutine has returned or been
+// stopped, or the supervisor itself has been stopped.
+func (s *RemoteSupervisor) Running() bool {
+	return s.ref.Running()
+}
+
+// Stops the supervisor, and the coroutine it supervises along with it.
+func (s *RemoteSupervisor) Stop() {
+	if s.ref.Running() {
+		s.ref.Stop()
+	}
+}
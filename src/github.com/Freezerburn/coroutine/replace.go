@@ -0,0 +1,104 @@
+package coroutine
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// Returned by Replace for a coroutine whose handler can't be replaced, or when the new handler isn't the right
+	// kind for it.
+	ErrNotReplaceable = errors.New("coroutine: handler can't be replaced")
+	// Returned by Replace when it's called from inside the coroutine being replaced, which can't wait for itself
+	// to finish the message it's handling.
+	ErrReplaceSelf = errors.New("coroutine: can't replace its own handler")
+)
+
+// Implemented by Refs whose coroutine's handler can be swapped while it runs, used by Replace.
+type Replaceable interface {
+	Replace(handler interface{}) error
+}
+
+// Swaps the handler of the coroutine behind r while it keeps running, as described on the Replace method of the Ref
+// of a coroutine started by this package. Returns ErrNotReplaceable if r isn't Replaceable.
+func Replace(r Ref, handler interface{}) error {
+	if rr, ok := refAs[Replaceable](r); ok {
+		return rr.Replace(handler)
+	}
+	return ErrNotReplaceable
+}
+
+// A handler passed to Replace, and where to send the result of swapping it in.
+type replacement struct {
+	handler interface{}
+	result  chan error
+}
+
+// Implemented by a Server that needs to take over from the one it's replacing in Replace, such as by copying
+// its state. Returning an error leaves the old Server in place.
+type Upgrader interface {
+	Upgrade(c Coroutine, old Server) error
+}
+
+// Swaps the handler of the coroutine this references while it keeps running, for live upgrades of long lived
+// coroutines. The swap is made by the coroutine itself in between messages, so nothing in its mailbox is lost, and the
+// message it's handling at the time finishes with the old handler. Waits for the swap to be made.
+//
+// A coroutine started with StartServer takes a new Server, which isn't given Init, but is given Upgrade if it's an
+// Upgrader. One started with StartBehavior takes a new Behavior, which replaces its current one. Anything else
+// returns ErrNotReplaceable, and a coroutine that stops before making the swap returns ErrNotRunning. Calling it from
+// inside the coroutine itself returns ErrReplaceSelf.
+func (r *embeddableRef) Replace(handler interface{}) error {
+	e := r.e
+	if atomic.LoadUint64(&e.goid) == currentGoroutineId() {
+		return ErrReplaceSelf
+	}
+	if !r.Running() {
+		return ErrNotRunning
+	}
+
+	result := make(chan error, 1)
+	e.system(func(e *Embeddable) {
+		x := e.ext.Load()
+		if x == nil || x.replacer == nil {
+			result <- ErrNotReplaceable
+			return
+		}
+		// System messages run at every checkpoint, including those in the middle of a handler, so the swap waits
+		// for the receive loop unless the coroutine is already there.
+		x.replacements = append(x.replacements, replacement{handler, result})
+		if x.betweenMessages {
+			e.replace()
+		}
+	})
+	select {
+	case err := <-result:
+		return err
+	case <-e.done:
+		return ErrNotRunning
+	}
+}
+
+// Receives the next message for the receive loop of StartServer or StartBehavior, swapping in any handlers passed to
+// Replace first, and while it waits.
+func (e *Embeddable) recvNext() interface{} {
+	e.replace()
+	x := e.extra()
+	x.betweenMessages = true
+	v := e.Recv()
+	x.betweenMessages = false
+	return v
+}
+
+// Swaps in every handler waiting to be, in the order they were passed to Replace.
+func (e *Embeddable) replace() {
+	x := e.ext.Load()
+	if x == nil {
+		return
+	}
+	for len(x.replacements) > 0 {
+		next := x.replacements[0]
+		x.replacements = x.replacements[1:]
+		next.result <- x.replacer(next.handler)
+	}
+}
@@ -0,0 +1,88 @@
+package coroutine
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A Server whose HandleCast runs a function, and that counts Upgrades into upgraded.
+type funcServer struct {
+	cast     func(c Coroutine, v interface{})
+	upgraded *int32
+}
+
+func (s *funcServer) Init(c Coroutine) error {
+	return nil
+}
+
+func (s *funcServer) HandleCall(c Coroutine, v interface{}) interface{} {
+	return v
+}
+
+func (s *funcServer) HandleCast(c Coroutine, v interface{}) {
+	s.cast(c, v)
+}
+
+func (s *funcServer) Terminate(c Coroutine, reason ExitReason) {
+}
+
+func (s *funcServer) Upgrade(c Coroutine, old Server) error {
+	atomic.AddInt32(s.upgraded, 1)
+	return nil
+}
+
+func TestReplaceSelf(t *testing.T) {
+	errs := make(chan error, 1)
+	r := StartBehavior(func(c Coroutine, v interface{}) {
+		errs <- Replace(v.(Ref), Behavior(func(c Coroutine, v interface{}) {}))
+	})
+	defer r.Stop()
+
+	r.Send(r)
+	select {
+	case err := <-errs:
+		if err != ErrReplaceSelf {
+			t.Fatalf("expected ErrReplaceSelf, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected replacing itself to return straight away")
+	}
+}
+
+func TestReplaceWaitsForHandlerToFinish(t *testing.T) {
+	var upgraded int32
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	midHandler := make(chan int32, 1)
+	r := StartServer(&funcServer{upgraded: &upgraded, cast: func(c Coroutine, v interface{}) {
+		close(started)
+		<-proceed
+		// A checkpoint, which is where the Replace's system message runs.
+		c.Now()
+		midHandler <- atomic.LoadInt32(&upgraded)
+	}})
+	defer r.Stop()
+
+	r.Send("slow")
+	<-started
+	replaced := make(chan error, 1)
+	go func() {
+		replaced <- Replace(r, &funcServer{upgraded: &upgraded, cast: func(c Coroutine, v interface{}) {}})
+	}()
+	e := r.(*embeddableRef).e
+	for atomic.LoadInt32(&e.sysPending) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(proceed)
+
+	if n := <-midHandler; n != 0 {
+		t.Fatal("expected the handler to finish with the old Server before the swap")
+	}
+	if err := <-replaced; err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&upgraded); n != 1 {
+		t.Fatalf("expected one Upgrade once the handler finished, got %d", n)
+	}
+}
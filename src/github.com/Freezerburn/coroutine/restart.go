@@ -0,0 +1,83 @@
+package coroutine
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Returned by Restart when it's called from inside the coroutine being restarted, which can't wait for itself to
+// finish.
+var ErrRestartSelf = errors.New("coroutine: can't restart itself")
+
+// Implemented by Refs whose coroutine can be started again, used by Restart.
+type Restartable interface {
+	Restart() error
+}
+
+// Stops the coroutine behind r, waits for it to finish and starts it again, as described on the Restart method of the
+// Ref of a coroutine started by this package. Returns ErrUnsupported if r isn't Restartable.
+func Restart(r Ref) error {
+	if rr, ok := refAs[Restartable](r); ok {
+		return rr.Restart()
+	}
+	return ErrUnsupported
+}
+
+// Implemented by a Starter that needs to put its own fields back the way they were before Restart runs Start
+// again. The Embeddable is reset by the library, so only the fields of the struct embedding it need resetting here.
+type Resetter interface {
+	ResetState()
+}
+
+// Stops the coroutine this references, if it's still running, waits for it to finish, then starts it again with the
+// same name, id, function or Starter, and options. The Ref carries on referring to it. Its timers and anything left in
+// its mailbox are thrown away, the leftover messages going to the dead letter handler along with anything sent while
+// it restarts, and links and monitors have to be set up again. Messages sent with SendAcked that weren't acknowledged
+// are the exception, and are delivered again once it has started, as are messages in a Mailbox given with WithMailbox,
+// which is left as it is. A Starter that's also a Resetter has ResetState called just before Start.
+//
+// A coroutine started with WithUniqueName may find another coroutine has taken its name while it was stopped, in which
+// case it isn't started again, and ErrNameTaken is returned.
+//
+// A coroutine started with WithPooling can't be restarted once it has finished and its Embeddable has been reused, and
+// returns ErrNotRunning.
+//
+// A coroutine run by a Scheduler only finishes while the scheduler is being stepped, so Restart waits for that.
+func (r *embeddableRef) Restart() error {
+	e := r.e
+	if r.stale() {
+		return ErrNotRunning
+	}
+	if atomic.LoadUint64(&e.goid) == currentGoroutineId() {
+		return ErrRestartSelf
+	}
+	e.restartLock.Lock()
+	defer e.restartLock.Unlock()
+
+	if e.isRunning() {
+		r.Stop()
+	}
+	<-e.done
+	e.goroutine.Wait()
+
+	for _, m := range e.drainMailbox() {
+		if d, ok := m.v.(Delivery); ok && e.isUnacked(d.Token) {
+			// Delivered again below.
+			continue
+		}
+		deadLetter(DeadLetter{Reason: DeadLetterStopped, Value: m.v, To: r, From: m.from})
+	}
+
+	if e.resetState != nil {
+		e.resetState()
+	}
+	atomic.AddUint64(&e.restarts, 1)
+	e.restarting = true
+	started := start(e.name, e, e.body, e.opts)
+	// Dead-lettered instead if it didn't start.
+	e.redeliver()
+	if started != r {
+		return ErrNameTaken
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package coroutine
+
+import (
+	"testing"
+)
+
+func TestRestartWhenNameTaken(t *testing.T) {
+	idle := func(c Coroutine) {
+		for {
+			c.Recv()
+		}
+	}
+	first := StartFuncName("restart-name-taken", idle, WithUniqueName())
+	first.Stop()
+	<-first.(*embeddableRef).e.done
+
+	second := StartFuncName("restart-name-taken", idle, WithUniqueName())
+	defer second.Stop()
+	if second == first {
+		t.Fatal("expected a new coroutine once the first had stopped")
+	}
+
+	if err := Restart(first); err != ErrNameTaken {
+		t.Fatalf("expected ErrNameTaken, got %v", err)
+	}
+	if first.Running() {
+		t.Fatal("expected the first coroutine to stay stopped")
+	}
+	if r, ok := Whereis("restart-name-taken"); !ok || r != second {
+		t.Fatal("expected the name to still belong to the second coroutine")
+	}
+}
+
+func TestRestartStartsWithEmptyStats(t *testing.T) {
+	handled := make(chan struct{})
+	r := StartFunc(func(c Coroutine) {
+		for {
+			c.Recv()
+			handled <- struct{}{}
+		}
+	})
+	defer r.Stop()
+	r.Send("first")
+	<-handled
+	if s := StatsOf(r); s.QueueTime.Count != 1 {
+		t.Fatalf("expected one message in the queue time histogram, got %d", s.QueueTime.Count)
+	}
+
+	if err := Restart(r); err != nil {
+		t.Fatal(err)
+	}
+	if s := StatsOf(r); s.QueueTime.Count != 0 || s.HandleTime.Count != 0 {
+		t.Fatalf("expected the histograms to start again empty, got %d and %d", s.QueueTime.Count, s.HandleTime.Count)
+	}
+	r.Send("second")
+	<-handled
+	if s := StatsOf(r); s.QueueTime.Count != 1 {
+		t.Fatalf("expected only the message since the restart, got %d", s.QueueTime.Count)
+	}
+}
@@ -0,0 +1,75 @@
+package coroutine
+
+import (
+	"math"
+	"time"
+)
+
+// How long to wait before the next attempt of a Retry. attempt is the number of attempts made so far, starting at 1.
+type Backoff func(attempt int) time.Duration
+
+// Waits d between every attempt.
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		return d
+	}
+}
+
+// Waits base before the second attempt, then twice as long before each one after that, up to max. A max <= 0 means
+// there is no limit.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d > 0; i++ {
+			if d > math.MaxInt64/2 {
+				d = math.MaxInt64
+				break
+			}
+			d *= 2
+			if max > 0 && d >= max {
+				break
+			}
+		}
+		if max > 0 && d > max {
+			return max
+		}
+		return d
+	}
+}
+
+// Implemented by Embeddable for coroutines that poll for a condition or retry something that fails, pausing in
+// between so that the rest of the coroutines keep running.
+type Waiter interface {
+	WaitFor(cond func() bool, pollInterval, timeout time.Duration) bool
+	Retry(attempts int, backoff Backoff, fn func() error) error
+}
+
+var _ Waiter = (*Embeddable)(nil)
+
+// Calls fn until it returns nil or it has been called attempts times, pausing for as long as backoff says in between.
+// Returns nil as soon as fn succeeds, or the error from the last attempt. An attempts < 1 is treated as 1, and a nil
+// backoff retries straight away.
+//
+// The waits between attempts are Pauses, so they use the coroutine's clock, and the coroutine can still be stopped
+// while it's waiting, unlike with time.Sleep.
+func (e *Embeddable) Retry(attempts int, backoff Backoff, fn func() error) error {
+	e.checkpoint()
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if !e.isRunning() {
+			panic(Stop{})
+		}
+		if err = fn(); err == nil || attempt >= attempts {
+			return err
+		}
+		if backoff != nil {
+			if d := backoff(attempt); d > 0 {
+				e.Pause(d)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package coroutine
+
+import (
+	"sync"
+)
+
+// The smallest a ringQueue's buffer gets. It never shrinks below this, so a coroutine that only ever has a few
+// messages waiting never allocates again once it has its first one.
+const minRingSize = 16
+
+// A mailQueue that keeps messages in a growable ring buffer, guarded by a lock. Putting a message in and taking one
+// out are amortized O(1) and allocate nothing once the buffer is big enough, unlike the default mpscQueue, which
+// allocates a node for every message. The buffer doubles when it fills up, and halves once it's no more than a
+// quarter full, so a burst of messages doesn't leave a long-lived coroutine holding on to a huge buffer afterwards.
+type ringQueue struct {
+	lock sync.Mutex
+	// Always nil or a power of two long, so indexes wrap with a mask.
+	buf []mail
+	// The index of the first message, and how many there are.
+	head int
+	n    int
+}
+
+func newRingQueue() *ringQueue {
+	return &ringQueue{}
+}
+
+func (q *ringQueue) put(m mail) error {
+	q.lock.Lock()
+	q.pushLocked(m)
+	q.lock.Unlock()
+	return nil
+}
+
+func (q *ringQueue) putAll(ms []mail) {
+	q.lock.Lock()
+	for _, m := range ms {
+		q.pushLocked(m)
+	}
+	q.lock.Unlock()
+}
+
+func (q *ringQueue) pushLocked(m mail) {
+	if q.n == len(q.buf) {
+		size := 2 * len(q.buf)
+		if size < minRingSize {
+			size = minRingSize
+		}
+		q.resizeLocked(size)
+	}
+	q.buf[(q.head+q.n)&(len(q.buf)-1)] = m
+	q.n++
+}
+
+func (q *ringQueue) take() (mail, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.n == 0 {
+		return mail{}, false
+	}
+	m := q.buf[q.head]
+	// Let go of the message, so the buffer doesn't keep it alive.
+	q.buf[q.head] = mail{}
+	q.head = (q.head + 1) & (len(q.buf) - 1)
+	q.n--
+	if len(q.buf) > minRingSize && q.n <= len(q.buf)/4 {
+		q.resizeLocked(len(q.buf) / 2)
+	}
+	return m, true
+}
+
+// Moves the messages into a new buffer of the given size, which must be a power of two that they fit in, with the
+// first of them at the start.
+func (q *ringQueue) resizeLocked(size int) {
+	buf := make([]mail, size)
+	if q.n > 0 {
+		if end := q.head + q.n; end <= len(q.buf) {
+			copy(buf, q.buf[q.head:end])
+		} else {
+			copied := copy(buf, q.buf[q.head:])
+			copy(buf[copied:], q.buf[:end-len(q.buf)])
+		}
+	}
+	q.buf = buf
+	q.head = 0
+}
+
+func (q *ringQueue) empty() bool {
+	return q.len() == 0
+}
+
+func (q *ringQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.n
+}
+
+func (q *ringQueue) each(f func(m mail)) {
+	q.lock.Lock()
+	ms := make([]mail, q.n)
+	for i := range ms {
+		ms[i] = q.buf[(q.head+i)&(len(q.buf)-1)]
+	}
+	q.lock.Unlock()
+	for _, m := range ms {
+		f(m)
+	}
+}
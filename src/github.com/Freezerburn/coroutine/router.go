@@ -0,0 +1,268 @@
+package coroutine
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Decides which worker a Router sends each message to. Called once for every Router with its number of workers,
+// returning the function the Router calls with each message to get the index of the worker to send it to. This lets
+// strategies keep state per Router, such as RoundRobin's position.
+type RoutingStrategy func(n int) func(v interface{}) int
+
+// Sends each message to the next worker in turn.
+func RoundRobin(n int) func(v interface{}) int {
+	var next uint64
+	return func(v interface{}) int {
+		return int((atomic.AddUint64(&next, 1) - 1) % uint64(n))
+	}
+}
+
+// How many points each worker gets on a ConsistentHash ring. More points spread keys more evenly.
+const hashRingPoints = 160
+
+// Sends every message with the same key, as returned by key, to the same worker, so that messages for one key are
+// always handled in the order they were sent while different keys are spread across the workers. Keys are placed on
+// a consistent hash ring, so the same key always maps to the same worker for a given number of workers, and routers
+// with different numbers of workers agree on where most keys go.
+func ConsistentHash(key func(v interface{}) string) RoutingStrategy {
+	return func(n int) func(v interface{}) int {
+		type point struct {
+			hash   uint32
+			worker int
+		}
+		ring := make([]point, 0, n*hashRingPoints)
+		for worker := 0; worker < n; worker++ {
+			for i := 0; i < hashRingPoints; i++ {
+				ring = append(ring, point{hashKey(strconv.Itoa(worker) + "#" + strconv.Itoa(i)), worker})
+			}
+		}
+		sort.Slice(ring, func(i, j int) bool {
+			return ring[i].hash < ring[j].hash
+		})
+
+		return func(v interface{}) int {
+			h := hashKey(key(v))
+			// The first point at or after the key's hash, wrapping around to the start of the ring.
+			i := sort.Search(len(ring), func(i int) bool {
+				return ring[i].hash >= h
+			})
+			if i == len(ring) {
+				i = 0
+			}
+			return ring[i].worker
+		}
+	}
+}
+
+// Hashes a key for the ring. FNV on its own leaves similar keys close together, so its result is mixed with the
+// MurmurHash3 finalizer to spread them around the ring.
+func hashKey(key string) uint32 {
+	f := fnv.New32a()
+	f.Write([]byte(key))
+	h := f.Sum32()
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// A Ref that spreads messages across a fixed number of identical worker coroutines, created with NewRouter. Workers
+// that finish are replaced with fresh ones straight away, until the Router is stopped, so the Router keeps working
+// even if a worker stops itself. A message routed to a worker in the moment between it finishing and being replaced
+// goes to dead letters, as with any other coroutine that has stopped.
+type Router struct {
+	lock    sync.Mutex
+	workers []Ref
+	pick    func(v interface{}) int
+	start   func() Ref
+	// The Clock the workers wait on, and so the one SendAfter and SendEvery wait on too.
+	clock    Clock
+	stopped  bool
+	stopping chan struct{}
+}
+
+// Starts n worker coroutines running f, with the given options, and returns a Router that sends messages to them as
+// decided by strategy. Panics if n isn't positive, since there would be nothing to route to.
+func NewRouter(n int, f Function, strategy RoutingStrategy, opts ...Option) *Router {
+	if n < 1 {
+		panic("coroutine: NewRouter requires at least one worker")
+	}
+	r := &Router{
+		workers:  make([]Ref, n),
+		pick:     strategy(n),
+		stopping: make(chan struct{}),
+		clock:    optionsClock(opts),
+		start: func() Ref {
+			return StartFunc(f, opts...)
+		},
+	}
+	for i := range r.workers {
+		r.startWorker(i)
+	}
+	return r
+}
+
+var _ Ref = (*Router)(nil)
+
+// Starts the worker at index i, arranging for it to be replaced once it finishes.
+func (r *Router) startWorker(i int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopped {
+		return
+	}
+	w := r.start()
+	r.workers[i] = w
+	if ref, ok := w.(*embeddableRef); ok {
+		registered := ref.onExit(r, func() {
+			go r.replace(i, w)
+		})
+		if !registered {
+			go r.replace(i, w)
+		}
+	}
+}
+
+// Replaces a worker that has finished, unless it has already been replaced or the Router was stopped.
+func (r *Router) replace(i int, old Ref) {
+	r.lock.Lock()
+	current := r.workers[i] == old
+	r.lock.Unlock()
+	if current {
+		r.startWorker(i)
+	}
+}
+
+// The worker coroutines at the moment, in the order the strategy indexes them. Any of them may be replaced at any
+// time after this returns.
+func (r *Router) Workers() []Ref {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Ref(nil), r.workers...)
+}
+
+func (r *Router) worker(v interface{}) Ref {
+	i := r.pick(v)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.workers[i]
+}
+
+// Sends v to the worker chosen by the strategy.
+func (r *Router) Send(v interface{}) {
+	r.worker(v).Send(v)
+}
+
+func (r *Router) SendContext(ctx context.Context, v interface{}) {
+	SendContext(ctx, r.worker(v), v)
+}
+
+// The worker is chosen once d has passed rather than straight away, so a worker replaced in the meantime doesn't
+// lose the message.
+func (r *Router) SendAfter(v interface{}, d time.Duration) CancelFunc {
+	return afterFunc(r.clock, d, r.stopping, func() {
+		if r.Running() {
+			r.Send(v)
+		}
+	})
+}
+
+// Each send is routed separately, so with RoundRobin they're spread across the workers.
+func (r *Router) SendEvery(v interface{}, interval time.Duration) CancelFunc {
+	return everyFunc(r.clock, interval, r.stopping, func() bool {
+		r.Send(v)
+		return true
+	})
+}
+
+func (r *Router) refClock() Clock {
+	return r.clock
+}
+
+func (r *Router) Call(v interface{}, timeout time.Duration) (interface{}, error) {
+	return Call(r.worker(v), v, timeout)
+}
+
+func (r *Router) Ask(v interface{}) *Future {
+	return Ask(r.worker(v), v)
+}
+
+// Whether the Router is still running. This is true until Stop is called, even while a worker is being replaced.
+func (r *Router) Running() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return !r.stopped
+}
+
+func (r *Router) Name() string {
+	return "Router"
+}
+
+// Always zero, since a Router isn't a coroutine itself.
+func (r *Router) Id() uint64 {
+	return 0
+}
+
+// The Stats of every worker added together, in the same way as Multicast.
+func (r *Router) Stats() Stats {
+	return StatsOf(Multicast(r.Workers()...))
+}
+
+// Pings every worker, in the same way as Multicast.
+func (r *Router) Ping(timeout time.Duration) Health {
+	return Ping(Multicast(r.Workers()...), timeout)
+}
+
+// Replaces the handler of every current worker. A replacement worker started afterwards runs the original Function
+// again, so this only makes sense for workers that can't finish; for Function workers it returns ErrNotReplaceable.
+func (r *Router) Replace(handler interface{}) error {
+	return Replace(Multicast(r.Workers()...), handler)
+}
+
+// Suspends every current worker. A replacement worker started afterwards isn't suspended.
+func (r *Router) Suspend() {
+	Suspend(Multicast(r.Workers()...))
+}
+
+// Resumes every current worker.
+func (r *Router) Resume() {
+	Resume(Multicast(r.Workers()...))
+}
+
+// Restarts every current worker by stopping it, leaving the Router to start a replacement as it does for any worker
+// that finishes.
+func (r *Router) Restart() error {
+	for _, w := range r.Workers() {
+		if w.Running() {
+			w.Stop()
+		}
+	}
+	return nil
+}
+
+// Stops every worker, without replacing them.
+func (r *Router) Stop() {
+	r.lock.Lock()
+	if r.stopped {
+		r.lock.Unlock()
+		return
+	}
+	r.stopped = true
+	close(r.stopping)
+	workers := append([]Ref(nil), r.workers...)
+	r.lock.Unlock()
+
+	for _, w := range workers {
+		if w.Running() {
+			w.Stop()
+		}
+	}
+}
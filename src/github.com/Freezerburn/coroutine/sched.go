@@ -0,0 +1,310 @@
+package coroutine
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Runs coroutines one at a time, and only when told to, instead of letting the Go runtime run them whenever it likes.
+// Coroutines are started under a scheduler by passing WithScheduler to one of the Start functions. Each call to Step
+// runs the next ready coroutine until it next waits on something (Recv with an empty mailbox, Pause, RecvFor...) or
+// finishes. Since only one coroutine runs at a time, and they're always run in the same order, the same sequence of
+// calls always produces the same result.
+//
+// Time for scheduled coroutines is virtual: it only moves when Advance is called, so Pause and RecvFor never sleep
+// for real. Messages from After, Tick, SendAfter and SendEvery are delivered during the Advance that reaches them, so
+// the RunUntilIdle after it handles them.
+//
+// A scheduled coroutine that blocks on anything outside of this package, such as a channel or I/O, will block Step
+// until it's done. Step must not be called from one of the scheduler's own coroutines.
+type Scheduler struct {
+	lock    sync.Mutex
+	clock   *FakeClock
+	ready   []*Embeddable
+	members map[*Embeddable]struct{}
+	// Signalled by the coroutine being stepped whenever it gives control back to the scheduler.
+	yielded chan struct{}
+	// Held for the duration of a Step, so only one coroutine is ever running.
+	stepLock sync.Mutex
+	// Coroutines that called WaitNextTick, in the order they did, which Driver.Tick makes ready.
+	nextTick []*Embeddable
+}
+
+// Creates a Scheduler whose virtual time starts at the given time.
+func NewScheduler(now time.Time) *Scheduler {
+	return &Scheduler{
+		clock:   NewFakeClock(now),
+		members: make(map[*Embeddable]struct{}),
+		yielded: make(chan struct{}),
+	}
+}
+
+// The scheduler's current virtual time.
+func (s *Scheduler) Now() time.Time {
+	return s.clock.Now()
+}
+
+// How many times a ready coroutine has to be passed over for one of higher priority before it counts as one level
+// higher itself.
+const priorityAging = 8
+
+// Gives a coroutine run by a Scheduler or Driver a priority, so that it runs before every ready coroutine of a lower
+// priority, such as input handling ahead of streaming in the background. The default is 0, and higher runs first.
+// Those of equal priority take turns in the order they became ready.
+//
+// So that a busy coroutine of high priority can't keep one of low priority from ever running, a coroutine that's
+// ready but passed over gains a level for every 8 steps it waits, until it runs. Priorities are best kept small, since
+// a difference of n levels can hold a coroutine back for 8n steps. Has no effect on a coroutine that isn't scheduled.
+func WithPriority(priority int) Option {
+	return func(e *Embeddable) {
+		e.priority = priority
+	}
+}
+
+// Runs the next ready coroutine until it waits on something or finishes: the one of the highest priority, counting
+// how long each has been waiting, that became ready first. Returns false without doing anything if no coroutine is
+// ready.
+func (s *Scheduler) Step() bool {
+	s.stepLock.Lock()
+	defer s.stepLock.Unlock()
+
+	s.lock.Lock()
+	if len(s.ready) == 0 {
+		s.lock.Unlock()
+		return false
+	}
+	next := 0
+	for i, e := range s.ready {
+		if e.schedScore() > s.ready[next].schedScore() {
+			next = i
+		}
+	}
+	e := s.ready[next]
+	s.ready = append(s.ready[:next], s.ready[next+1:]...)
+	for _, passed := range s.ready {
+		passed.schedPassed++
+	}
+	s.lock.Unlock()
+
+	e.schedResume <- struct{}{}
+	<-s.yielded
+	return true
+}
+
+// Keeps calling Step until no coroutine is ready, and returns how many steps were run. Coroutines that never wait on
+// anything, or that keep pausing for zero time, will cause this to run forever.
+func (s *Scheduler) RunUntilIdle() int {
+	steps := 0
+	for s.Step() {
+		steps++
+	}
+	return steps
+}
+
+// Moves virtual time forward by d. Coroutines whose Pause or RecvFor ends within that time become ready, in the
+// order their waits end, but they don't run until the next Step or RunUntilIdle.
+func (s *Scheduler) Advance(d time.Duration) {
+	s.clock.Advance(d)
+	now := s.clock.Now()
+
+	s.lock.Lock()
+	var due []*Embeddable
+	for e := range s.members {
+		if e.schedBlocked && !e.schedUntil.IsZero() && !e.schedUntil.After(now) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].schedUntil.Equal(due[j].schedUntil) {
+			return due[i].id < due[j].id
+		}
+		return due[i].schedUntil.Before(due[j].schedUntil)
+	})
+	for _, e := range due {
+		s.makeReady(e)
+	}
+	s.lock.Unlock()
+}
+
+// Whether no coroutine is ready to run. Coroutines may still be waiting on messages or on time passing.
+func (s *Scheduler) Idle() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.ready) == 0
+}
+
+// The number of coroutines started under this scheduler that haven't finished yet.
+func (s *Scheduler) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.members)
+}
+
+// A copy of everything currently sitting in the mailbox of a coroutine, in the order it will be received. Only works
+// for coroutines started by this package, and is only guaranteed to be stable when no step is running.
+func (s *Scheduler) Mailbox(r Ref) []interface{} {
+	er, ok := localRef(r)
+	if !ok {
+		return nil
+	}
+	msgs := make([]interface{}, 0, er.e.mailbox.len())
+	er.e.mailbox.each(func(m mail) {
+		msgs = append(msgs, m.v)
+	})
+	return msgs
+}
+
+// Stops every coroutine under this scheduler and runs them until they have all finished.
+func (s *Scheduler) StopAll() {
+	s.lock.Lock()
+	var all []*Embeddable
+	for e := range s.members {
+		all = append(all, e)
+	}
+	s.lock.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].id < all[j].id
+	})
+	for _, e := range all {
+		if e.isRunning() {
+			e.ref().Stop()
+		}
+	}
+	s.RunUntilIdle()
+}
+
+// The coroutine's priority, raised by how long it has been ready. Must be called with the lock held.
+func (e *Embeddable) schedScore() int {
+	return e.priority*priorityAging + e.schedPassed
+}
+
+// Must be called with the lock held.
+func (s *Scheduler) makeReady(e *Embeddable) {
+	e.schedBlocked = false
+	e.schedSuspended = false
+	e.schedTick = false
+	e.schedUntil = time.Time{}
+	e.schedPassed = 0
+	s.ready = append(s.ready, e)
+}
+
+// Registers a coroutine that is about to be started. It becomes ready straight away, so its first step runs it until
+// it first waits on something.
+func (s *Scheduler) add(e *Embeddable) {
+	e.schedResume = make(chan struct{})
+	s.lock.Lock()
+	s.members[e] = struct{}{}
+	e.schedPassed = 0
+	s.ready = append(s.ready, e)
+	s.lock.Unlock()
+}
+
+// Called from the coroutine's goroutine before it runs anything, to wait for its first step.
+func (s *Scheduler) wait(e *Embeddable) {
+	<-e.schedResume
+}
+
+// Called from the coroutine's goroutine to give control back to the scheduler until what it's waiting for happens:
+// a message if recv is true, d passing if d >= 0, and otherwise only being woken to stop or handle system messages.
+func (s *Scheduler) block(e *Embeddable, recv bool, d time.Duration) {
+	s.lock.Lock()
+	if !e.isRunning() || atomic.LoadInt32(&e.sysPending) != 0 {
+		s.lock.Unlock()
+		return
+	}
+	if recv {
+		if !e.mailbox.empty() {
+			s.lock.Unlock()
+			return
+		}
+	}
+
+	e.schedBlocked = true
+	e.schedRecv = recv
+	if d >= 0 {
+		e.schedUntil = s.clock.Now().Add(d)
+		if d == 0 {
+			// Nothing to wait for, but still let everything else that's ready run first.
+			s.makeReady(e)
+		}
+	}
+	s.lock.Unlock()
+
+	s.yielded <- struct{}{}
+	<-e.schedResume
+}
+
+// Called from the coroutine's goroutine to give control back to the scheduler until the next call to tick. Returns
+// false if it was woken, or never blocked, for some other reason, such as being stopped.
+func (s *Scheduler) blockUntilTick(e *Embeddable) bool {
+	s.lock.Lock()
+	if !e.isRunning() || atomic.LoadInt32(&e.sysPending) != 0 {
+		s.lock.Unlock()
+		return false
+	}
+	e.schedBlocked = true
+	e.schedRecv = false
+	e.schedTick = true
+	e.schedTicked = false
+	s.nextTick = append(s.nextTick, e)
+	s.lock.Unlock()
+
+	s.yielded <- struct{}{}
+	<-e.schedResume
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return e.schedTicked
+}
+
+// Makes every coroutine waiting in WaitNextTick ready. Those that were woken some other way meanwhile, such as by
+// being stopped, are left alone.
+func (s *Scheduler) tick() {
+	s.lock.Lock()
+	for _, e := range s.nextTick {
+		if e.schedTick {
+			e.schedTicked = true
+			s.makeReady(e)
+		}
+	}
+	s.nextTick = nil
+	s.lock.Unlock()
+}
+
+// Called from the coroutine's goroutine to give control back to the scheduler for as long as it's suspended.
+func (s *Scheduler) suspend(e *Embeddable) {
+	s.lock.Lock()
+	if !e.isRunning() || !e.isSuspended() || atomic.LoadInt32(&e.sysPending) != 0 {
+		s.lock.Unlock()
+		return
+	}
+	e.schedBlocked = true
+	e.schedRecv = false
+	e.schedSuspended = true
+	s.lock.Unlock()
+
+	s.yielded <- struct{}{}
+	<-e.schedResume
+}
+
+// Called when a message is sent to the coroutine, or it is stopped or resumed.
+func (s *Scheduler) wake(e *Embeddable) {
+	s.lock.Lock()
+	if e.schedBlocked && (e.schedRecv || !e.isRunning() || atomic.LoadInt32(&e.sysPending) != 0 ||
+		(e.schedSuspended && !e.isSuspended())) {
+		s.makeReady(e)
+	}
+	s.lock.Unlock()
+}
+
+// Called from the coroutine's goroutine once it has finished running.
+func (s *Scheduler) exited(e *Embeddable) {
+	s.lock.Lock()
+	delete(s.members, e)
+	s.lock.Unlock()
+
+	s.yielded <- struct{}{}
+}
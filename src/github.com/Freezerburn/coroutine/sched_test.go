@@ -0,0 +1,33 @@
+package coroutine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerDeliversTimersWithinAdvance(t *testing.T) {
+	s := NewScheduler(time.Unix(0, 0))
+	defer s.StopAll()
+
+	var received []interface{}
+	StartFunc(func(c Coroutine) {
+		c.After(time.Second, "after")
+		c.Tick(time.Minute, "tick")
+		for {
+			received = append(received, c.Recv())
+		}
+	}, WithScheduler(s))
+	s.RunUntilIdle()
+
+	s.Advance(time.Second)
+	s.RunUntilIdle()
+	if len(received) != 1 || received[0] != "after" {
+		t.Fatalf("expected the delayed message within the same RunUntilIdle, got %v", received)
+	}
+
+	s.Advance(3 * time.Minute)
+	s.RunUntilIdle()
+	if len(received) != 4 {
+		t.Fatalf("expected a tick for every minute the clock moved, got %v", received)
+	}
+}
@@ -0,0 +1,230 @@
+package coroutine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Identifies a single entry added to a Schedule, so it can be removed later.
+type EntryId uint64
+
+// A single upcoming fire of a Schedule entry, as reported by Schedule.Upcoming.
+type Fire struct {
+	Entry EntryId
+	At    time.Time
+}
+
+// Sends messages to coroutines, or starts new coroutines, at the times decided by a Calendar. The schedule is itself
+// run by a coroutine which sleeps until the next entry is due, so entries fire from that coroutine and never block
+// the code that adds them.
+type Schedule struct {
+	ref Ref
+	// The clock of the schedule's coroutine, which every entry's calendar is followed by.
+	clock   Clock
+	lock    sync.Mutex
+	entries map[EntryId]*scheduleEntry
+	nextId  EntryId
+	paused  bool
+}
+
+type scheduleEntry struct {
+	id     EntryId
+	cal    Calendar
+	next   time.Time
+	action func(id EntryId)
+}
+
+// Sent to the schedule's coroutine whenever something changes that could affect when it next needs to wake up.
+type scheduleChanged struct{}
+
+// Creates a new Schedule and starts the coroutine that runs it, with the given options. Entries fire by that
+// coroutine's clock, so a schedule started with WithClock and a FakeClock only fires as the fake clock is advanced.
+// Call Stop once the schedule is no longer needed.
+func NewSchedule(opts ...Option) *Schedule {
+	s := &Schedule{
+		entries: make(map[EntryId]*scheduleEntry),
+		nextId:  1,
+	}
+	s.ref = StartFuncName("Schedule", s.run, opts...)
+	s.clock = s.ref.(*embeddableRef).e.clock
+	return s
+}
+
+// Sends v to the referenced coroutine every time the calendar fires. Entries for coroutines that have stopped are
+// removed automatically the next time they would fire.
+func (s *Schedule) Send(cal Calendar, r Ref, v interface{}) EntryId {
+	return s.add(cal, func(id EntryId) {
+		if !r.Running() {
+			s.Remove(id)
+			return
+		}
+		r.Send(v)
+	})
+}
+
+// The same as Send, but with the calendar given as a cron expression understood by ParseCron.
+func (s *Schedule) SendCron(spec string, r Ref, v interface{}) (EntryId, error) {
+	cal, err := ParseCron(spec)
+	if err != nil {
+		return 0, err
+	}
+	return s.Send(cal, r, v), nil
+}
+
+// Starts a new coroutine with the given name running f every time the calendar fires.
+func (s *Schedule) StartFunc(cal Calendar, name string, f Function) EntryId {
+	return s.add(cal, func(EntryId) {
+		StartFuncName(name, f)
+	})
+}
+
+// The same as StartFunc, but with the calendar given as a cron expression understood by ParseCron.
+func (s *Schedule) StartFuncCron(spec string, name string, f Function) (EntryId, error) {
+	cal, err := ParseCron(spec)
+	if err != nil {
+		return 0, err
+	}
+	return s.StartFunc(cal, name, f), nil
+}
+
+// Adds an entry that calls action with its own id every time it fires.
+func (s *Schedule) add(cal Calendar, action func(id EntryId)) EntryId {
+	s.lock.Lock()
+	entry := &scheduleEntry{
+		id:     s.nextId,
+		cal:    cal,
+		next:   cal.Next(s.clock.Now()),
+		action: action,
+	}
+	s.nextId++
+	s.entries[entry.id] = entry
+	s.lock.Unlock()
+
+	s.ref.Send(scheduleChanged{})
+	return entry.id
+}
+
+// Removes an entry so that it will not fire again. Does nothing if the entry has already been removed.
+func (s *Schedule) Remove(id EntryId) {
+	s.lock.Lock()
+	delete(s.entries, id)
+	s.lock.Unlock()
+}
+
+// Stops all entries from firing until Resume is called. Any fires that would have happened while paused are skipped.
+func (s *Schedule) Pause() {
+	s.lock.Lock()
+	s.paused = true
+	s.lock.Unlock()
+}
+
+// Lets entries fire again after a call to Pause. Every entry is rescheduled from the current time.
+func (s *Schedule) Resume() {
+	s.lock.Lock()
+	if s.paused {
+		s.paused = false
+		now := s.clock.Now()
+		for _, entry := range s.entries {
+			entry.next = entry.cal.Next(now)
+		}
+	}
+	s.lock.Unlock()
+
+	s.ref.Send(scheduleChanged{})
+}
+
+// Whether the schedule is currently paused.
+func (s *Schedule) Paused() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.paused
+}
+
+// Lists up to n of the next fires across all entries, in the order they will happen. Fires are listed even while the
+// schedule is paused, since they show what will happen once it is resumed.
+func (s *Schedule) Upcoming(n int) []Fire {
+	s.lock.Lock()
+	var fires []Fire
+	for _, entry := range s.entries {
+		at := entry.next
+		for i := 0; i < n && !at.IsZero(); i++ {
+			fires = append(fires, Fire{Entry: entry.id, At: at})
+			at = entry.cal.Next(at)
+		}
+	}
+	s.lock.Unlock()
+
+	sort.Slice(fires, func(i, j int) bool {
+		if fires[i].At.Equal(fires[j].At) {
+			return fires[i].Entry < fires[j].Entry
+		}
+		return fires[i].At.Before(fires[j].At)
+	})
+	if len(fires) > n {
+		fires = fires[:n]
+	}
+	return fires
+}
+
+// Stops the schedule's coroutine. No entries will fire after this.
+func (s *Schedule) Stop() {
+	s.ref.Stop()
+}
+
+func (s *Schedule) run(c Coroutine) {
+	for {
+		var wait time.Duration
+		idle := true
+
+		s.lock.Lock()
+		if !s.paused {
+			now := c.Now()
+			var due []*scheduleEntry
+			for _, entry := range s.entries {
+				if entry.next.IsZero() {
+					continue
+				}
+				if !entry.next.After(now) {
+					due = append(due, entry)
+					continue
+				}
+				if d := entry.next.Sub(now); idle || d < wait {
+					wait = d
+					idle = false
+				}
+			}
+
+			if len(due) > 0 {
+				for _, entry := range due {
+					entry.next = entry.cal.Next(entry.next)
+					// Don't try to catch up on fires that were missed entirely, such as when the process was
+					// suspended. Skip to the next one in the future instead.
+					for !entry.next.IsZero() && !entry.next.After(now) {
+						entry.next = entry.cal.Next(now)
+					}
+					if entry.next.IsZero() {
+						delete(s.entries, entry.id)
+					}
+				}
+				s.lock.Unlock()
+
+				sort.Slice(due, func(i, j int) bool {
+					return due[i].id < due[j].id
+				})
+				for _, entry := range due {
+					entry.action(entry.id)
+				}
+				continue
+			}
+		}
+		s.lock.Unlock()
+
+		// Any message means the entries changed, so go back around and work out how long to wait for again.
+		if idle {
+			c.Recv()
+		} else {
+			c.RecvFor(wait)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package coroutine
+
+import (
+	"context"
+)
+
+// Sends v to r and waits until the coroutine has received it with one of the Recv functions, for senders that must
+// not run ahead of the coroutine, such as one handing over a buffer it's about to reuse. Returns the context's error
+// if it's done first, or ErrNotRunning if the coroutine stops before receiving v. Either way v may still be received
+// later, or may already have been.
+//
+// Anything that isn't a coroutine started by this package is sent v with its own SendContext, without waiting.
+func SendSync(ctx context.Context, r Ref, v interface{}) error {
+	ref, ok := r.(*embeddableRef)
+	if !ok {
+		SendContext(ctx, r, v)
+		return nil
+	}
+	if ref.stale() {
+		ref.SendContext(ctx, v)
+		return ErrNotRunning
+	}
+
+	e := ref.e
+	done := e.done
+	if !e.isRunning() {
+		ref.SendContext(ctx, v)
+		return ErrNotRunning
+	}
+	m := mail{v: v, ctx: ctx, received: make(chan struct{})}
+	if h := currentSpanHooks(); h != nil {
+		m.ctx = h.StartSend(ctx, r, v)
+	}
+	e.deliver(m)
+	select {
+	case <-m.received:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return ErrNotRunning
+	}
+}
@@ -0,0 +1,68 @@
+//go:build go1.23
+
+package coroutine
+
+import (
+	"iter"
+)
+
+// The remaining values of the generator as an iter.Seq, for ranging over with a for loop. Breaking out of the loop
+// stops the generator, the same as Stop. Like the Iterator itself, it can only be ranged over once.
+func (it *Iterator[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		// Also covers the body of the loop panicking, which would otherwise leave the generator waiting forever.
+		defer it.Stop()
+		for {
+			v, ok := it.Next()
+			if !ok || !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Makes f into an iter.Seq that runs it as a Generator, so a coroutine that yields values can be used anywhere the
+// standard library and other packages take an iterator. Every range over it starts f again from the beginning, as a
+// coroutine of its own, started with the given options. Breaking out of the loop stops the coroutine.
+func Seq[T any](f func(yield func(T)), opts ...Option) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		Generator(f, opts...).All()(yield)
+	}
+}
+
+// The same as Seq, for an f that yields pairs, such as a key and its value or a value and an error.
+func Seq2[K, V any](f func(yield func(K, V)), opts ...Option) iter.Seq2[K, V] {
+	type pair struct {
+		k K
+		v V
+	}
+	return func(yield func(K, V) bool) {
+		it := Generator(func(yield func(pair)) {
+			f(func(k K, v V) {
+				yield(pair{k: k, v: v})
+			})
+		}, opts...)
+		for p := range it.All() {
+			if !yield(p.k, p.v) {
+				return
+			}
+		}
+	}
+}
+
+// Starts a coroutine that ranges over seq and sends each value to out, the way FromReader does for a reader, so that
+// any iterator can feed a pipeline or a pool. The coroutine returns once seq runs out. Stopping it breaks out of the
+// loop, so seq gets to clean up after itself. Monitor the coroutine to find out when everything has been sent to out.
+func FromSeq[T any](seq iter.Seq[T], out Ref, opts ...Option) Ref {
+	return StartFuncName("FromSeq", func(c Coroutine) {
+		e := c.(*Embeddable)
+		for v := range seq {
+			// Breaking rather than stopping partway through the loop lets seq's own deferred functions run normally.
+			e.checkpoint()
+			if !e.isRunning() {
+				break
+			}
+			out.Send(v)
+		}
+	}, opts...)
+}
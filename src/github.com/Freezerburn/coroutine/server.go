@@ -0,0 +1,89 @@
+package coroutine
+
+// A coroutine written as a set of callbacks, for StartServer to run. The library owns the receive loop: it calls
+// HandleCall for every message sent with Call or Ask and replies with whatever it returns, and calls HandleCast for
+// every other message. Servers that also implement InfoHandler have Exit messages, from links and monitors, handed to
+// HandleInfo instead of HandleCast.
+//
+// Every callback runs inside the coroutine, so it can use c for everything a Function can, including stopping the
+// server with c.Stop.
+type Server interface {
+	// Called once, before any messages are handled. Returning an error stops the server before it handles anything,
+	// without calling Terminate.
+	Init(c Coroutine) error
+	// Handles a message sent with Call or Ask. What it returns is sent back as the reply.
+	HandleCall(c Coroutine, v interface{}) interface{}
+	// Handles any other message.
+	HandleCast(c Coroutine, v interface{})
+	// Called once the server stops, however it stops, as long as Init succeeded. The coroutine is no longer running
+	// by then, so this is only for cleaning up: calling any of c's methods that wait or receive stops it straight
+	// away.
+	Terminate(c Coroutine, reason ExitReason)
+}
+
+// Implemented by Servers that want Exit messages kept apart from the messages they're sent by other code.
+type InfoHandler interface {
+	HandleInfo(c Coroutine, exit Exit)
+}
+
+func StartServer(s Server, opts ...Option) Ref {
+	return StartServerName(defaultName, s, opts...)
+}
+
+// Starts a coroutine with the given name that runs s. See Server for how messages are dispatched.
+func StartServerName(name string, s Server, opts ...Option) Ref {
+	return StartFuncName(name, func(c Coroutine) {
+		serve(c.(*Embeddable), s)
+	}, opts...)
+}
+
+func serve(e *Embeddable, s Server) {
+	// Set before anything else, so that a Replace during Init waits for the first message rather than failing.
+	info, _ := s.(InfoHandler)
+	e.extra().replacer = func(handler interface{}) error {
+		next, ok := handler.(Server)
+		if !ok {
+			return ErrNotReplaceable
+		}
+		if u, ok := next.(Upgrader); ok {
+			if err := u.Upgrade(e, s); err != nil {
+				return err
+			}
+		}
+		s = next
+		info, _ = s.(InfoHandler)
+		return nil
+	}
+	if err := s.Init(e); err != nil {
+		e.logError("Server failed to start.", "error", err)
+		return
+	}
+
+	reason := ExitReturned
+	defer func() {
+		r := recover()
+		if _, stopped := r.(Stop); stopped {
+			reason = ExitStopped
+		} else if r != nil {
+			reason = ExitPanicked
+		}
+		e.setRunning(false)
+		s.Terminate(e, reason)
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	for {
+		v := e.recvNext()
+		if e.call != 0 {
+			e.Reply(s.HandleCall(e, v))
+			continue
+		}
+		if exit, ok := v.(Exit); ok && info != nil {
+			info.HandleInfo(e, exit)
+			continue
+		}
+		s.HandleCast(e, v)
+	}
+}
@@ -0,0 +1,265 @@
+package coroutine
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Creates the Function run by the entity coroutine for a key, the first time a ShardedSet needs one.
+type EntityFactory func(key string) Function
+
+// One coroutine per key, each started the first time something is sent to its key, for the actor per user, session
+// or device pattern. All messages for a key go to the same coroutine, so they're handled one at a time, in order,
+// without any locking in the entity itself. Entities that finish are forgotten, and the next message for their key
+// starts a new one. Safe for concurrent use.
+type ShardedSet struct {
+	name    string
+	factory EntityFactory
+	opts    []Option
+
+	lock     sync.Mutex
+	entities map[string]Ref
+	stopped  bool
+	// Signalled whenever a passivation finishes.
+	cond *sync.Cond
+	// Keys whose entities are being started, outside the lock, and keys whose entities are being passivated, along
+	// with the snapshots of passivated entities waiting to be restored.
+	starting    map[string]struct{}
+	passivating map[string]struct{}
+	snapshots   map[string]interface{}
+	// Closed to stop the goroutine looking for idle entities, if SetPassivation started one.
+	sweepStop chan struct{}
+}
+
+// Sent with Call to an entity that a ShardedSet is passivating. The entity should Reply with a snapshot of its state,
+// which can be anything, then return.
+type Passivate struct{}
+
+// The first message received by an entity started for a key whose previous entity was passivated, carrying whatever
+// that entity replied to Passivate with.
+type Restore struct {
+	Snapshot interface{}
+}
+
+// Identifies an entity when it's registered to be forgotten once its coroutine finishes.
+type shardedEntity struct {
+	set *ShardedSet
+	key string
+}
+
+// Creates an empty ShardedSet whose entities are started with factory and the given options. Each entity's coroutine
+// is named after the set and its key.
+func NewShardedSet(name string, factory EntityFactory, opts ...Option) *ShardedSet {
+	s := &ShardedSet{
+		name:        name,
+		factory:     factory,
+		opts:        opts,
+		entities:    make(map[string]Ref),
+		starting:    make(map[string]struct{}),
+		passivating: make(map[string]struct{}),
+		snapshots:   make(map[string]interface{}),
+	}
+	s.cond = sync.NewCond(&s.lock)
+	return s
+}
+
+// Sends v to the entity for key, starting it first if it isn't running. Does nothing once the set has been stopped.
+func (s *ShardedSet) SendToEntity(key string, v interface{}) {
+	if r := s.Entity(key); r != nil {
+		r.Send(v)
+	}
+}
+
+// The Ref of the entity for key, starting it if it isn't running. If the key's previous entity is being passivated,
+// waits for that to finish first, so that the new one can be given its snapshot, and if another goroutine is
+// starting the key's entity, waits for that one instead of starting a second. Returns nil once the set has been
+// stopped.
+func (s *ShardedSet) Entity(key string) Ref {
+	s.lock.Lock()
+	for {
+		if s.stopped {
+			s.lock.Unlock()
+			return nil
+		}
+		if r, ok := s.entities[key]; ok {
+			s.lock.Unlock()
+			return r
+		}
+		_, passivating := s.passivating[key]
+		_, starting := s.starting[key]
+		if !passivating && !starting {
+			break
+		}
+		s.cond.Wait()
+	}
+	// The key is held for this goroutine while the entity starts, so the lock isn't held while the factory and the
+	// entity's options run.
+	s.starting[key] = struct{}{}
+	snapshot, restore := s.snapshots[key]
+	delete(s.snapshots, key)
+	s.lock.Unlock()
+
+	r := StartFuncName(s.name+" "+key, s.factory(key), s.opts...)
+	if restore {
+		r.Send(Restore{Snapshot: snapshot})
+	}
+
+	s.lock.Lock()
+	delete(s.starting, key)
+	s.cond.Broadcast()
+	if s.stopped {
+		// Stop missed this entity, since it wasn't in the set yet.
+		s.lock.Unlock()
+		r.Stop()
+		return nil
+	}
+	defer s.lock.Unlock()
+	s.entities[key] = r
+	if ref, ok := r.(*embeddableRef); ok {
+		// Registered with the lock held, so the entity can't be forgotten before it has been added. If it has
+		// already finished, the hook isn't registered, and the entity is forgotten straight away instead.
+		if !ref.onExit(shardedEntity{s, key}, func() { s.forget(key, r) }) {
+			delete(s.entities, key)
+		}
+	}
+	return r
+}
+
+// Removes the entity for key, as long as it's still the given one and not one started after it finished.
+func (s *ShardedSet) forget(key string, r Ref) {
+	s.lock.Lock()
+	if s.entities[key] == r {
+		delete(s.entities, key)
+	}
+	s.lock.Unlock()
+}
+
+// The Ref of the entity for key, without starting one. Returns false if there isn't one running.
+func (s *ShardedSet) Lookup(key string) (Ref, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.entities[key]
+	return r, ok
+}
+
+// The key of every entity that is running, sorted.
+func (s *ShardedSet) Keys() []string {
+	s.lock.Lock()
+	keys := make([]string, 0, len(s.entities))
+	for key := range s.entities {
+		keys = append(keys, key)
+	}
+	s.lock.Unlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// How many entities are running.
+func (s *ShardedSet) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.entities)
+}
+
+// Makes the set passivate entities that have been waiting in Recv with an empty mailbox for longer than idle. A
+// passivated entity is sent Passivate with Call, and has up to timeout to reply with a snapshot of its state and
+// return; it's stopped if it hasn't by then. The next message for its key starts a new entity, whose first message
+// is a Restore carrying the snapshot, so passivation is invisible to whoever sends to the set. An idle <= 0 turns
+// passivation off.
+//
+// Messages sent straight to an entity's Ref, rather than through the set, are lost if they arrive while it's being
+// passivated.
+func (s *ShardedSet) SetPassivation(idle, timeout time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sweepStop != nil {
+		close(s.sweepStop)
+		s.sweepStop = nil
+	}
+	if idle <= 0 || s.stopped {
+		return
+	}
+	stop := make(chan struct{})
+	s.sweepStop = stop
+	go s.sweep(optionsClock(s.opts), idle, timeout, stop)
+}
+
+// Looks for idle entities every so often, until stop is closed. Waits on the same clock the entities measure their
+// idle time on, so a FakeClock given to them with WithClock drives the sweep too.
+func (s *ShardedSet) sweep(c Clock, idle, timeout time.Duration, stop chan struct{}) {
+	interval := idle / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	t := c.NewTimer(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.Chan():
+		case <-stop:
+			return
+		}
+		t.Reset(interval)
+
+		s.lock.Lock()
+		for key, r := range s.entities {
+			ref, ok := localRef(r)
+			if !ok || !isIdle(ref.e, idle) {
+				continue
+			}
+			// Removed straight away, so that nothing else is sent to it through the set.
+			delete(s.entities, key)
+			s.passivating[key] = struct{}{}
+			go s.passivate(key, r, timeout)
+		}
+		s.lock.Unlock()
+	}
+}
+
+// Whether the coroutine is waiting for a message with nothing in its mailbox, and hasn't received one for idle.
+func isIdle(e *Embeddable, idle time.Duration) bool {
+	info := e.info()
+	if info.State != StateWaiting || info.MailboxLen > 0 {
+		return false
+	}
+	last := time.Unix(0, atomic.LoadInt64(&e.lastActivity))
+	return e.clock.Now().Sub(last) >= idle
+}
+
+func (s *ShardedSet) passivate(key string, r Ref, timeout time.Duration) {
+	snapshot, err := Call(r, Passivate{}, timeout)
+	if r.Running() {
+		r.Stop()
+	}
+
+	s.lock.Lock()
+	if err == nil {
+		s.snapshots[key] = snapshot
+	}
+	delete(s.passivating, key)
+	s.cond.Broadcast()
+	s.lock.Unlock()
+}
+
+// Stops every entity, and stops any more from being started.
+func (s *ShardedSet) Stop() {
+	s.lock.Lock()
+	s.stopped = true
+	s.cond.Broadcast()
+	if s.sweepStop != nil {
+		close(s.sweepStop)
+		s.sweepStop = nil
+	}
+	entities := make([]Ref, 0, len(s.entities))
+	for _, r := range s.entities {
+		entities = append(entities, r)
+	}
+	s.lock.Unlock()
+
+	for _, r := range entities {
+		r.Stop()
+	}
+}
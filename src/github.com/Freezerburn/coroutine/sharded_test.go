@@ -0,0 +1,83 @@
+package coroutine
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// An entity that counts the messages it's sent, passivating with the count and picking it up again from Restore.
+func countingEntity(counts chan int) EntityFactory {
+	return func(key string) Function {
+		return func(c Coroutine) {
+			count := 0
+			for {
+				switch v := c.Recv().(type) {
+				case Passivate:
+					c.(Replier).Reply(count)
+					return
+				case Restore:
+					count = v.Snapshot.(int)
+				default:
+					count++
+					counts <- count
+				}
+			}
+		}
+	}
+}
+
+func TestShardedSetStartsOneEntityPerKey(t *testing.T) {
+	s := NewShardedSet("test", countingEntity(make(chan int, 100)))
+	defer s.Stop()
+
+	refs := make([]Ref, 16)
+	var wg sync.WaitGroup
+	for i := range refs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			refs[i] = s.Entity("a")
+		}(i)
+	}
+	wg.Wait()
+	for _, r := range refs {
+		if r != refs[0] {
+			t.Fatal("expected every caller to get the same entity")
+		}
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("expected 1 entity, got %d", n)
+	}
+}
+
+func TestShardedSetPassivatesOnEntityClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	counts := make(chan int, 1)
+	s := NewShardedSet("test", countingEntity(counts), WithClock(clock))
+	defer s.Stop()
+
+	s.SendToEntity("a", "hello")
+	if n := <-counts; n != 1 {
+		t.Fatalf("expected the first message to be counted as 1, got %d", n)
+	}
+	s.SetPassivation(time.Minute, time.Second)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := s.Lookup("a"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the entity to be passivated once its clock moved past the idle time")
+		}
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+
+	s.SendToEntity("a", "again")
+	if n := <-counts; n != 2 {
+		t.Fatalf("expected the restored entity to carry on counting from 1, got %d", n)
+	}
+}
@@ -0,0 +1,74 @@
+package coroutine
+
+import (
+	"os"
+	"os/signal"
+)
+
+// Sent to a coroutine started by SignalSource to make R receive the signals it sees.
+type Subscribe struct {
+	R Ref
+}
+
+// Sent to a coroutine started by SignalSource to stop R receiving the signals it sees.
+type Unsubscribe struct {
+	R Ref
+}
+
+// What the goroutine waiting on signals sends the coroutine started by SignalSource for each one.
+type signalReceived struct {
+	sig os.Signal
+}
+
+// Starts a coroutine that receives the given signals, or every signal if there are none, and sends each one as an
+// os.Signal to every coroutine subscribed to it, so that shutting down on SIGTERM or reloading on SIGHUP is handled
+// by a coroutine like any other message, rather than by a channel in main. Subscribe by sending it a Subscribe, and
+// stop with an Unsubscribe. Subscribers started by this package are dropped once they finish.
+//
+// Signals are handled the way signal.Notify handles them, so SIGINT and SIGTERM no longer end the program by
+// themselves while the coroutine is running. Once it's stopped, they do again.
+func SignalSource(sigs ...os.Signal) Ref {
+	return StartFuncName("SignalSource", func(c Coroutine) {
+		e := c.(*Embeddable)
+		ch := make(chan os.Signal, 8)
+		signal.Notify(ch, sigs...)
+		done := make(chan struct{})
+		defer func() {
+			signal.Stop(ch)
+			close(done)
+		}()
+		self := e.ref()
+		go func() {
+			for {
+				select {
+				case sig := <-ch:
+					self.Send(signalReceived{sig: sig})
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		subscribers := make(map[Ref]struct{})
+		for {
+			switch v := c.Recv().(type) {
+			case Subscribe:
+				if _, ok := subscribers[v.R]; !ok {
+					subscribers[v.R] = struct{}{}
+					c.(Linker).Monitor(v.R)
+				}
+			case Unsubscribe:
+				if _, ok := subscribers[v.R]; ok {
+					delete(subscribers, v.R)
+					c.(Linker).Demonitor(v.R)
+				}
+			case Exit:
+				delete(subscribers, v.From)
+			case signalReceived:
+				for r := range subscribers {
+					r.Send(v.sig)
+				}
+			}
+		}
+	})
+}
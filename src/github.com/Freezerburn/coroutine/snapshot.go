@@ -0,0 +1,238 @@
+package coroutine
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Returned by SaveSnapshot when the coroutine wasn't started with WithSnapshots, or isn't a Snapshotter.
+var ErrNoSnapshots = errors.New("coroutine: not started with WithSnapshots")
+
+// Implemented by a Starter that can save its state as a single value and put it back again, so that, when it's
+// started with WithSnapshots, it carries on from where it was when it last stopped, including across deploys. Restore
+// is called with what Snapshot last returned before Start runs, and Snapshot is called when the coroutine stops
+// because it was told to or Start returned, though not when it panics, since its state may be broken. Both run on the
+// coroutine's goroutine, so they can use its fields freely, but shouldn't do anything else.
+type Snapshotter interface {
+	Snapshot() interface{}
+	Restore(state interface{})
+}
+
+// The state of a coroutine, as saved to a SnapshotStore.
+type Snapshot struct {
+	// What the Snapshotter's Snapshot returned.
+	State interface{}
+	// For a Persistent, the number of the last event applied to State, so that only those after it are replayed.
+	// Zero otherwise.
+	Sequence uint64
+	Taken    time.Time
+}
+
+// Where coroutines started with WithSnapshots keep their snapshots, by id. Only the latest is kept for each id.
+// Implementations backed by a database or file let a coroutine's state survive restarts of the program. Must be safe
+// for concurrent use.
+type SnapshotStore interface {
+	// Returns false if nothing has been saved for the id.
+	Load(id string) (Snapshot, bool, error)
+	Save(id string, s Snapshot) error
+}
+
+// Makes a coroutine whose Starter is a Snapshotter restore the snapshot saved for id in store when it starts, and
+// save a new one when it stops, so that it survives being stopped and started again, whether by Restart or by the
+// program being deployed again. If the snapshot can't be loaded, the error is logged and the coroutine stops before
+// Start is called, rather than carrying on without its state and then saving over the snapshot. If one can't be saved
+// as the coroutine stops, all that can be done is to log the error.
+//
+// A Persistent started with WithSnapshots restores its snapshot and then only replays the events stored after it was
+// taken, which is much quicker for one with a long history. Has no effect on coroutines started by StartFunc.
+func WithSnapshots(id string, store SnapshotStore) Option {
+	return func(e *Embeddable) {
+		x := e.extra()
+		x.snapshotId = id
+		x.snapshotStore = store
+	}
+}
+
+// Saves a snapshot of the coroutine now, rather than waiting until it stops, so that less is lost if it panics or the
+// program crashes. Returns ErrNoSnapshots if it wasn't started with WithSnapshots or isn't a Snapshotter.
+func (e *Embeddable) SaveSnapshot() error {
+	e.checkpoint()
+	x := e.snapshots()
+	if x == nil {
+		return ErrNoSnapshots
+	}
+	return e.saveSnapshot(x)
+}
+
+// The coroutine's extras, if it was started with WithSnapshots and is a Snapshotter, or nil.
+func (e *Embeddable) snapshots() *extras {
+	x := e.ext.Load()
+	if x == nil || x.snapshotStore == nil || e.snapshotter == nil {
+		return nil
+	}
+	return x
+}
+
+func (e *Embeddable) saveSnapshot(x *extras) error {
+	s := Snapshot{State: e.snapshotter.Snapshot(), Taken: e.clock.Now()}
+	if e.snapshotSeq != nil {
+		s.Sequence = e.snapshotSeq()
+	}
+	return x.snapshotStore.Save(x.snapshotId, s)
+}
+
+// Restores the coroutine's snapshot, if it has one, and returns its Sequence. Stops the coroutine if it can't be
+// loaded.
+func (e *Embeddable) restoreSnapshot() uint64 {
+	x := e.snapshots()
+	if x == nil {
+		return 0
+	}
+	s, ok, err := x.snapshotStore.Load(x.snapshotId)
+	if err != nil {
+		e.logError("Couldn't load snapshot.", "snapshot_id", x.snapshotId, "error", err)
+		panic(Stop{})
+	}
+	if !ok {
+		return 0
+	}
+	e.snapshotter.Restore(s.State)
+	return s.Sequence
+}
+
+// Records that the coroutine's state has been restored from its snapshot, if it has one, so that it can be saved
+// over.
+func (e *Embeddable) markSnapshotRestored() {
+	if x := e.snapshots(); x != nil {
+		x.snapshotRestored = true
+	}
+}
+
+// Saves the coroutine's snapshot as it stops, if its state was restored, since otherwise this would save over the
+// snapshot it never got as far as restoring.
+func (e *Embeddable) saveFinalSnapshot() {
+	x := e.snapshots()
+	if x == nil || !x.snapshotRestored {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e.logError("Snapshot panicked.", "snapshot_id", x.snapshotId, "panic", r)
+		}
+	}()
+	if err := e.saveSnapshot(x); err != nil {
+		e.logError("Couldn't save snapshot.", "snapshot_id", x.snapshotId, "error", err)
+	}
+}
+
+// A SnapshotStore that keeps snapshots in memory, so coroutines keep their state across restarts of the coroutine,
+// but not of the program. Safe for concurrent use.
+type MemorySnapshotStore struct {
+	lock      sync.Mutex
+	snapshots map[string]Snapshot
+}
+
+var _ SnapshotStore = (*MemorySnapshotStore)(nil)
+
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{snapshots: make(map[string]Snapshot)}
+}
+
+func (s *MemorySnapshotStore) Load(id string) (Snapshot, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snap, ok := s.snapshots[id]
+	return snap, ok, nil
+}
+
+func (s *MemorySnapshotStore) Save(id string, snap Snapshot) error {
+	s.lock.Lock()
+	s.snapshots[id] = snap
+	s.lock.Unlock()
+	return nil
+}
+
+// A SnapshotStore that keeps each id's snapshot in a file of its own in a directory, replacing it whole each time so
+// that a crash part of the way through saving leaves the previous one in place. States are encoded with the Codec set
+// with SetCodec, so the concrete type of every State must be registered with it.
+type FileSnapshotStore struct {
+	dir string
+}
+
+var _ SnapshotStore = (*FileSnapshotStore)(nil)
+
+// Creates a FileSnapshotStore in dir, creating it if it doesn't exist.
+func NewFileSnapshotStore(dir string) (*FileSnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileSnapshotStore{dir: dir}, nil
+}
+
+// How a snapshot is written to its file, with State encoded by the Codec.
+type snapshotFile struct {
+	State    []byte
+	Sequence uint64
+	Taken    time.Time
+}
+
+func (s *FileSnapshotStore) path(id string) string {
+	return filepath.Join(s.dir, url.PathEscape(id)+".snapshot")
+}
+
+func (s *FileSnapshotStore) Load(id string) (Snapshot, bool, error) {
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, false, nil
+	}
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	var file snapshotFile
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&file)
+	snap := Snapshot{Sequence: file.Sequence, Taken: file.Taken}
+	if err == nil {
+		snap.State, err = decodeValue(file.State)
+	}
+	if err != nil {
+		return Snapshot{}, false, fmt.Errorf("coroutine: can't decode snapshot of %q: %w", id, err)
+	}
+	return snap, true, nil
+}
+
+func (s *FileSnapshotStore) Save(id string, snap Snapshot) error {
+	state, err := encodeValue(snap.State)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	file := snapshotFile{State: state, Sequence: snap.Sequence, Taken: snap.Taken}
+	if err := gob.NewEncoder(&buf).Encode(file); err != nil {
+		return err
+	}
+	// Written under a name of its own, so that saves of the same id from two places don't write into each other.
+	f, err := os.CreateTemp(s.dir, url.PathEscape(id)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path(id))
+}
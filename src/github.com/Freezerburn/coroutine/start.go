@@ -1,7 +1,10 @@
 package coroutine
 
 import (
-	"sync"
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,9 +19,10 @@ func (s *Stop) Error() string {
 	return "coroutine stop"
 }
 
-// Signature of the func that can be started as a coroutine. Receives the embeddable struct so that the func can
-// call methods on it to act as a coroutine.
-type Function func(embeddable *Embeddable)
+// Signature of the func that can be started as a coroutine. Receives the coroutine itself so that the func can
+// call methods on it to act as a coroutine. When started by one of the Start functions, this is always an
+// *Embeddable.
+type Function func(c Coroutine)
 type Starter interface {
 	Start()
 	Embedded() *Embeddable
@@ -28,98 +32,281 @@ const (
 	defaultName = "Default Coroutine Name"
 )
 
-var (
-	nextId     uint64 = 1
-	nextIdLock sync.Mutex
-)
+// Changes how a coroutine behaves. Passed to any of the Start functions.
+type Option func(e *Embeddable)
 
-func StartFunc(f Function) Ref {
-	return StartFuncName(defaultName, f)
+// Makes the coroutine use the given Clock for all of its waits, instead of the one set by SetClock.
+func WithClock(c Clock) Option {
+	return func(e *Embeddable) {
+		e.clock = c
+	}
 }
 
-func StartFuncName(name string, f Function) Ref {
-	next := &Embeddable{
-		name:         name,
-		waitTimer:    time.NewTimer(0),
-		receiver:     make(chan bool),
-		receiveTimer: time.NewTimer(0),
-		running:      true,
+// The Clock a coroutine started with the given options would use, for code that waits alongside it.
+func optionsClock(opts []Option) Clock {
+	e := &Embeddable{clock: defaultClock()}
+	for _, opt := range opts {
+		opt(e)
 	}
+	return e.clock
+}
 
-	nextIdLock.Lock()
-	next.id = nextId
-	nextId++
-	nextIdLock.Unlock()
-
-	go func() {
-		defer func() {
-			// Ensure external code will know that this coroutine is stopped if the program doesn't end due to the
-			// panic.
-			next.running = false
-			// Close down all the coroutine's resources.
-			next.waitTimer.Stop()
-			next.receiveTimer.Stop()
-			close(next.receiver)
-
-			if e := recover(); e != nil {
-				if _, ok := e.(Stop); ok {
-					// Stop requested for this coroutine, so we just let the goroutine end.
-				} else {
-					// Repanic since it came from code that isn't part of the coroutine library.
-					panic(e)
-				}
-			}
-		}()
+func StartFunc(f Function, opts ...Option) Ref {
+	return StartFuncName(defaultName, f, opts...)
+}
 
+func StartFuncName(name string, f Function, opts ...Option) Ref {
+	next := newEmbeddable()
+	return start(name, next, func() {
 		f(next)
-	}()
-
-	return &embeddableRef{next}
+	}, opts)
 }
 
-func Start(s Starter) Ref {
-	return StartName(defaultName, s)
+func Start(s Starter, opts ...Option) Ref {
+	return StartName(defaultName, s, opts...)
 }
 
 func (e *Embeddable) Embedded() *Embeddable {
 	return e
 }
 
-func StartName(name string, s Starter) Ref {
+func StartName(name string, s Starter, opts ...Option) Ref {
 	e := s.Embedded()
-	e.name = name
-	e.waitTimer = time.NewTimer(0)
-	e.receiver = make(chan bool)
-	e.receiveTimer = time.NewTimer(0)
-	e.running = true
-
-	nextIdLock.Lock()
-	e.id = nextId
-	nextId++
-	nextIdLock.Unlock()
-
-	go func() {
+	e.owned = false
+	e.resetState = nil
+	if r, ok := s.(Resetter); ok {
+		e.resetState = r.ResetState
+	}
+	e.snapshotSeq = nil
+	sn, ok := s.(Snapshotter)
+	if !ok {
+		e.snapshotter = nil
+		return start(name, e, s.Start, opts)
+	}
+	e.snapshotter = sn
+	return start(name, e, func() {
+		e.restoreSnapshot()
+		e.markSnapshotRestored()
+		s.Start()
+	}, opts)
+}
+
+// Runs the coroutine under the given Scheduler instead of letting it run freely. It will only run during calls to
+// the scheduler's Step and RunUntilIdle, and uses the scheduler's clock for all of its waits.
+func WithScheduler(s *Scheduler) Option {
+	return func(e *Embeddable) {
+		e.sched = s
+		e.clock = s.clock
+	}
+}
+
+// Records the referenced coroutine as the parent of the one being started, so it appears beneath it in Tree. This is
+// purely descriptive: stopping the parent doesn't stop its children.
+func WithParent(r Ref) Option {
+	return func(e *Embeddable) {
+		e.parent = r.Id()
+	}
+}
+
+// Makes the coroutine record which coroutine sent each message it receives, so that Sender can say who to reply to.
+// Working out whether a Send came from inside a coroutine costs around a microsecond per message, which is why it
+// isn't done for every coroutine.
+func WithSenders() Option {
+	return func(e *Embeddable) {
+		e.recordSenders = true
+	}
+}
+
+// Stops the coroutine once it has been waiting in one of the Recv functions for longer than d without a message
+// arriving, as if it had been stopped through its Ref. Useful for coroutines that belong to something that may go
+// away without telling them, such as a connection, so that forgotten ones don't pile up. Pause isn't affected.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(e *Embeddable) {
+		e.idleTimeout = d
+	}
+}
+
+// Shared implementation of all the Start functions. Initializes the given Embeddable so it's ready to be used as a
+// coroutine, then runs body in a new goroutine that is set up to recover from the panic used to stop a coroutine.
+func start(name string, e *Embeddable, body func(), opts []Option) Ref {
+	if !e.restarting {
+		// Left alone by Restart, since the Ref is still in use and neither of them changes.
+		e.self = &embeddableRef{e: e, gen: atomic.LoadUint64(&e.generation)}
+		e.name = name
+	}
+	e.body = body
+	e.opts = opts
+	e.clock = defaultClock()
+	timer := e.timer
+	e.timer = nil
+	if !e.recycled {
+		e.receiver = make(chan bool, 1)
+	}
+	e.done = make(chan struct{})
+	e.sched = nil
+	e.recording = nil
+	e.logger = nil
+	e.msgCtx = nil
+	e.endProcessing = nil
+	e.parent = 0
+	e.recordSenders = false
+	e.envelopes = false
+	e.trapExit = false
+	e.limiter = nil
+	e.idleTimeout = 0
+	e.maxLifetime = 0
+	e.deadline = time.Time{}
+	e.suspended = 0
+	e.lazy = false
+	e.pooled = false
+	e.unique = false
+	e.labels = nil
+	e.onAck = nil
+	e.priority = 0
+	e.ringMailbox = false
+	e.customMailbox = nil
+	// Before the options, since some of them set what's in it.
+	e.ext.Store(nil)
+	for _, opt := range opts {
+		opt(e)
+	}
+	// A pooled timer from the real clock is just as good as a new one, whatever it was last set to.
+	if _, real := e.clock.(realClock); real && e.recycled {
+		e.timer = timer
+	}
+	e.recycled = false
+	startedAt := e.clock.Now().UnixNano()
+	e.initMailbox()
+	atomic.StoreUint64(&e.enqueued, 0)
+	atomic.StoreUint64(&e.dequeued, 0)
+	atomic.StoreInt64(&e.started, startedAt)
+	atomic.StoreInt64(&e.lastActivity, startedAt)
+	atomic.StoreInt64(&e.finished, 0)
+	e.setState(StateRunning)
+	e.lastCall = time.Now().UnixNano()
+	e.goid = 0
+	e.launched = 0
+	e.parked = notParked
+	e.sysQueue = nil
+	e.sysPending = 0
+	e.sender = nil
+	e.call = 0
+	e.calls = nil
+	e.callsClosed = false
+	e.exited = false
+	e.exitReason = ExitReturned
+	e.exitPanic = nil
+	e.exitHooks = nil
+
+	// Set before the coroutine counts as running, since anything sent to it from then on can launch it.
+	pooled := e.pooled && e.owned
+	e.run = func() {
+		if pooled {
+			defer e.release()
+		}
+		defer e.goroutine.Done()
+		registerGoroutine(e)
 		defer func() {
+			r := recover()
+			_, stopped := r.(Stop)
+			if r == nil || stopped {
+				e.saveFinalSnapshot()
+			}
+
 			// Ensure external code will know that this coroutine is stopped if the program doesn't end due to the
 			// panic.
-			e.running = false
-			// Close down all the coroutine's resources.
-			e.waitTimer.Stop()
-			e.receiveTimer.Stop()
-			close(e.receiver)
-
-			if e := recover(); e != nil {
-				if _, ok := e.(Stop); ok {
-					// Stop requested for this coroutine, so we just let the goroutine end.
-				} else {
-					// Repanic since it came from code that isn't part of the coroutine library.
-					panic(e)
-				}
+			e.setRunning(false)
+			atomic.StoreInt64(&e.finished, e.clock.Now().UnixNano())
+			unregister(e)
+			e.finishProcessing()
+			e.finishHandling()
+			// Close down all the coroutine's resources. The receiver channel is deliberately left open: a Ref can
+			// outlive the coroutine, and sending to a closed channel would panic in the sender.
+			if e.timer != nil {
+				e.timer.Stop()
+			}
+			e.failCalls()
+			switch {
+			case r != nil && !stopped:
+				e.notifyExit(ExitPanicked, r)
+			case stopped:
+				e.notifyExit(ExitStopped, nil)
+			default:
+				e.notifyExit(ExitReturned, nil)
+			}
+			close(e.done)
+			if e.sched != nil {
+				e.sched.exited(e)
+			}
+
+			if r != nil && !stopped {
+				atomic.AddUint64(&totalPanicked, 1)
+				e.trace(TracePanic, r, 0)
+				e.logError("Coroutine panicked.", "panic", r)
+				// Repanic since it came from code that isn't part of the coroutine library.
+				panic(r)
 			}
+			// Otherwise either the coroutine returned or a stop was requested, so we just let the goroutine end.
+			if stopped {
+				atomic.AddUint64(&totalStopped, 1)
+			} else {
+				atomic.AddUint64(&totalReturned, 1)
+			}
+			e.trace(TraceStop, nil, 0)
+			e.logDebug("Coroutine finished.")
 		}()
 
-		s.Start()
-	}()
+		if e.sched != nil {
+			e.sched.wait(e)
+			if !e.isRunning() {
+				panic(Stop{})
+			}
+		}
+		e.waitResumed()
+		if !e.isRunning() {
+			panic(Stop{})
+		}
+		e.logDebug("Coroutine started.")
+		// Labelled so that CPU profiles and goroutine dumps show which coroutine the work belongs to.
+		pprof.Do(context.Background(), pprof.Labels(
+			"coroutine_name", e.name,
+			"coroutine_id", strconv.FormatUint(e.id, 10),
+		), func(context.Context) {
+			body()
+		})
+	}
+
+	if e.restarting {
+		// Keeps its id, so it's still the same coroutine to anything that looked it up.
+		e.restarting = false
+	} else {
+		e.id = newId()
+	}
+	// Only now that everything is reset, so that anything sent while Restart is resetting it is dead-lettered rather
+	// than racing with it.
+	e.setRunning(true)
+
+	e.startStack = recordStartStack(1)
+	if existing := register(e); existing != nil {
+		// Nothing ever runs on e, so it's finished already.
+		e.setRunning(false)
+		close(e.done)
+		return existing.ref()
+	}
+	atomic.AddUint64(&totalStarted, 1)
+	if e.sched != nil {
+		e.sched.add(e)
+	}
+	if n, ok := e.customMailbox.(NotifyingMailbox); ok {
+		n.Notify(e.notify)
+	}
+
+	e.enforceDeadline()
+	if e.lazy && e.sched == nil {
+		e.setState(StateUnstarted)
+	} else {
+		e.launch()
+	}
 
-	return &embeddableRef{e}
+	return e.ref()
 }
@@ -0,0 +1,15 @@
+package coroutine
+
+import (
+	"testing"
+)
+
+// Measures how quickly coroutines can be started from many goroutines at once, each of which returns straight away.
+func BenchmarkStartParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			StartFunc(func(c Coroutine) {})
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package coroutine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Implemented by Refs that can report how busy their coroutine is, used by StatsOf.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// A snapshot of how busy the coroutine behind r is. Everything is zero if r isn't a StatsReporter.
+func StatsOf(r Ref) Stats {
+	if s, ok := refAs[StatsReporter](r); ok {
+		return s.Stats()
+	}
+	return Stats{}
+}
+
+// A snapshot of how busy a coroutine is, returned by StatsOf.
+type Stats struct {
+	// How many messages are waiting in the mailbox right now.
+	MailboxLen int
+	// How many messages have been put into the mailbox since the coroutine started.
+	Received uint64
+	// How many messages the coroutine has taken out of its mailbox with one of the Recv functions.
+	Processed uint64
+	Started   time.Time
+	// How long the coroutine has been running for, or ran for if it has finished.
+	Uptime time.Duration
+	// The last time the coroutine received a message or woke up from a Pause. The time it started if it has done
+	// neither yet.
+	LastActivity time.Time
+	// How long each message waited in the mailbox before the coroutine received it.
+	QueueTime Histogram
+	// How long the coroutine spent on each message: from receiving it until it next tried to receive, or finished.
+	// The message it's working on right now isn't included. If QueueTime is growing while HandleTime isn't, messages
+	// are arriving faster than the coroutine can get through them.
+	HandleTime Histogram
+}
+
+// When the coroutine was started, according to its clock.
+func (e *Embeddable) startedAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&e.started))
+}
+
+// Marks that the coroutine has just done something, for Stats.LastActivity.
+func (e *Embeddable) touch() {
+	atomic.StoreInt64(&e.lastActivity, e.clock.Now().UnixNano())
+}
+
+func (e *Embeddable) stats() Stats {
+	s := Stats{
+		MailboxLen: e.mailbox.len(),
+		Received:   atomic.LoadUint64(&e.enqueued),
+		Processed:  atomic.LoadUint64(&e.dequeued),
+		Started:    e.startedAt(),
+	}
+
+	end := e.clock.Now()
+	if finished := atomic.LoadInt64(&e.finished); finished != 0 {
+		end = time.Unix(0, finished)
+	}
+	s.Uptime = end.Sub(s.Started)
+	s.LastActivity = time.Unix(0, atomic.LoadInt64(&e.lastActivity))
+	if x := e.ext.Load(); x != nil {
+		s.QueueTime = x.queueTime.snapshot()
+		s.HandleTime = x.handleTime.snapshot()
+	}
+	return s
+}
+
+// Called once the coroutine is done with the message it last received, if any, to record how long it took.
+func (e *Embeddable) finishHandling() {
+	x := e.ext.Load()
+	if x == nil || x.handlingSince.IsZero() {
+		return
+	}
+	x.handleTime.observe(e.clock.Now().Sub(x.handlingSince))
+	x.handlingSince = time.Time{}
+}
+
+// Counts across every coroutine since the program started, returned by ReadTotals.
+type Totals struct {
+	// Coroutines currently running.
+	Live int
+	// Coroutines ever started.
+	Started uint64
+	// Coroutines that finished by returning from their function.
+	Returned uint64
+	// Coroutines that finished by being stopped.
+	Stopped uint64
+	// Coroutines that finished by panicking.
+	Panicked uint64
+	// Messages that went to dead letters for any DeadLetterReason: sent to a coroutine that had already stopped,
+	// replies to a Call that had given up, expired, duplicate, refused by a Mailbox, or of a type the coroutine can't
+	// handle.
+	Dropped uint64
+	// The number of messages waiting across every live coroutine's mailbox, and in the fullest one.
+	MailboxTotal int
+	MailboxMax   int
+}
+
+var (
+	totalStarted  uint64
+	totalReturned uint64
+	totalStopped  uint64
+	totalPanicked uint64
+	totalDropped  uint64
+)
+
+// Reads the counts across every coroutine. Live and the mailbox counts require looking at every live coroutine, so
+// this shouldn't be called in a tight loop.
+func ReadTotals() Totals {
+	t := Totals{
+		Started:  atomic.LoadUint64(&totalStarted),
+		Returned: atomic.LoadUint64(&totalReturned),
+		Stopped:  atomic.LoadUint64(&totalStopped),
+		Panicked: atomic.LoadUint64(&totalPanicked),
+		Dropped:  atomic.LoadUint64(&totalDropped),
+	}
+	live := liveCoroutines()
+	t.Live = len(live)
+	for _, e := range live {
+		n := e.mailbox.len()
+		t.MailboxTotal += n
+		if n > t.MailboxMax {
+			t.MailboxMax = n
+		}
+	}
+	return t
+}
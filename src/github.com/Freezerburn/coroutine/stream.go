@@ -0,0 +1,110 @@
+package coroutine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Starts a coroutine that reads r, splits what it reads into frames with split, and sends each frame to out as a
+// []byte of its own, so a pipeline can take its input from a file or a socket without a goroutine of its own to feed
+// it. split is any bufio.SplitFunc: bufio.ScanLines for lines, bufio.ScanWords, ScanFrames for frames with a length in
+// front of them, or one of the program's own. Frames can be at most bufio.MaxScanTokenSize long.
+//
+// The coroutine returns once r has been read to the end, or reading it fails, which is logged. If r is an io.Closer
+// it's closed once the coroutine finishes, however it finishes, so stopping the coroutine stops a Read that's waiting
+// on a socket. Monitor the coroutine to find out when everything has been sent to out.
+func FromReader(r io.Reader, split bufio.SplitFunc, out Ref, opts ...Option) Ref {
+	return StartFuncName("FromReader", func(c Coroutine) {
+		e := c.(*Embeddable)
+		var stopped int32
+		if closer, ok := r.(io.Closer); ok {
+			defer closer.Close()
+		}
+		defer atomic.StoreInt32(&stopped, 1)
+
+		// Reads on a goroutine of its own, since a Read can block for as long as the other end of a socket likes, and
+		// the coroutine has to be able to stop meanwhile.
+		self := e.ref()
+		go func() {
+			s := bufio.NewScanner(r)
+			s.Split(split)
+			for s.Scan() {
+				if atomic.LoadInt32(&stopped) != 0 {
+					return
+				}
+				out.Send(append([]byte(nil), s.Bytes()...))
+			}
+			self.Send(readerDone{err: s.Err()})
+		}()
+
+		for {
+			if done, ok := c.Recv().(readerDone); ok {
+				if done.err != nil && atomic.LoadInt32(&stopped) == 0 {
+					e.logError("Reading failed.", "error", done.err)
+				}
+				return
+			}
+		}
+	}, opts...)
+}
+
+// What the goroutine reading for FromReader sends the coroutine once there's nothing more to read.
+type readerDone struct {
+	err error
+}
+
+// Starts a coroutine that writes every message it receives to w: a []byte or a string as it is, and anything else as
+// fmt.Fprint formats it. If writing fails, the error is logged and the coroutine returns. If w is an io.Closer it's
+// closed once the coroutine finishes, however it finishes, so stopping the coroutine closes the file or socket.
+func ToWriter(w io.Writer, opts ...Option) Ref {
+	return StartFuncName("ToWriter", func(c Coroutine) {
+		e := c.(*Embeddable)
+		if closer, ok := w.(io.Closer); ok {
+			defer closer.Close()
+		}
+		for {
+			var err error
+			switch v := c.Recv().(type) {
+			case []byte:
+				_, err = w.Write(v)
+			case string:
+				_, err = io.WriteString(w, v)
+			default:
+				_, err = fmt.Fprint(w, v)
+			}
+			if err != nil {
+				e.logError("Writing failed.", "error", err)
+				return
+			}
+		}
+	}, opts...)
+}
+
+// Returned by ScanFrames for a frame longer than bufio.MaxScanTokenSize.
+var ErrFrameTooLong = errors.New("coroutine: frame too long")
+
+// A bufio.SplitFunc for frames that each start with their length as a 4 byte big-endian integer, which is left out of
+// the frame itself. The same framing FileJournal uses for its records.
+func ScanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) < 4 {
+		if atEOF && len(data) > 0 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	n := binary.BigEndian.Uint32(data)
+	if n > bufio.MaxScanTokenSize-4 {
+		return 0, nil, ErrFrameTooLong
+	}
+	if uint32(len(data)-4) < n {
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	return 4 + int(n), data[4 : 4+n], nil
+}
@@ -0,0 +1,84 @@
+package coroutine
+
+import (
+	"sync/atomic"
+)
+
+// Creates a coroutine that is ready to be sent to, but doesn't run f until Resume is called on its Ref. Messages sent
+// before then wait in its mailbox. This lets a batch of coroutines be wired together, by sending each other's Refs,
+// before any of them starts handling messages. Stopping it before it's resumed means f never runs at all.
+func StartFuncPaused(f Function, opts ...Option) Ref {
+	return StartFuncNamePaused(defaultName, f, opts...)
+}
+
+func StartFuncNamePaused(name string, f Function, opts ...Option) Ref {
+	return StartFuncName(name, f, append(opts[:len(opts):len(opts)], startSuspended)...)
+}
+
+// Used by StartFuncPaused to start the coroutine suspended. Given last, so that no other option can undo it.
+func startSuspended(e *Embeddable) {
+	e.suspended = 1
+}
+
+func (e *Embeddable) isSuspended() bool {
+	return atomic.LoadInt32(&e.suspended) != 0
+}
+
+// Implemented by Refs whose coroutine can be suspended, used by Suspend and Resume.
+type Suspendable interface {
+	Suspend()
+	Resume()
+}
+
+// Suspends the coroutine behind r until it's resumed with Resume, as described on the Suspend method of the Ref of a
+// coroutine started by this package. Does nothing if r isn't Suspendable.
+func Suspend(r Ref) {
+	if s, ok := refAs[Suspendable](r); ok {
+		s.Suspend()
+	}
+}
+
+// Lets a coroutine suspended with Suspend, or started paused, carry on. Does nothing if r isn't Suspendable.
+func Resume(r Ref) {
+	if s, ok := refAs[Suspendable](r); ok {
+		s.Resume()
+	}
+}
+
+// Pauses the coroutine the next time it calls one of its methods, until Resume is called. Messages sent to it in the
+// meantime wait in its mailbox, and timers keep running, so a Pause that should have finished while it was suspended
+// finishes as soon as it's resumed. It can still be stopped while suspended. Does nothing if it's already suspended.
+//
+// Code in the coroutine that doesn't call any of its methods, such as a tight loop, carries on until it does.
+func (r *embeddableRef) Suspend() {
+	if r.stale() {
+		return
+	}
+	atomic.StoreInt32(&r.e.suspended, 1)
+}
+
+// Lets a coroutine that was suspended, or started paused, carry on. Does nothing if it isn't.
+func (r *embeddableRef) Resume() {
+	if !r.stale() && atomic.CompareAndSwapInt32(&r.e.suspended, 1, 0) {
+		r.e.notify()
+	}
+}
+
+// Blocks the coroutine for as long as it's suspended, or until it's stopped. System messages are still handled while
+// it waits, and messages sent to it wait in its mailbox.
+func (e *Embeddable) waitResumed() {
+	if !e.isSuspended() {
+		return
+	}
+	prev := State(atomic.LoadInt32(&e.state))
+	e.setState(StateSuspended)
+	for e.isSuspended() && e.isRunning() {
+		if e.sched != nil {
+			e.sched.suspend(e)
+		} else {
+			e.park(parkedSuspended, nil)
+		}
+		e.handleSystem()
+	}
+	e.setState(prev)
+}
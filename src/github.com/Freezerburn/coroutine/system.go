@@ -0,0 +1,34 @@
+package coroutine
+
+import (
+	"sync/atomic"
+)
+
+// Queues f to be run by the coroutine itself, ahead of anything in its mailbox: the next time it calls any of its
+// methods, or straight away if it's waiting in Recv or Pause. This is how the library's own control messages reach a
+// coroutine without being stuck behind a backlog of user messages, or being seen by the coroutine's own code. f is
+// dropped if the coroutine finishes first.
+func (e *Embeddable) system(f func(e *Embeddable)) {
+	e.sysLock.Lock()
+	e.sysQueue = append(e.sysQueue, f)
+	atomic.StoreInt32(&e.sysPending, 1)
+	e.sysLock.Unlock()
+	e.notify()
+}
+
+// Runs every waiting system message. Called from the coroutine's own goroutine at every checkpoint, so it needs to
+// be cheap when nothing is waiting.
+func (e *Embeddable) handleSystem() {
+	if atomic.LoadInt32(&e.sysPending) == 0 {
+		return
+	}
+	e.sysLock.Lock()
+	queue := e.sysQueue
+	e.sysQueue = nil
+	atomic.StoreInt32(&e.sysPending, 0)
+	e.sysLock.Unlock()
+
+	for _, f := range queue {
+		f(e)
+	}
+}
@@ -0,0 +1,192 @@
+package coroutine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Wraps a Ref so that messages sent to it reach the coroutine at most once per interval. The first message goes
+// straight through. Anything sent before the interval is up is held back, and once it is up only the most recent of
+// those is delivered, so a burst of sends turns into its first and last message. Useful for coroutines fed by noisy
+// sources where only the latest value matters, such as UI events or sensor readings.
+//
+// SendAfter and SendEvery are throttled along with everything else once their messages are due. Call and Ask reach
+// the wrapped Ref straight away, since every one of them expects its own reply, as do the other functions taking a
+// Ref. Stop discards anything being held back.
+func Throttle(r Ref, interval time.Duration) Ref {
+	return &throttledRef{Ref: r, interval: interval, clock: clockOf(r)}
+}
+
+type throttledRef struct {
+	Ref
+	interval time.Duration
+	// The wrapped coroutine's Clock, which the interval is measured on.
+	clock Clock
+
+	lock sync.Mutex
+	// When the last message went through.
+	last time.Time
+	// Delivers the most recent message being held back, if there is one. Set along with cancel, which stops the
+	// timer waiting to deliver it.
+	pending func()
+	cancel  CancelFunc
+}
+
+func (t *throttledRef) Send(v interface{}) {
+	t.send(func() { t.Ref.Send(v) })
+}
+
+func (t *throttledRef) SendContext(ctx context.Context, v interface{}) {
+	t.send(func() { SendContext(ctx, t.Ref, v) })
+}
+
+func (t *throttledRef) send(deliver func()) {
+	t.lock.Lock()
+	now := t.clock.Now()
+	if t.cancel == nil && now.Sub(t.last) >= t.interval {
+		t.last = now
+		t.lock.Unlock()
+		deliver()
+		return
+	}
+	t.pending = deliver
+	if t.cancel == nil {
+		t.cancel = afterFunc(t.clock, t.last.Add(t.interval).Sub(now), nil, t.flush)
+	}
+	t.lock.Unlock()
+}
+
+// Delivers whatever was held back, once the interval is up.
+func (t *throttledRef) flush() {
+	t.lock.Lock()
+	deliver := t.pending
+	t.pending = nil
+	t.cancel = nil
+	t.last = t.clock.Now()
+	t.lock.Unlock()
+
+	if deliver != nil {
+		deliver()
+	}
+}
+
+func (t *throttledRef) SendAfter(v interface{}, d time.Duration) CancelFunc {
+	return sendAfter(t, v, d)
+}
+
+func (t *throttledRef) SendEvery(v interface{}, interval time.Duration) CancelFunc {
+	return sendEvery(t, v, interval)
+}
+
+func (t *throttledRef) refClock() Clock {
+	return t.clock
+}
+
+func (t *throttledRef) wrapped() Ref {
+	return t.Ref
+}
+
+func (t *throttledRef) Stop() {
+	t.lock.Lock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	t.pending = nil
+	t.lock.Unlock()
+	t.Ref.Stop()
+}
+
+// Wraps a Ref so that a burst of messages reaches the coroutine as just its last message, once window has passed
+// without anything else being sent. A steady stream of messages closer together than window is held back until it
+// pauses. Useful for acting on input once it has settled, such as a search box being typed into.
+//
+// SendAfter and SendEvery are debounced along with everything else once their messages are due. Call and Ask reach
+// the wrapped Ref straight away, since every one of them expects its own reply, as do the other functions taking a
+// Ref. Stop discards anything being held back.
+func Debounce(r Ref, window time.Duration) Ref {
+	return &debouncedRef{Ref: r, window: window, clock: clockOf(r)}
+}
+
+type debouncedRef struct {
+	Ref
+	window time.Duration
+	// The wrapped coroutine's Clock, which the window is measured on.
+	clock Clock
+
+	lock sync.Mutex
+	// Delivers the most recent message, once nothing else has been sent for the window. Set along with cancel, which
+	// stops the timer waiting to deliver it.
+	pending func()
+	cancel  CancelFunc
+	// Counts every send, so a timer that fires just as it's replaced can tell it's out of date.
+	sends uint64
+}
+
+func (d *debouncedRef) Send(v interface{}) {
+	d.send(func() { d.Ref.Send(v) })
+}
+
+func (d *debouncedRef) SendContext(ctx context.Context, v interface{}) {
+	d.send(func() { SendContext(ctx, d.Ref, v) })
+}
+
+func (d *debouncedRef) send(deliver func()) {
+	d.lock.Lock()
+	d.pending = deliver
+	d.sends++
+	sends := d.sends
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.cancel = afterFunc(d.clock, d.window, nil, func() {
+		d.flush(sends)
+	})
+	d.lock.Unlock()
+}
+
+// Delivers the most recent message once the window has passed since the given send, unless something else was sent in
+// the meantime and replaced it.
+func (d *debouncedRef) flush(sends uint64) {
+	d.lock.Lock()
+	if d.sends != sends || d.cancel == nil {
+		d.lock.Unlock()
+		return
+	}
+	deliver := d.pending
+	d.pending = nil
+	d.cancel = nil
+	d.lock.Unlock()
+
+	if deliver != nil {
+		deliver()
+	}
+}
+
+func (d *debouncedRef) SendAfter(v interface{}, after time.Duration) CancelFunc {
+	return sendAfter(d, v, after)
+}
+
+func (d *debouncedRef) SendEvery(v interface{}, interval time.Duration) CancelFunc {
+	return sendEvery(d, v, interval)
+}
+
+func (d *debouncedRef) refClock() Clock {
+	return d.clock
+}
+
+func (d *debouncedRef) wrapped() Ref {
+	return d.Ref
+}
+
+func (d *debouncedRef) Stop() {
+	d.lock.Lock()
+	if d.cancel != nil {
+		d.cancel()
+		d.cancel = nil
+	}
+	d.pending = nil
+	d.lock.Unlock()
+	d.Ref.Stop()
+}
@@ -0,0 +1,166 @@
+package coroutine
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// A timer driven by a single goroutine shared by every coroutine, rather than each coroutine owning its own runtime
+// timers. This keeps the cost of a coroutine that never waits on a timer down to this small struct, no matter how
+// many coroutines are started.
+//
+// A sharedTimer must only be waited on by one goroutine at a time, which is always the coroutine that owns it.
+type sharedTimer struct {
+	// Receives the time when the timer fires. Buffered so the shared goroutine never blocks on a slow coroutine.
+	c     chan time.Time
+	when  time.Time
+	index int
+}
+
+func newSharedTimer() *sharedTimer {
+	return &sharedTimer{
+		c:     make(chan time.Time, 1),
+		index: -1,
+	}
+}
+
+func (t *sharedTimer) Chan() <-chan time.Time {
+	return t.c
+}
+
+// Sets the timer to fire once d has passed, replacing anything it was previously set to. Any fire from a previous use
+// that was never received is discarded.
+func (t *sharedTimer) Reset(d time.Duration) {
+	timers.reset(t, time.Now().Add(d))
+}
+
+// Prevents the timer from firing. Returns false if it has already fired or was never set.
+func (t *sharedTimer) Stop() bool {
+	return timers.stop(t)
+}
+
+// The queue of every pending sharedTimer, ordered by when they fire. The goroutine driving it is only started the
+// first time a timer is used.
+type timerQueue struct {
+	lock  sync.Mutex
+	heap  timerHeap
+	wake  chan struct{}
+	start sync.Once
+}
+
+var timers = timerQueue{
+	wake: make(chan struct{}, 1),
+}
+
+func (q *timerQueue) reset(t *sharedTimer, when time.Time) {
+	q.start.Do(func() {
+		go q.run()
+	})
+
+	q.lock.Lock()
+	if t.index >= 0 {
+		heap.Remove(&q.heap, t.index)
+	}
+	// Timers only ever fire while the lock is held, so nothing can sneak a value in between draining and pushing.
+	select {
+	case <-t.c:
+	default:
+	}
+	t.when = when
+	heap.Push(&q.heap, t)
+	earliest := t.index == 0
+	q.lock.Unlock()
+
+	// If this timer is now the first to fire, the driving goroutine needs to recalculate how long it sleeps.
+	if earliest {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *timerQueue) stop(t *sharedTimer) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&q.heap, t.index)
+	return true
+}
+
+func (q *timerQueue) run() {
+	// The one runtime timer backing every sharedTimer.
+	rt := time.NewTimer(time.Hour)
+	for {
+		q.lock.Lock()
+		now := time.Now()
+		for len(q.heap) > 0 && !q.heap[0].when.After(now) {
+			t := heap.Pop(&q.heap).(*sharedTimer)
+			select {
+			case t.c <- now:
+			default:
+			}
+		}
+		sleep := time.Hour
+		if len(q.heap) > 0 {
+			sleep = q.heap[0].when.Sub(now)
+		}
+		q.lock.Unlock()
+
+		resetTimer(rt, sleep)
+		select {
+		case <-rt.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// Instead of constructing a new Timer object, which can be expensive if done frequently, we reset an existing one
+// to a given duration immediately before using it.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		// I've seen cases where Stop returns false, but there isn't an item in the channel, causing the receive to
+		// hang forever.
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// Min-heap of timers by the time they fire, for use with container/heap.
+type timerHeap []*sharedTimer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].when.Before(h[j].when)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*sharedTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
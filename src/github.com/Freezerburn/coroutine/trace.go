@@ -0,0 +1,122 @@
+package coroutine
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// What happened to a coroutine in a TraceEvent.
+type TraceKind int
+
+const (
+	// A message was put into the coroutine's mailbox.
+	TraceSend TraceKind = iota
+	// The coroutine took a message out of its mailbox.
+	TraceRecv
+	// The coroutine started a Pause.
+	TracePause
+	// The coroutine finished running, either by returning or by being stopped.
+	TraceStop
+	// The coroutine panicked with something other than Stop. The panic continues after the event is traced.
+	TracePanic
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceSend:
+		return "send"
+	case TraceRecv:
+		return "recv"
+	case TracePause:
+		return "pause"
+	case TraceStop:
+		return "stop"
+	case TracePanic:
+		return "panic"
+	}
+	return fmt.Sprintf("TraceKind(%d)", int(k))
+}
+
+// A single event in the life of a coroutine, passed to the Tracer set with SetTracer.
+type TraceEvent struct {
+	Kind TraceKind
+	Time time.Time
+	Id   uint64
+	Name string
+	// The message that was sent or received for TraceSend and TraceRecv, or the value passed to panic for TracePanic.
+	Value interface{}
+	// How long the coroutine asked to pause for, for TracePause.
+	Duration time.Duration
+}
+
+// The dynamic type of the event's Value, such as "string" or "*main.Request".
+func (ev TraceEvent) ValueType() string {
+	return fmt.Sprintf("%T", ev.Value)
+}
+
+func (ev TraceEvent) String() string {
+	switch ev.Kind {
+	case TraceSend, TraceRecv:
+		return fmt.Sprintf("coroutine [%v / %s] %v %s: %v", ev.Id, ev.Name, ev.Kind, ev.ValueType(), ev.Value)
+	case TracePause:
+		return fmt.Sprintf("coroutine [%v / %s] %v %v", ev.Id, ev.Name, ev.Kind, ev.Duration)
+	case TracePanic:
+		return fmt.Sprintf("coroutine [%v / %s] %v: %v", ev.Id, ev.Name, ev.Kind, ev.Value)
+	}
+	return fmt.Sprintf("coroutine [%v / %s] %v", ev.Id, ev.Name, ev.Kind)
+}
+
+// Receives every TraceEvent for every coroutine while it is set with SetTracer. Trace is called from whichever
+// goroutine caused the event, often many at once, so it must be safe for concurrent use. It should also be quick,
+// since the coroutine or sender causing the event waits for it.
+type Tracer interface {
+	Trace(ev TraceEvent)
+}
+
+// Adapts a plain function into a Tracer.
+type TracerFunc func(ev TraceEvent)
+
+func (f TracerFunc) Trace(ev TraceEvent) {
+	f(ev)
+}
+
+// A Tracer that writes every event to the given logger, or the standard logger if it's nil.
+func LogTracer(l *log.Logger) Tracer {
+	return TracerFunc(func(ev TraceEvent) {
+		if l == nil {
+			log.Print(ev)
+		} else {
+			l.Print(ev)
+		}
+	})
+}
+
+// Wraps the Tracer so that atomic.Value always stores the same concrete type.
+type tracerHolder struct {
+	t Tracer
+}
+
+var tracer atomic.Value
+
+// Starts sending every TraceEvent from every coroutine to the given Tracer. Passing nil turns tracing back off, which
+// is the default. When tracing is off, the cost is a single atomic load per event.
+func SetTracer(t Tracer) {
+	tracer.Store(tracerHolder{t})
+}
+
+func (e *Embeddable) trace(kind TraceKind, v interface{}, d time.Duration) {
+	h, _ := tracer.Load().(tracerHolder)
+	if h.t == nil {
+		return
+	}
+	h.t.Trace(TraceEvent{
+		Kind:     kind,
+		Time:     time.Now(),
+		Id:       e.id,
+		Name:     e.name,
+		Value:    v,
+		Duration: d,
+	})
+}
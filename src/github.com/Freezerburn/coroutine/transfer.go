@@ -0,0 +1,138 @@
+package coroutine
+
+// A value handed to a coroutine by Transfer, waiting for it to call Transfer or AwaitTransfer.
+type transfer struct {
+	from Ref
+	v    interface{}
+}
+
+// The key a coroutine waiting in Transfer registers its exit hook under on the coroutine it handed control to.
+type transferWatch struct {
+	waiter *Embeddable
+}
+
+// Implemented by Embeddable for coroutines that take turns with another through Transfer.
+type Transferer interface {
+	Transfer(to Ref, v interface{}) (interface{}, error)
+	AwaitTransfer() (interface{}, Ref)
+}
+
+var _ Transferer = (*Embeddable)(nil)
+
+// Hands control to the coroutine to, along with v, and waits for control to come back: classic symmetric coroutines,
+// for co-simulations and parsers where two sides take turns, rather than each handling messages whenever they arrive.
+// to gets v from its own Transfer or AwaitTransfer, and returns whatever is handed back, by to or by any other
+// coroutine that transfers to this one. Values transferred to a coroutine that isn't waiting for one are kept, in
+// order, until it is. Its mailbox is left alone meanwhile, so messages sent to it wait there as usual.
+//
+// Fails with ErrNotRunning if to has finished, or finishes before control comes back, and with ErrRemoteUnsupported
+// if to isn't a coroutine in this process. Transferring to itself returns v straight away.
+//
+// If this coroutine has been stopped by external code using the Ref returned by all Start functions, then it will
+// immediately stop, and no further code outside of deferred functions will be executed in this coroutine.
+func (e *Embeddable) Transfer(to Ref, v interface{}) (interface{}, error) {
+	e.checkpoint()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+	er, ok := to.(*embeddableRef)
+	if !ok {
+		return nil, ErrRemoteUnsupported
+	}
+	if er.stale() {
+		return nil, ErrNotRunning
+	}
+	if er.e == e {
+		return v, nil
+	}
+
+	// Only ever touched by this coroutine's own goroutine, which is where system messages run.
+	ended := false
+	key := transferWatch{waiter: e}
+	registered := er.e.onExit(key, func() {
+		e.system(func(e *Embeddable) {
+			ended = true
+		})
+	})
+	if !registered {
+		return nil, ErrNotRunning
+	}
+	defer er.e.cancelOnExit(key)
+
+	self := e.ref()
+	er.e.system(func(to *Embeddable) {
+		x := to.extra()
+		x.transfers = append(x.transfers, transfer{from: self, v: v})
+	})
+	t, ok := e.awaitTransfer(func() bool {
+		return ended
+	})
+	if !ok {
+		return nil, ErrNotRunning
+	}
+	return t.v, nil
+}
+
+// Waits for another coroutine to hand control to this one with Transfer, and returns what it handed over along with
+// who handed it, for the side that starts off waiting for its turn. The one that transferred is then waiting for
+// control to come back, which is handed to it with Transfer.
+//
+// If this coroutine has been stopped by external code using the Ref returned by all Start functions, then it will
+// immediately stop, and no further code outside of deferred functions will be executed in this coroutine.
+func (e *Embeddable) AwaitTransfer() (interface{}, Ref) {
+	e.checkpoint()
+	if !e.isRunning() {
+		panic(Stop{})
+	}
+	t, _ := e.awaitTransfer(func() bool {
+		return false
+	})
+	return t.v, t.from
+}
+
+// Waits until a value has been transferred to this coroutine, and takes it, or returns false as soon as ended does.
+func (e *Embeddable) awaitTransfer(ended func() bool) (transfer, bool) {
+	x := e.extra()
+	if len(x.transfers) == 0 {
+		e.setState(StateWaiting)
+		for len(x.transfers) == 0 && !ended() {
+			// Transfers arrive as system messages, which wake it, so there's nothing else to wait for.
+			e.waitOnce(false, -1)
+			e.checkpoint()
+			if !e.isRunning() {
+				panic(Stop{})
+			}
+		}
+		e.setState(StateRunning)
+		e.touch()
+		if len(x.transfers) == 0 {
+			return transfer{}, false
+		}
+	}
+	t := x.transfers[0]
+	x.transfers[0] = transfer{}
+	x.transfers = x.transfers[1:]
+	return t, true
+}
+
+// What StartPair runs for each side, with the Ref of the other side. v is what was handed over by the first Transfer
+// to it, or nil for the side that goes first.
+type PairFunction func(c Coroutine, peer Ref, v interface{})
+
+// Starts two coroutines that take turns with Transfer. a runs first, while b waits for a to transfer to it, and each
+// is given the other's Ref. Once either returns, the other's Transfer fails with ErrNotRunning. The options apply to
+// both.
+func StartPair(a, b PairFunction, opts ...Option) (Ref, Ref) {
+	var ra, rb Ref
+	// Started paused so that neither runs before it knows the other's Ref.
+	ra = StartFuncNamePaused("Pair", func(c Coroutine) {
+		a(c, rb, nil)
+	}, opts...)
+	rb = StartFuncNamePaused("Pair", func(c Coroutine) {
+		v, _ := c.(Transferer).AwaitTransfer()
+		b(c, ra, v)
+	}, opts...)
+	Resume(rb)
+	Resume(ra)
+	return ra, rb
+}
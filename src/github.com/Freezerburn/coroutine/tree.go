@@ -0,0 +1,88 @@
+package coroutine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// A live coroutine along with its live children, as returned by Tree.
+type TreeNode struct {
+	Id       uint64      `json:"id"`
+	Name     string      `json:"name"`
+	State    string      `json:"state"`
+	Restarts uint64      `json:"restarts"`
+	Children []*TreeNode `json:"children,omitempty"`
+}
+
+// Arranges every live coroutine into trees by the parents given with WithParent. Returns the roots: coroutines with
+// no parent, or whose parent has already finished. Roots and children are both ordered by id.
+func Tree() []*TreeNode {
+	infos := List()
+	nodes := make(map[uint64]*TreeNode, len(infos))
+	for _, info := range infos {
+		nodes[info.Id] = &TreeNode{
+			Id:       info.Id,
+			Name:     info.Name,
+			State:    info.State.String(),
+			Restarts: info.Restarts,
+		}
+	}
+
+	var roots []*TreeNode
+	// List is ordered by id, so children are appended in id order too.
+	for _, info := range infos {
+		node := nodes[info.Id]
+		if parent, ok := nodes[info.Parent]; ok && info.Parent != info.Id {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].Id < roots[j].Id
+	})
+	return roots
+}
+
+// Writes the output of Tree as JSON.
+func WriteTreeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Tree())
+}
+
+// Writes the output of Tree as a Graphviz DOT digraph, with an edge from every parent to each of its children.
+func WriteTreeDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph coroutines {"); err != nil {
+		return err
+	}
+	var write func(n *TreeNode) error
+	write = func(n *TreeNode) error {
+		label := fmt.Sprintf("%s\n#%v %s", n.Name, n.Id, n.State)
+		if n.Restarts > 0 {
+			label += fmt.Sprintf(" (%v restarts)", n.Restarts)
+		}
+		if _, err := fmt.Fprintf(w, "\tc%v [label=%s];\n", n.Id, strconv.Quote(label)); err != nil {
+			return err
+		}
+		for _, child := range n.Children {
+			if _, err := fmt.Fprintf(w, "\tc%v -> c%v;\n", n.Id, child.Id); err != nil {
+				return err
+			}
+			if err := write(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, root := range Tree() {
+		if err := write(root); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
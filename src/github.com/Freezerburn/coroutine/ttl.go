@@ -0,0 +1,28 @@
+package coroutine
+
+import (
+	"time"
+)
+
+// Sends v to the coroutine r references, but only if it gets to it within ttl. If v is still waiting in the mailbox
+// once ttl has passed, by the coroutine's clock, the coroutine skips it, and it goes to the dead letter handler with
+// DeadLetterExpired instead. Stops commands that have been stuck behind a backlog from being carried out long after
+// they stopped making sense. A ttl <= 0 never expires, the same as Send.
+//
+// Anything that isn't a coroutine started by this package is sent v with its own Send, without a ttl.
+func SendWithTTL(r Ref, v interface{}, ttl time.Duration) {
+	if er, ok := localRef(r); ok {
+		er.e.deliver(mail{v: v, ttl: ttl})
+		return
+	}
+	r.Send(v)
+}
+
+// Whether m was sent with a ttl that ran out while it was waiting in the mailbox, in which case it's dead-lettered.
+func (e *Embeddable) expired(m mail) bool {
+	if m.ttl <= 0 || e.clock.Now().Sub(m.at) < m.ttl {
+		return false
+	}
+	deadLetter(DeadLetter{Reason: DeadLetterExpired, Value: m.v, To: e.ref(), From: m.from})
+	return true
+}
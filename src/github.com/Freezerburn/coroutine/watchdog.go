@@ -0,0 +1,145 @@
+package coroutine
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// A coroutine that the watchdog found running its own code for too long without calling any of its Coroutine
+// methods, passed to the callback given to StartWatchdog.
+type Stall struct {
+	Id   uint64
+	Name string
+	// How long it has been since the coroutine last called one of its methods.
+	Since time.Duration
+	// The current stack of the goroutine the coroutine runs on, in the format used by runtime.Stack. Empty if it
+	// couldn't be found, which can happen if the coroutine finished while the watchdog was looking at it.
+	Stack []byte
+}
+
+// Starts watching every coroutine for stalls: coroutines that haven't called any of their Coroutine methods (Recv,
+// Pause, Now, and so on) for longer than interval while running their own code. This catches coroutines stuck in
+// blocking syscalls, or in loops that never give Stop a chance to take effect. Waiting in Recv or Pause doesn't
+// count as stalling, no matter how long it lasts.
+//
+// onStall is called from the watchdog's goroutine, once for each stall. A coroutine that recovers and later stalls
+// again is reported again. Coroutines run by a Scheduler are skipped, since they only run when stepped. The
+// interval is measured in real time, regardless of any Clock. The returned CancelFunc stops the watchdog.
+//
+// Getting a goroutine's stack means collecting the stacks of every goroutine, which stops the world briefly, so an
+// interval shorter than a second or so is best avoided outside of tests.
+func StartWatchdog(interval time.Duration, onStall func(s Stall)) CancelFunc {
+	quit := make(chan struct{})
+	go func() {
+		// The lastCall of each coroutine as of its most recent report, so each stall is only reported once.
+		reported := make(map[uint64]int64)
+		// Checking twice per interval bounds how late a stall can be noticed.
+		check := interval / 2
+		if check <= 0 {
+			check = interval
+		}
+		ticker := time.NewTicker(check)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+
+			now := time.Now()
+			var stalled []*Embeddable
+			var since []time.Duration
+			live := make(map[uint64]bool)
+			for _, e := range liveCoroutines() {
+				live[e.id] = true
+				if e.sched != nil || State(atomic.LoadInt32(&e.state)) != StateRunning {
+					continue
+				}
+				last := atomic.LoadInt64(&e.lastCall)
+				d := now.Sub(time.Unix(0, last))
+				if d < interval || reported[e.id] == last {
+					continue
+				}
+				reported[e.id] = last
+				stalled = append(stalled, e)
+				since = append(since, d)
+			}
+			for id := range reported {
+				if !live[id] {
+					delete(reported, id)
+				}
+			}
+			if len(stalled) == 0 {
+				continue
+			}
+
+			stacks := allStacks()
+			for i, e := range stalled {
+				onStall(Stall{
+					Id:    e.id,
+					Name:  e.name,
+					Since: since[i],
+					Stack: goroutineStack(stacks, atomic.LoadUint64(&e.goid)),
+				})
+			}
+		}
+	}()
+
+	var stopped int32
+	return func() {
+		if atomic.CompareAndSwapInt32(&stopped, 0, 1) {
+			close(quit)
+		}
+	}
+}
+
+// Marks that the coroutine has just called one of its own methods, so it isn't stalled. This is also where system
+// messages are handled, so every method call gives them a chance to run ahead of anything in the mailbox.
+func (e *Embeddable) checkpoint() {
+	atomic.StoreInt64(&e.lastCall, time.Now().UnixNano())
+	e.handleSystem()
+	e.waitResumed()
+}
+
+// The stacks of every goroutine, as written by runtime.Stack.
+func allStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// Picks the stack of a single goroutine out of the output of allStacks.
+func goroutineStack(stacks []byte, id uint64) []byte {
+	if id == 0 {
+		return nil
+	}
+	header := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, stack := range bytes.Split(stacks, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, header) {
+			return stack
+		}
+	}
+	return nil
+}
+
+// The id of the calling goroutine. The runtime doesn't expose this directly, but it's always the first thing in the
+// header of the goroutine's own stack.
+func currentGoroutineId() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i >= 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
@@ -0,0 +1,283 @@
+package coroutine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A single step of a Workflow. Do performs the step. Compensate, if set, undoes it, and is only ever called after Do
+// succeeded. Both run inside a coroutine, so they can Pause, Retry or Call other coroutines.
+type WorkflowStep struct {
+	Name       string
+	Do         func(c Coroutine) error
+	Compensate func(c Coroutine) error
+}
+
+// Where a run of a Workflow has got to, as reported in WorkflowProgress.
+type WorkflowState int
+
+const (
+	// Running its steps.
+	WorkflowRunning WorkflowState = iota
+	// A step failed or the run was stopped, and the steps that completed are being compensated.
+	WorkflowCompensating
+	// Every step completed.
+	WorkflowSucceeded
+	// A step failed or the run was stopped, and every completed step was compensated.
+	WorkflowCompensated
+	// A step failed or the run was stopped, and compensating at least one completed step failed too.
+	WorkflowFailed
+)
+
+func (s WorkflowState) String() string {
+	switch s {
+	case WorkflowRunning:
+		return "running"
+	case WorkflowCompensating:
+		return "compensating"
+	case WorkflowSucceeded:
+		return "succeeded"
+	case WorkflowCompensated:
+		return "compensated"
+	case WorkflowFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("WorkflowState(%d)", int(s))
+}
+
+// How far a run of a Workflow has got, as saved to a WorkflowStore after every step and every compensation.
+type WorkflowProgress struct {
+	State WorkflowState
+	// How many steps have completed. While compensating, how many are still to be compensated.
+	Completed int
+	// The step that failed and why, once one has. Step is empty if the run was stopped instead.
+	Step  string
+	Error string
+	// Why any compensations failed.
+	CompensationErrors []string
+}
+
+// Where a Workflow keeps the progress of each of its runs, by run id, so that a run that was interrupted can pick up
+// where it left off. Implementations backed by a database or file make that possible across restarts of the program.
+type WorkflowStore interface {
+	// Returns false if nothing has been saved for the id.
+	Load(id string) (WorkflowProgress, bool, error)
+	Save(id string, p WorkflowProgress) error
+}
+
+// A WorkflowStore that keeps progress in memory, which is what a Workflow uses if it isn't given a store. Progress
+// outlives each run but not the program. Safe for concurrent use.
+type MemoryWorkflowStore struct {
+	lock     sync.Mutex
+	progress map[string]WorkflowProgress
+}
+
+func NewMemoryWorkflowStore() *MemoryWorkflowStore {
+	return &MemoryWorkflowStore{progress: make(map[string]WorkflowProgress)}
+}
+
+func (m *MemoryWorkflowStore) Load(id string) (WorkflowProgress, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	p, ok := m.progress[id]
+	p.CompensationErrors = append([]string(nil), p.CompensationErrors...)
+	return p, ok, nil
+}
+
+func (m *MemoryWorkflowStore) Save(id string, p WorkflowProgress) error {
+	m.lock.Lock()
+	p.CompensationErrors = append([]string(nil), p.CompensationErrors...)
+	m.progress[id] = p
+	m.lock.Unlock()
+	return nil
+}
+
+// What a run of a Workflow fails with when it didn't complete.
+type WorkflowError struct {
+	// The step that failed, or empty if the run was stopped instead.
+	Step string
+	// Why the step failed, or ErrNotRunning if the run was stopped.
+	Err error
+	// Why any compensations failed. Empty if every completed step was compensated.
+	Compensation []error
+}
+
+func (e *WorkflowError) Error() string {
+	var b strings.Builder
+	if e.Step != "" {
+		fmt.Fprintf(&b, "coroutine: workflow step %q failed: %v", e.Step, e.Err)
+	} else {
+		fmt.Fprintf(&b, "coroutine: workflow stopped: %v", e.Err)
+	}
+	for _, err := range e.Compensation {
+		fmt.Fprintf(&b, "; compensation failed: %v", err)
+	}
+	return b.String()
+}
+
+func (e *WorkflowError) Unwrap() error {
+	return e.Err
+}
+
+// A sequence of steps run one after another in a coroutine, like a saga: if a step fails, or the run is stopped, the
+// steps that already completed are compensated in reverse order. Progress is saved to a WorkflowStore after every
+// step and every compensation, so a run started again with the same id carries on from where it got to. A Workflow
+// can be run any number of times, and is safe for concurrent use.
+type Workflow struct {
+	name  string
+	steps []WorkflowStep
+	store WorkflowStore
+}
+
+// Creates a Workflow that runs the given steps in order, saving its progress to store, or to a MemoryWorkflowStore
+// if store is nil.
+func NewWorkflow(name string, store WorkflowStore, steps ...WorkflowStep) *Workflow {
+	if store == nil {
+		store = NewMemoryWorkflowStore()
+	}
+	return &Workflow{name: name, steps: append([]WorkflowStep(nil), steps...), store: store}
+}
+
+// Starts a run of the workflow with the given id, in a coroutine started with the given options. If progress has
+// already been saved for the id, the run carries on from there: skipping steps that completed, finishing off
+// compensation, or doing nothing at all if the run already finished.
+//
+// The returned Future resolves with nil once every step has completed, or with a *WorkflowError once the run has
+// failed and been compensated. A step that panics is logged, and fails the run the same way as returning an error
+// with the panic in it, so the coroutine returns rather than panicking.
+//
+// Stopping the returned Ref stops the run as soon as the step being run calls one of the coroutine's methods, or
+// before the next step if it doesn't call any, and compensates the steps that completed, in a coroutine of its own
+// since the one running the steps has stopped. A step that was interrupted part way through isn't compensated, since
+// it never completed, so a step that can be stopped should undo what it has done itself, such as with a deferred
+// function.
+func (w *Workflow) Start(id string, opts ...Option) (Ref, *Future) {
+	f := newFuture()
+	r := StartFuncName("Workflow "+w.name, func(c Coroutine) {
+		w.run(c, id, f, opts)
+	}, opts...)
+	return r, f
+}
+
+func (w *Workflow) run(c Coroutine, id string, f *Future, opts []Option) {
+	p, ok, err := w.store.Load(id)
+	if err != nil {
+		f.resolve(nil, err)
+		return
+	}
+	if !ok {
+		p = WorkflowProgress{State: WorkflowRunning}
+	}
+	switch p.State {
+	case WorkflowSucceeded:
+		f.resolve(nil, nil)
+		return
+	case WorkflowCompensated, WorkflowFailed:
+		f.resolve(nil, progressError(p))
+		return
+	case WorkflowCompensating:
+		w.compensate(id, p, f, opts)
+		return
+	}
+
+	// The step being run, for a panic to be blamed on.
+	var current string
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		p.State = WorkflowCompensating
+		_, stopped := r.(Stop)
+		if stopped {
+			p.Error = ErrNotRunning.Error()
+		} else {
+			// Treated as the step failing, rather than passed on to take the whole program down before the run has
+			// been compensated.
+			c.Logger().Error("Workflow step panicked.", "workflow", w.name, "run", id, "step", current, "panic", r)
+			p.Step = current
+			p.Error = fmt.Sprintf("panic: %v", r)
+		}
+		w.save(c, id, p)
+		w.compensate(id, p, f, opts)
+		if stopped {
+			panic(Stop{})
+		}
+	}()
+
+	for p.Completed < len(w.steps) {
+		step := w.steps[p.Completed]
+		current = step.Name
+		if err := step.Do(c); err != nil {
+			p.State = WorkflowCompensating
+			p.Step = step.Name
+			p.Error = err.Error()
+			w.save(c, id, p)
+			w.compensate(id, p, f, opts)
+			return
+		}
+		p.Completed++
+		if err := w.save(c, id, p); err != nil {
+			// The step is done, but there's no record of it, so it can't be relied on. Undo it along with the rest.
+			p.State = WorkflowCompensating
+			p.Step = step.Name
+			p.Error = err.Error()
+			w.compensate(id, p, f, opts)
+			return
+		}
+	}
+	p.State = WorkflowSucceeded
+	w.save(c, id, p)
+	f.resolve(nil, nil)
+}
+
+// Starts the coroutine that compensates every completed step of a run that has failed, most recent first, then
+// resolves f.
+func (w *Workflow) compensate(id string, p WorkflowProgress, f *Future, opts []Option) {
+	StartFuncName("Workflow "+w.name+" compensation", func(c Coroutine) {
+		defer func() {
+			// Only reached without resolving f if this coroutine was stopped. The progress saved so far lets a later
+			// Start with the same id finish the job.
+			f.resolve(nil, progressError(p))
+		}()
+
+		for p.Completed > 0 {
+			step := w.steps[p.Completed-1]
+			if step.Compensate != nil {
+				if err := step.Compensate(c); err != nil {
+					p.CompensationErrors = append(p.CompensationErrors, fmt.Sprintf("%s: %v", step.Name, err))
+				}
+			}
+			p.Completed--
+			w.save(c, id, p)
+		}
+		p.State = WorkflowCompensated
+		if len(p.CompensationErrors) > 0 {
+			p.State = WorkflowFailed
+		}
+		w.save(c, id, p)
+	}, opts...)
+}
+
+// Saves the run's progress, logging any error along with returning it.
+func (w *Workflow) save(c Coroutine, id string, p WorkflowProgress) error {
+	err := w.store.Save(id, p)
+	if err != nil {
+		c.Logger().Error("Failed to save workflow progress.", "workflow", w.name, "run", id, "error", err)
+	}
+	return err
+}
+
+// The error a run with the given progress fails with.
+func progressError(p WorkflowProgress) error {
+	err := &WorkflowError{Step: p.Step, Err: errors.New(p.Error)}
+	if p.Step == "" && p.Error == ErrNotRunning.Error() {
+		err.Err = ErrNotRunning
+	}
+	for _, e := range p.CompensationErrors {
+		err.Compensation = append(err.Compensation, errors.New(e))
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package coroutine
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkflowStepPanicCompensates(t *testing.T) {
+	var lock sync.Mutex
+	var compensated []string
+	record := func(name string) func(c Coroutine) error {
+		return func(c Coroutine) error {
+			lock.Lock()
+			compensated = append(compensated, name)
+			lock.Unlock()
+			return nil
+		}
+	}
+
+	store := NewMemoryWorkflowStore()
+	w := NewWorkflow("panics", store,
+		WorkflowStep{Name: "one", Do: func(c Coroutine) error { return nil }, Compensate: record("one")},
+		WorkflowStep{Name: "two", Do: func(c Coroutine) error { panic("boom") }, Compensate: record("two")},
+		WorkflowStep{Name: "three", Do: func(c Coroutine) error { return nil }, Compensate: record("three")},
+	)
+	_, f := w.Start("run")
+
+	_, err := f.Await(time.Second)
+	var werr *WorkflowError
+	if !errors.As(err, &werr) {
+		t.Fatalf("expected a *WorkflowError, got %v", err)
+	}
+	if werr.Step != "two" || !strings.Contains(werr.Err.Error(), "boom") {
+		t.Errorf("expected step two to fail with the panic, got step %q: %v", werr.Step, werr.Err)
+	}
+
+	lock.Lock()
+	if len(compensated) != 1 || compensated[0] != "one" {
+		t.Errorf("expected only step one to be compensated, got %v", compensated)
+	}
+	lock.Unlock()
+
+	p, ok, _ := store.Load("run")
+	if !ok || p.State != WorkflowCompensated || p.Completed != 0 {
+		t.Errorf("expected the run to be saved as compensated, got %+v", p)
+	}
+}
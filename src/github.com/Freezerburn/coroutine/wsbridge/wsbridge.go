@@ -0,0 +1,235 @@
+// Runs each WebSocket connection as a coroutine, for chat and game servers that keep a coroutine per player: every
+// message read from the socket lands in the coroutine's mailbox, everything sent to its Out is written to the socket,
+// the connection is kept alive with pings, and the socket is closed as soon as the coroutine finishes. The package
+// doesn't depend on a WebSocket library. It works with a Conn, which *websocket.Conn from github.com/gorilla/websocket
+// already is:
+//
+//	func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+//		conn, err := s.upgrader.Upgrade(w, req, nil)
+//		if err != nil {
+//			return
+//		}
+//		s.hub.Send(Joined{Player: wsbridge.Start(conn, 30*time.Second, s.player)})
+//	}
+package wsbridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Freezerburn/coroutine"
+)
+
+// What an Out's Err returns once the socket has been closed because the coroutine finished.
+var ErrClosed = errors.New("wsbridge: connection closed")
+
+// The types of WebSocket message, as defined by RFC 6455 and used by Conn.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// The status sent in the close message once the coroutine finishes, meaning the connection did what it was for.
+const closeNormal = 1000
+
+// How long writing the close message, and each ping, may take before the connection is given up on.
+const controlTimeout = time.Second
+
+// What Start needs from a WebSocket connection. *websocket.Conn from github.com/gorilla/websocket already has these
+// methods. WriteControl and Close may be called at the same time as the others; the rest are only called from one
+// goroutine at a time.
+type Conn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+// A message read from the socket, which is either a TextMessage or a BinaryMessage.
+type Message struct {
+	Type int
+	Data []byte
+}
+
+// Decodes Data with the coroutine package's Codec, for messages written by an Out, or by anything else that encodes
+// them the same way.
+func (m Message) Decode() (interface{}, error) {
+	return coroutine.CurrentCodec().Decode(m.Data)
+}
+
+// The message the coroutine receives once reading from the socket fails, because the other side closed it, it
+// stopped answering pings, or the connection broke. Nothing more can be read, and the coroutine should finish.
+type Closed struct {
+	Err error
+}
+
+// What Start runs as a coroutine for each connection, with the Out that writes to it.
+type Handler func(c coroutine.Coroutine, out *Out)
+
+// Starts h as a coroutine for the connection, and returns the Ref to it, for the rest of the program to send to.
+// Every message read from the socket is sent to the coroutine as a Message, and once reading fails it's sent a Closed.
+// When the coroutine finishes, however it finishes, a close message is sent and the socket is closed.
+//
+// If keepalive isn't zero, the other side is pinged every keepalive, and the connection counts as broken once nothing,
+// not even the answer to a ping, has arrived for twice that. The Conn's pong handler is replaced to do this.
+func Start(conn Conn, keepalive time.Duration, h Handler, opts ...coroutine.Option) coroutine.Ref {
+	out := &Out{conn: conn}
+	r := coroutine.StartFuncName("wsbridge", func(c coroutine.Coroutine) {
+		h(c, out)
+	}, opts...)
+
+	w := &watch{conn: conn, out: out, stop: make(chan struct{})}
+	if keepalive > 0 {
+		conn.SetReadDeadline(time.Now().Add(2 * keepalive))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * keepalive))
+		})
+		go w.ping(keepalive)
+	}
+	go w.read(r, keepalive)
+
+	// Closes the socket from a coroutine of its own, which is told once the connection's coroutine has finished.
+	coroutine.StartFuncName("wsbridge close", func(c coroutine.Coroutine) {
+		c.(coroutine.Linker).Monitor(r)
+		for {
+			if _, ok := c.Recv().(coroutine.Exit); ok {
+				w.close()
+				return
+			}
+		}
+	})
+	return r
+}
+
+// Everything that runs alongside a connection's coroutine.
+type watch struct {
+	conn Conn
+	out  *Out
+	once sync.Once
+	// Closed once the socket has been closed because the coroutine finished, so nothing is sent to it any more.
+	stop chan struct{}
+}
+
+func (w *watch) read(r coroutine.Ref, keepalive time.Duration) {
+	for {
+		t, data, err := w.conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-w.stop:
+			default:
+				r.Send(Closed{Err: err})
+			}
+			return
+		}
+		if keepalive > 0 {
+			w.conn.SetReadDeadline(time.Now().Add(2 * keepalive))
+		}
+		r.Send(Message{Type: t, Data: data})
+	}
+}
+
+func (w *watch) ping(keepalive time.Duration) {
+	t := time.NewTicker(keepalive)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := w.conn.WriteControl(PingMessage, nil, time.Now().Add(controlTimeout)); err != nil {
+				// Reading fails too once the other side has been quiet for long enough, which tells the coroutine.
+				return
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Sends the close message and closes the socket.
+func (w *watch) close() {
+	w.once.Do(func() {
+		close(w.stop)
+		w.conn.WriteControl(CloseMessage, binary.BigEndian.AppendUint16(nil, closeNormal),
+			time.Now().Add(controlTimeout))
+		w.conn.Close()
+		w.out.fail(ErrClosed)
+	})
+}
+
+// A coroutine.Ref for writing to a connection: Send writes a string as a TextMessage, a []byte as a BinaryMessage,
+// and a Message as whatever type it has. Anything else is encoded with the coroutine package's Codec and written as a
+// BinaryMessage. Anything that takes a Ref, such as the subscribers of an EventBus, can write to the socket through
+// one. Safe for concurrent use, unlike the Conn itself.
+//
+// Nothing can report a failed write to the caller of Send, so the first error writing fails with is kept for Err, and
+// everything sent after it is dropped. Name is "wsbridge" and Id is always 0. Stop does nothing.
+type Out struct {
+	conn Conn
+	lock sync.Mutex
+	err  error
+}
+
+var _ coroutine.Ref = (*Out)(nil)
+
+// The first error writing to the socket failed with, or nil if it hasn't. ErrClosed if nothing had failed by the time
+// the coroutine finished.
+func (o *Out) Err() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.err
+}
+
+func (o *Out) fail(err error) {
+	o.lock.Lock()
+	if o.err == nil {
+		o.err = err
+	}
+	o.lock.Unlock()
+}
+
+func (o *Out) Send(v interface{}) {
+	t, data := BinaryMessage, []byte(nil)
+	var err error
+	switch v := v.(type) {
+	case string:
+		t, data = TextMessage, []byte(v)
+	case []byte:
+		data = v
+	case Message:
+		t, data = v.Type, v.Data
+	default:
+		data, err = coroutine.CurrentCodec().Encode(v)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.err != nil {
+		return
+	}
+	if err == nil {
+		err = o.conn.WriteMessage(t, data)
+	}
+	o.err = err
+}
+
+// False once writing has failed or the socket has been closed.
+func (o *Out) Running() bool {
+	return o.Err() == nil
+}
+
+func (o *Out) Name() string {
+	return "wsbridge"
+}
+
+func (o *Out) Id() uint64 {
+	return 0
+}
+
+func (o *Out) Stop() {
+}